@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	alertStatusPending   = "pending"
+	alertStatusDelivered = "delivered"
+	alertStatusFailed    = "failed"
+
+	maxWebhookAttempts    = 5
+	maxWebhookBackoff     = time.Minute
+	maxDeliveryConcurrent = 4
+	// discordContentLimit is Discord's maximum message length.
+	discordContentLimit = 2000
+	// pendingFiringsPage is how many pending firings resumePending reads
+	// per query.
+	pendingFiringsPage = 500
+)
+
+// errWebhookAddressBlocked is returned when a webhook host is not on the
+// allow-list or resolves to an address inside the API's own network.
+var errWebhookAddressBlocked = errors.New("webhook address not allowed")
+
+// sharedAddressSpace (RFC 6598) is carrier-grade NAT space that netip does
+// not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// alertEngine evaluates enabled rules whenever the cache replaces a
+// timeframe's snapshot and delivers new firings to the rules' webhooks.
+type alertEngine struct {
+	store   *alertStore
+	logger  *slog.Logger
+	client  *http.Client
+	backoff time.Duration
+	// allowedHosts restricts webhook hosts when non-empty
+	// (ALERT_WEBHOOK_ALLOWED_HOSTS).
+	allowedHosts []string
+
+	refreshes  chan alertRefresh
+	deliveries chan struct{}
+	// delivering tracks deliver goroutines so run can wait for their last
+	// store writes before the state database is closed.
+	delivering sync.WaitGroup
+}
+
+type alertRefresh struct {
+	timeframe string
+	snapshot  marketSnapshot
+}
+
+// alertMatch is a symbol whose metric crossed a rule's threshold on the
+// snapshot being evaluated.
+type alertMatch struct {
+	symbol   string
+	value    float64
+	candleTS int64
+}
+
+func newAlertEngine(store *alertStore, logger *slog.Logger, allowedHosts []string) *alertEngine {
+	return &alertEngine{
+		store:        store,
+		logger:       logger,
+		client:       newWebhookClient(allowedHosts),
+		backoff:      time.Second,
+		allowedHosts: allowedHosts,
+		refreshes:    make(chan alertRefresh, 16),
+		deliveries:   make(chan struct{}, maxDeliveryConcurrent),
+	}
+}
+
+// newWebhookClient returns the client webhooks are posted with. Without an
+// allow-list every connection, redirects included, is checked after DNS
+// resolution, so a public name pointing at a private address is refused too.
+// Allow-listed hosts are trusted, which is how internal relays are reached.
+func newWebhookClient(allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if len(allowedHosts) == 0 {
+		dialer.Control = rejectNonPublicAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect to the webhook on our behalf, past the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !webhookHostAllowed(req.URL, allowedHosts) {
+				return fmt.Errorf("%w: redirect to %s", errWebhookAddressBlocked, req.URL.Hostname())
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+func rejectNonPublicAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddressBlocked, ip)
+	}
+	return nil
+}
+
+// isPublicAddr rejects loopback, link-local, private, shared, multicast and
+// unspecified addresses.
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// webhookHostAllowed reports whether u's host is on the allow-list. An empty
+// list allows every host.
+func webhookHostAllowed(u *url.URL, allowedHosts []string) bool {
+	return len(allowedHosts) == 0 || contains(allowedHosts, strings.ToLower(u.Hostname()))
+}
+
+// notify is registered as a cache refresh listener. It never blocks the
+// refresh: when the queue is full the refresh is dropped and the next one
+// carries newer data anyway.
+func (e *alertEngine) notify(timeframe string, snapshot marketSnapshot) {
+	select {
+	case e.refreshes <- alertRefresh{timeframe: timeframe, snapshot: snapshot}:
+	default:
+		e.logger.Warn("alert evaluation queue full; skipped refresh", "timeframe", timeframe)
+	}
+}
+
+func (e *alertEngine) run(ctx context.Context) {
+	defer e.delivering.Wait()
+	e.resumePending(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case refresh := <-e.refreshes:
+			e.evaluate(ctx, refresh.timeframe, refresh.snapshot)
+		}
+	}
+}
+
+// resumePending retries deliveries that were still pending when the process
+// stopped. Their firings are already recorded, so they are not re-evaluated.
+func (e *alertEngine) resumePending(ctx context.Context) {
+	byRule := make(map[int64][]alertFiring)
+	var afterID int64
+	for {
+		page, err := e.store.pendingFirings(ctx, afterID, pendingFiringsPage)
+		if err != nil {
+			e.logger.Error("alert pending firings query failed", "err", err)
+			return
+		}
+		for _, f := range page {
+			byRule[f.RuleID] = append(byRule[f.RuleID], f)
+		}
+		if len(page) < pendingFiringsPage {
+			break
+		}
+		afterID = page[len(page)-1].ID
+	}
+	for ruleID, firings := range byRule {
+		rule, err := e.store.getRule(ctx, ruleID)
+		if err != nil {
+			e.logger.Warn("alert rule for pending firings unavailable", "rule", ruleID, "err", err)
+			if err := e.store.finishDelivery(ctx, firings, alertStatusFailed, 0, "rule unavailable", nil); err != nil {
+				e.logger.Error("alert firing update failed", "rule", ruleID, "err", err)
+			}
+			continue
+		}
+		e.logger.Info("resuming pending alert deliveries", "rule", ruleID, "firings", len(firings))
+		e.delivering.Go(func() { e.deliver(ctx, rule, firings) })
+	}
+}
+
+func (e *alertEngine) evaluate(ctx context.Context, timeframe string, snapshot marketSnapshot) {
+	rules, err := e.store.listRules(ctx)
+	if err != nil {
+		e.logger.Error("alert rules query failed", "err", err)
+		return
+	}
+	stepMS, err := timeframeStepMS(timeframe)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Timeframe != timeframe {
+			continue
+		}
+		matches := evaluateAlertRule(rule, snapshot, stepMS)
+		firings := make([]alertFiring, 0, len(matches))
+		for _, m := range matches {
+			last, err := e.store.lastFiredAt(ctx, rule.ID, m.symbol)
+			if err != nil {
+				e.logger.Error("alert cooldown query failed", "rule", rule.ID, "symbol", m.symbol, "err", err)
+				continue
+			}
+			if last > 0 && now.UnixMilli()-last < int64(rule.CooldownSeconds)*1000 {
+				continue
+			}
+			f := alertFiring{RuleID: rule.ID, Symbol: m.symbol, Value: round4(m.value), CandleTS: m.candleTS, FiredAt: now.UnixMilli()}
+			inserted, err := e.store.insertFiring(ctx, &f)
+			if err != nil {
+				e.logger.Error("alert firing insert failed", "rule", rule.ID, "symbol", m.symbol, "err", err)
+				continue
+			}
+			if inserted {
+				firings = append(firings, f)
+			}
+		}
+		if len(firings) > 0 {
+			e.logger.Info("alert fired", "rule", rule.ID, "timeframe", timeframe, "symbols", len(firings))
+			e.delivering.Go(func() { e.deliver(ctx, rule, firings) })
+		}
+	}
+}
+
+// evaluateAlertRule returns the symbols matching rule on snapshot, strongest
+// first. Symbols whose newest candle lags the snapshot (delisted or halted)
+// are skipped so that stale data cannot fire.
+func evaluateAlertRule(rule alertRule, snapshot marketSnapshot, stepMS int64) []alertMatch {
+	matches := make([]alertMatch, 0)
+	for symbol, candles := range snapshot.seriesBySymbol {
+		if len(rule.Symbols) > 0 && !contains(rule.Symbols, symbol) {
+			continue
+		}
+		if len(candles) == 0 || candles[0].TS < snapshot.newestTS-stepMS {
+			continue
+		}
+		value, ok := computeVolatilityMetric(candles, rule.Metric, rule.Bars)
+		if !ok || !alertThresholdCrossed(rule, value) {
+			continue
+		}
+		matches = append(matches, alertMatch{symbol: symbol, value: value, candleTS: candles[0].TS})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if math.Abs(matches[i].value) == math.Abs(matches[j].value) {
+			return matches[i].symbol < matches[j].symbol
+		}
+		return math.Abs(matches[i].value) > math.Abs(matches[j].value)
+	})
+	return matches
+}
+
+func alertThresholdCrossed(rule alertRule, value float64) bool {
+	switch rule.Direction {
+	case "down":
+		return value <= -rule.Threshold
+	case "both":
+		return math.Abs(value) >= rule.Threshold
+	default:
+		return value >= rule.Threshold
+	}
+}
+
+// deliver posts one message per rule and refresh. Network errors, 5xx, 408
+// and 429 are retried with exponential backoff; other 4xx responses are
+// permanent. The outcome is stored on every firing in the batch.
+func (e *alertEngine) deliver(ctx context.Context, rule alertRule, firings []alertFiring) {
+	select {
+	case e.deliveries <- struct{}{}:
+		defer func() { <-e.deliveries }()
+	case <-ctx.Done():
+		return
+	}
+
+	payload, err := buildWebhookPayload(rule, firings)
+	if err != nil {
+		e.logger.Error("alert payload build failed", "rule", rule.ID, "err", err)
+		return
+	}
+
+	var lastErr error
+	attempts := 0
+	for attempts < maxWebhookAttempts {
+		attempts++
+		retryAfter, retryable, err := e.post(ctx, rule.WebhookURL, payload)
+		if err == nil {
+			deliveredAt := time.Now().UTC().UnixMilli()
+			if err := e.store.finishDelivery(ctx, firings, alertStatusDelivered, attempts, "", &deliveredAt); err != nil {
+				e.logger.Error("alert firing update failed", "rule", rule.ID, "err", err)
+			}
+			return
+		}
+		lastErr = err
+		if !retryable || attempts == maxWebhookAttempts {
+			break
+		}
+
+		wait := e.backoff << (attempts - 1)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > maxWebhookBackoff {
+			wait = maxWebhookBackoff
+		}
+		e.logger.Warn("alert webhook attempt failed, retrying", "rule", rule.ID, "attempt", attempts, "wait", wait.String(), "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// Leave the firings pending so the next start resumes them.
+			return
+		case <-timer.C:
+		}
+	}
+
+	e.logger.Error("alert webhook delivery failed", "rule", rule.ID, "attempts", attempts, "err", lastErr)
+	if err := e.store.finishDelivery(ctx, firings, alertStatusFailed, attempts, lastErr.Error(), nil); err != nil {
+		e.logger.Error("alert firing update failed", "rule", rule.ID, "err", err)
+	}
+}
+
+func (e *alertEngine) post(ctx context.Context, webhookURL string, payload []byte) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, false, err
+	}
+	// Rules created before the allow-list was set are refused here.
+	if !webhookHostAllowed(req.URL, e.allowedHosts) {
+		return 0, false, fmt.Errorf("%w: %s", errWebhookAddressBlocked, req.URL.Hostname())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, !errors.Is(err, errWebhookAddressBlocked), err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, false, nil
+	}
+	err = fmt.Errorf("webhook responded %d", resp.StatusCode)
+	var retryAfter time.Duration
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retryAfter, retryable, err
+}
+
+// buildWebhookPayload renders firings in the rule's webhook format: the
+// generic format is structured JSON, Slack and Discord get a text message.
+func buildWebhookPayload(rule alertRule, firings []alertFiring) ([]byte, error) {
+	switch rule.WebhookFormat {
+	case "slack":
+		return json.Marshal(map[string]string{"text": alertMessage(rule, firings, 0)})
+	case "discord":
+		return json.Marshal(map[string]string{"content": alertMessage(rule, firings, discordContentLimit)})
+	}
+
+	type genericRule struct {
+		ID        int64   `json:"id"`
+		Name      string  `json:"name"`
+		Metric    string  `json:"metric"`
+		Timeframe string  `json:"timeframe"`
+		Bars      int     `json:"bars"`
+		Threshold float64 `json:"threshold"`
+		Direction string  `json:"direction"`
+	}
+	type genericFiring struct {
+		ID       int64   `json:"id"`
+		Symbol   string  `json:"symbol"`
+		Value    float64 `json:"value"`
+		CandleTS int64   `json:"candle_ts"`
+		FiredAt  int64   `json:"fired_at"`
+	}
+	body := struct {
+		Rule    genericRule     `json:"rule"`
+		Firings []genericFiring `json:"firings"`
+	}{
+		Rule: genericRule{
+			ID: rule.ID, Name: rule.Name, Metric: rule.Metric, Timeframe: rule.Timeframe,
+			Bars: rule.Bars, Threshold: rule.Threshold, Direction: rule.Direction,
+		},
+		Firings: make([]genericFiring, 0, len(firings)),
+	}
+	for _, f := range firings {
+		body.Firings = append(body.Firings, genericFiring{ID: f.ID, Symbol: f.Symbol, Value: f.Value, CandleTS: f.CandleTS, FiredAt: f.FiredAt})
+	}
+	return json.Marshal(body)
+}
+
+// alertMessage is the chat text, one line per symbol. With a positive limit
+// trailing lines are replaced by a count so the message fits.
+func alertMessage(rule alertRule, firings []alertFiring, limit int) string {
+	comparator := map[string]string{"up": ">=", "down": "<= -", "both": "|x| >="}[rule.Direction]
+	header := fmt.Sprintf("[%s] %s %s(%d) %s %s%%", rule.Name, rule.Timeframe, rule.Metric, rule.Bars, comparator, strconv.FormatFloat(rule.Threshold, 'f', -1, 64))
+
+	lines := []string{header}
+	for i, f := range firings {
+		line := fmt.Sprintf("%s %+.2f%%", f.Symbol, f.Value)
+		if limit > 0 {
+			need := len(strings.Join(lines, "\n")) + len("\n"+line)
+			if rest := len(firings) - i - 1; rest > 0 {
+				need += len(fmt.Sprintf("\n… 他%d銘柄", rest))
+			}
+			if need > limit {
+				lines = append(lines, fmt.Sprintf("… 他%d銘柄", len(firings)-i))
+				break
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxAlertRuleSymbols   = 200
+	maxAlertCooldownHours = 7 * 24
+	maxAlertBodyBytes     = 16 << 10
+)
+
+var webhookFormats = []string{"generic", "slack", "discord"}
+
+// alertRuleInput is the body of POST/PUT /alerts/rules. Pointer fields
+// distinguish omitted values from zero values so defaults can be applied.
+type alertRuleInput struct {
+	Name            string   `json:"name"`
+	Metric          string   `json:"metric"`
+	Timeframe       string   `json:"timeframe"`
+	Bars            *int     `json:"bars"`
+	Threshold       float64  `json:"threshold"`
+	Direction       string   `json:"direction"`
+	Symbols         []string `json:"symbols"`
+	CooldownSeconds *int     `json:"cooldown_seconds"`
+	WebhookURL      string   `json:"webhook_url"`
+	WebhookFormat   string   `json:"webhook_format"`
+	Enabled         *bool    `json:"enabled"`
+}
+
+// alertStore persists rules and firings in the state database.
+type alertStore struct {
+	db *sql.DB
+}
+
+func (s *apiServer) alertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		writeAlertsDisabled(w)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := s.alerts.store.listRules(r.Context())
+		if err != nil {
+			s.writeQueryError(w, r, err, "alert rules query error")
+			return
+		}
+		writeData(w, r, http.StatusOK, alertRulesResponse{Count: len(rules), Data: rules})
+	case http.MethodPost:
+		rule, ok := s.decodeAlertRule(w, r)
+		if !ok {
+			return
+		}
+		created, err := s.alerts.store.createRule(r.Context(), rule)
+		if err != nil {
+			s.writeQueryError(w, r, err, "alert rule create error")
+			return
+		}
+		writeData(w, r, http.StatusCreated, created)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+	}
+}
+
+func (s *apiServer) alertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		writeAlertsDisabled(w)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_INPUT", "ルールIDは正の整数を指定してください")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rule, err := s.alerts.store.getRule(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeRuleNotFound(w, id)
+			return
+		}
+		if err != nil {
+			s.writeQueryError(w, r, err, "alert rule query error", "id", id)
+			return
+		}
+		writeData(w, r, http.StatusOK, rule)
+	case http.MethodPut:
+		rule, ok := s.decodeAlertRule(w, r)
+		if !ok {
+			return
+		}
+		rule.ID = id
+		updated, err := s.alerts.store.updateRule(r.Context(), rule)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeRuleNotFound(w, id)
+			return
+		}
+		if err != nil {
+			s.writeQueryError(w, r, err, "alert rule update error", "id", id)
+			return
+		}
+		writeData(w, r, http.StatusOK, updated)
+	case http.MethodDelete:
+		deleted, err := s.alerts.store.deleteRule(r.Context(), id)
+		if err != nil {
+			s.writeQueryError(w, r, err, "alert rule delete error", "id", id)
+			return
+		}
+		if !deleted {
+			writeRuleNotFound(w, id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+	}
+}
+
+func (s *apiServer) alertFiringsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		writeAlertsDisabled(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	var ruleID int64
+	if raw := strings.TrimSpace(q.Get("rule_id")); raw != "" {
+		var err error
+		ruleID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || ruleID <= 0 {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "rule_id は正の整数を指定してください")
+			return
+		}
+	}
+	status := strings.TrimSpace(q.Get("status"))
+	if status != "" && !contains([]string{alertStatusPending, alertStatusDelivered, alertStatusFailed}, status) {
+		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "status は pending/delivered/failed のいずれかを指定してください")
+		return
+	}
+	limit := 100
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > 500 {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください")
+			return
+		}
+	}
+
+	firings, err := s.alerts.store.listFirings(r.Context(), ruleID, status, limit)
+	if err != nil {
+		s.writeQueryError(w, r, err, "alert firings query error")
+		return
+	}
+	writeData(w, r, http.StatusOK, alertFiringsResponse{Count: len(firings), Data: firings})
+}
+
+func (s *apiServer) decodeAlertRule(w http.ResponseWriter, r *http.Request) (alertRule, bool) {
+	var in alertRuleInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAlertBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_BODY", fmt.Sprintf("リクエストボディが不正なJSONです: %v", err))
+		return alertRule{}, false
+	}
+	rule, perr := validateAlertRule(in, s.ohlcvHistoryLimit, s.alerts.allowedHosts)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return alertRule{}, false
+	}
+	return rule, true
+}
+
+func validateAlertRule(in alertRuleInput, historyLimit int, allowedHosts []string) (alertRule, *paramError) {
+	invalid := func(msg string) (alertRule, *paramError) {
+		return alertRule{}, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", msg}
+	}
+	rule := alertRule{
+		Name:            strings.TrimSpace(in.Name),
+		Metric:          metricChange,
+		Timeframe:       strings.TrimSpace(in.Timeframe),
+		Bars:            1,
+		Threshold:       in.Threshold,
+		Direction:       "up",
+		Symbols:         []string{},
+		CooldownSeconds: 3600,
+		WebhookURL:      strings.TrimSpace(in.WebhookURL),
+		WebhookFormat:   "generic",
+		Enabled:         true,
+	}
+
+	if rule.Name == "" || len([]rune(rule.Name)) > 100 {
+		return invalid("name は1〜100文字で指定してください")
+	}
+	if in.Metric != "" {
+		rule.Metric = in.Metric
+	}
+	if !contains(volatilityMetrics, rule.Metric) {
+		return invalid(fmt.Sprintf("metric は %s のいずれかを指定してください", strings.Join(volatilityMetrics, "/")))
+	}
+	if !contains(validTimeframes, rule.Timeframe) {
+		return alertRule{}, &paramError{http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", "))}
+	}
+	if in.Bars != nil {
+		rule.Bars = *in.Bars
+	}
+	if rule.Bars <= 0 || rule.Bars >= historyLimit {
+		return invalid(fmt.Sprintf("bars は1以上%d以下の整数を指定してください", historyLimit-1))
+	}
+	if math.IsNaN(rule.Threshold) || math.IsInf(rule.Threshold, 0) || rule.Threshold <= 0 {
+		return invalid("threshold は0より大きい有限の数値を指定してください")
+	}
+	if in.Direction != "" {
+		rule.Direction = in.Direction
+	}
+	if rule.Direction != "up" && rule.Direction != "down" && rule.Direction != "both" {
+		return invalid("direction は up/down/both のいずれかを指定してください")
+	}
+	if rule.Metric != metricChange && rule.Direction != "up" {
+		return invalid("change 以外の metric は常に正の値のため、direction には up のみ指定できます")
+	}
+
+	if len(in.Symbols) > maxAlertRuleSymbols {
+		return invalid(fmt.Sprintf("symbols は%d銘柄以内で指定してください", maxAlertRuleSymbols))
+	}
+	for _, raw := range in.Symbols {
+		symbol := strings.ToUpper(strings.TrimSpace(raw))
+		if !symbolRegex.MatchString(symbol) {
+			return alertRule{}, &paramError{http.StatusBadRequest, "INVALID_SYMBOL", fmt.Sprintf("symbols に不正な銘柄シンボルが含まれています: %s", raw)}
+		}
+		if !contains(rule.Symbols, symbol) {
+			rule.Symbols = append(rule.Symbols, symbol)
+		}
+	}
+
+	if in.CooldownSeconds != nil {
+		rule.CooldownSeconds = *in.CooldownSeconds
+	}
+	if rule.CooldownSeconds < 0 || rule.CooldownSeconds > maxAlertCooldownHours*3600 {
+		return invalid(fmt.Sprintf("cooldown_seconds は0以上%d以下の整数を指定してください", maxAlertCooldownHours*3600))
+	}
+
+	u, err := url.Parse(rule.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalid("webhook_url は http(s):// で始まるURLを指定してください")
+	}
+	if !webhookHostAllowed(u, allowedHosts) {
+		return invalid("webhook_url のホストが ALERT_WEBHOOK_ALLOWED_HOSTS に含まれていません")
+	}
+	// Names are checked again after resolution when the webhook is posted.
+	if len(allowedHosts) == 0 {
+		ip, err := netip.ParseAddr(u.Hostname())
+		if (err == nil && !isPublicAddr(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+			return invalid("webhook_url にループバック・リンクローカル・プライベートアドレスは指定できません")
+		}
+	}
+	if in.WebhookFormat != "" {
+		rule.WebhookFormat = in.WebhookFormat
+	}
+	if !contains(webhookFormats, rule.WebhookFormat) {
+		return invalid("webhook_format は generic/slack/discord のいずれかを指定してください")
+	}
+	if in.Enabled != nil {
+		rule.Enabled = *in.Enabled
+	}
+	return rule, nil
+}
+
+func writeAlertsDisabled(w http.ResponseWriter) {
+	writeError(w, http.StatusServiceUnavailable, "ALERTS_DISABLED", "アラート機能は無効です (API_STATE_DB_PATH を設定してください)")
+}
+
+func writeRuleNotFound(w http.ResponseWriter, id int64) {
+	writeError(w, http.StatusNotFound, "RULE_NOT_FOUND", fmt.Sprintf("ルール %d は存在しません", id))
+}
+
+const alertRuleColumns = `id, name, metric, timeframe, bars, threshold, direction, symbols, cooldown_seconds, webhook_url, webhook_format, enabled, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAlertRule(row rowScanner) (alertRule, error) {
+	var rule alertRule
+	var symbols string
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Metric, &rule.Timeframe, &rule.Bars, &rule.Threshold, &rule.Direction,
+		&symbols, &rule.CooldownSeconds, &rule.WebhookURL, &rule.WebhookFormat, &rule.Enabled, &rule.CreatedAt, &rule.UpdatedAt)
+	rule.Symbols = []string{}
+	if symbols != "" {
+		rule.Symbols = strings.Split(symbols, ",")
+	}
+	return rule, err
+}
+
+func (st *alertStore) listRules(ctx context.Context) ([]alertRule, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]alertRule, 0)
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (st *alertStore) getRule(ctx context.Context, id int64) (alertRule, error) {
+	return scanAlertRule(st.db.QueryRowContext(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = ?`, id))
+}
+
+func (st *alertStore) createRule(ctx context.Context, rule alertRule) (alertRule, error) {
+	now := time.Now().UTC().UnixMilli()
+	res, err := st.db.ExecContext(ctx, `
+		INSERT INTO alert_rules (name, metric, timeframe, bars, threshold, direction, symbols, cooldown_seconds, webhook_url, webhook_format, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.Name, rule.Metric, rule.Timeframe, rule.Bars, rule.Threshold, rule.Direction, strings.Join(rule.Symbols, ","),
+		rule.CooldownSeconds, rule.WebhookURL, rule.WebhookFormat, rule.Enabled, now, now)
+	if err != nil {
+		return alertRule{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return alertRule{}, err
+	}
+	return st.getRule(ctx, id)
+}
+
+// updateRule replaces every field of an existing rule. It returns
+// sql.ErrNoRows when the rule does not exist.
+func (st *alertStore) updateRule(ctx context.Context, rule alertRule) (alertRule, error) {
+	res, err := st.db.ExecContext(ctx, `
+		UPDATE alert_rules SET
+			name = ?, metric = ?, timeframe = ?, bars = ?, threshold = ?, direction = ?, symbols = ?,
+			cooldown_seconds = ?, webhook_url = ?, webhook_format = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, rule.Name, rule.Metric, rule.Timeframe, rule.Bars, rule.Threshold, rule.Direction, strings.Join(rule.Symbols, ","),
+		rule.CooldownSeconds, rule.WebhookURL, rule.WebhookFormat, rule.Enabled, time.Now().UTC().UnixMilli(), rule.ID)
+	if err != nil {
+		return alertRule{}, err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return alertRule{}, err
+	} else if affected == 0 {
+		return alertRule{}, sql.ErrNoRows
+	}
+	return st.getRule(ctx, rule.ID)
+}
+
+// deleteRule removes the rule. Its firings are kept as delivery history.
+func (st *alertStore) deleteRule(ctx context.Context, id int64) (bool, error) {
+	res, err := st.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// lastFiredAt returns when the rule last fired for symbol, or 0.
+func (st *alertStore) lastFiredAt(ctx context.Context, ruleID int64, symbol string) (int64, error) {
+	var firedAt sql.NullInt64
+	err := st.db.QueryRowContext(ctx, `SELECT MAX(fired_at) FROM alert_firings WHERE rule_id = ? AND symbol = ?`, ruleID, symbol).Scan(&firedAt)
+	return firedAt.Int64, err
+}
+
+// insertFiring records a pending firing. It reports false when the rule has
+// already fired for this symbol and candle, which keeps restarts and
+// overlapping refreshes from firing twice.
+func (st *alertStore) insertFiring(ctx context.Context, f *alertFiring) (bool, error) {
+	res, err := st.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO alert_firings (rule_id, symbol, value, candle_ts, fired_at, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, f.RuleID, f.Symbol, f.Value, f.CandleTS, f.FiredAt, alertStatusPending)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	f.ID, err = res.LastInsertId()
+	f.Status = alertStatusPending
+	return err == nil, err
+}
+
+func (st *alertStore) finishDelivery(ctx context.Context, firings []alertFiring, status string, attempts int, lastErr string, deliveredAt *int64) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, f := range firings {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE alert_firings SET status = ?, attempts = attempts + ?, last_error = ?, delivered_at = ?
+			WHERE id = ?
+		`, status, attempts, lastErr, deliveredAt, f.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (st *alertStore) listFirings(ctx context.Context, ruleID int64, status string, limit int) ([]alertFiring, error) {
+	rows, err := st.db.QueryContext(ctx, `
+		SELECT id, rule_id, symbol, value, candle_ts, fired_at, status, attempts, last_error, delivered_at
+		FROM alert_firings
+		WHERE (? = 0 OR rule_id = ?) AND (? = '' OR status = ?)
+		ORDER BY id DESC
+		LIMIT ?
+	`, ruleID, ruleID, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFirings(rows)
+}
+
+// pendingFirings returns up to limit pending firings with an id above
+// afterID, oldest first, so callers can page through all of them.
+func (st *alertStore) pendingFirings(ctx context.Context, afterID int64, limit int) ([]alertFiring, error) {
+	rows, err := st.db.QueryContext(ctx, `
+		SELECT id, rule_id, symbol, value, candle_ts, fired_at, status, attempts, last_error, delivered_at
+		FROM alert_firings
+		WHERE status = ? AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, alertStatusPending, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFirings(rows)
+}
+
+func scanFirings(rows *sql.Rows) ([]alertFiring, error) {
+	defer rows.Close()
+
+	firings := make([]alertFiring, 0)
+	for rows.Next() {
+		var f alertFiring
+		var deliveredAt sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.RuleID, &f.Symbol, &f.Value, &f.CandleTS, &f.FiredAt, &f.Status, &f.Attempts, &f.LastError, &deliveredAt); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			f.DeliveredAt = &deliveredAt.Int64
+		}
+		firings = append(firings, f)
+	}
+	return firings, rows.Err()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvaluateAlertRuleAppliesDirectionFilterAndStaleness(t *testing.T) {
+	snapshot := marketSnapshot{
+		newestTS: 300,
+		seriesBySymbol: map[string][]marketCandle{
+			"AAAUSDT":   {{TS: 300, Close: 110}, {TS: 200, Close: 100}},
+			"BBBUSDT":   {{TS: 300, Close: 90}, {TS: 200, Close: 100}},
+			"CCCUSDT":   {{TS: 300, Close: 102}, {TS: 200, Close: 100}},
+			"STALEUSDT": {{TS: 100, Close: 200}, {TS: 0, Close: 100}},
+		},
+	}
+	rule := alertRule{Metric: metricChange, Bars: 1, Threshold: 5, Direction: "both"}
+
+	matches := evaluateAlertRule(rule, snapshot, 100)
+	if len(matches) != 2 || matches[0].symbol != "AAAUSDT" || matches[1].symbol != "BBBUSDT" {
+		t.Fatalf("both: matches = %+v, want AAAUSDT then BBBUSDT", matches)
+	}
+
+	rule.Direction = "down"
+	if matches := evaluateAlertRule(rule, snapshot, 100); len(matches) != 1 || matches[0].symbol != "BBBUSDT" {
+		t.Fatalf("down: matches = %+v, want BBBUSDT", matches)
+	}
+
+	rule.Direction = "up"
+	rule.Symbols = []string{"BBBUSDT", "CCCUSDT"}
+	if matches := evaluateAlertRule(rule, snapshot, 100); len(matches) != 0 {
+		t.Fatalf("filtered up: matches = %+v, want none", matches)
+	}
+}
+
+func TestAlertDeliveryRetriesAndPersistsOutcome(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"text"`) {
+			t.Errorf("slack payload missing text field: %s", body)
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	db, err := openStateDB(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("openStateDB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	store := &alertStore{db: db}
+	rule, err := store.createRule(ctx, alertRule{
+		Name: "movers", Metric: metricChange, Timeframe: "1h", Bars: 1, Threshold: 5, Direction: "up",
+		CooldownSeconds: 3600, WebhookURL: server.URL, WebhookFormat: "slack", Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("createRule: %v", err)
+	}
+
+	firing := alertFiring{RuleID: rule.ID, Symbol: "AAAUSDT", Value: 6.5, CandleTS: 300, FiredAt: time.Now().UnixMilli()}
+	if inserted, err := store.insertFiring(ctx, &firing); err != nil || !inserted {
+		t.Fatalf("insertFiring = (%v, %v), want inserted", inserted, err)
+	}
+	duplicate := firing
+	if inserted, err := store.insertFiring(ctx, &duplicate); err != nil || inserted {
+		t.Fatalf("duplicate insertFiring = (%v, %v), want ignored", inserted, err)
+	}
+
+	engine := newAlertEngine(store, slog.New(slog.NewJSONHandler(io.Discard, nil)), []string{"127.0.0.1"})
+	engine.backoff = time.Millisecond
+	engine.deliver(ctx, rule, []alertFiring{firing})
+
+	firings, err := store.listFirings(ctx, rule.ID, "", 10)
+	if err != nil {
+		t.Fatalf("listFirings: %v", err)
+	}
+	if len(firings) != 1 || firings[0].Status != alertStatusDelivered || firings[0].Attempts != 2 || firings[0].DeliveredAt == nil {
+		t.Fatalf("firings = %+v, want one delivered firing after 2 attempts", firings)
+	}
+}
+
+func TestAlertMessageFitsDiscordLimit(t *testing.T) {
+	rule := alertRule{Name: "movers", Metric: metricChange, Timeframe: "5m", Bars: 3, Threshold: 2, Direction: "both"}
+	firings := make([]alertFiring, 300)
+	for i := range firings {
+		firings[i] = alertFiring{Symbol: "SYMBOL" + strings.Repeat("X", 10), Value: 3}
+	}
+
+	msg := alertMessage(rule, firings, discordContentLimit)
+	if len(msg) > discordContentLimit {
+		t.Fatalf("message length = %d, want <= %d", len(msg), discordContentLimit)
+	}
+	if !strings.Contains(msg, "銘柄") {
+		t.Fatalf("truncated message should report remaining symbols: %q", msg[len(msg)-40:])
+	}
+}
+
+func TestValidateAlertRuleWebhookHosts(t *testing.T) {
+	cases := []struct {
+		url     string
+		allowed []string
+		ok      bool
+	}{
+		{"https://hooks.slack.com/services/X", nil, true},
+		{"http://127.0.0.1:8080/hook", nil, false},
+		{"http://localhost/hook", nil, false},
+		{"http://169.254.169.254/latest/meta-data", nil, false},
+		{"http://10.0.0.5/hook", nil, false},
+		{"http://[::1]/hook", nil, false},
+		{"http://[::ffff:192.168.1.1]/hook", nil, false},
+		{"http://100.64.0.1/hook", nil, false},
+		{"http://relay.internal/hook", []string{"relay.internal"}, true},
+		{"http://10.0.0.5/hook", []string{"10.0.0.5"}, true},
+		{"https://hooks.slack.com/services/X", []string{"relay.internal"}, false},
+	}
+	for _, tc := range cases {
+		in := alertRuleInput{Name: "r", Timeframe: "1h", Threshold: 5, WebhookURL: tc.url}
+		if _, perr := validateAlertRule(in, 100, tc.allowed); (perr == nil) != tc.ok {
+			t.Errorf("%s (allowed %v) accepted=%v, want %v", tc.url, tc.allowed, perr == nil, tc.ok)
+		}
+	}
+}
+
+func TestWebhookPostRefusesPrivateAddresses(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	engine := newAlertEngine(nil, slog.New(slog.NewJSONHandler(io.Discard, nil)), nil)
+	_, retryable, err := engine.post(context.Background(), server.URL, []byte(`{}`))
+	if !errors.Is(err, errWebhookAddressBlocked) || retryable || calls.Load() != 0 {
+		t.Fatalf("post to loopback = retryable %v, %v, %d calls", retryable, err, calls.Load())
+	}
+
+	engine = newAlertEngine(nil, slog.New(slog.NewJSONHandler(io.Discard, nil)), []string{"relay.internal"})
+	if _, retryable, err := engine.post(context.Background(), server.URL, []byte(`{}`)); !errors.Is(err, errWebhookAddressBlocked) || retryable {
+		t.Fatalf("post outside the allow-list = retryable %v, %v", retryable, err)
+	}
+}
+
+func TestPendingFiringsPagesInIDOrder(t *testing.T) {
+	db, err := openStateDB(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	store := &alertStore{db: db}
+	for i, symbol := range []string{"AAAUSDT", "BBBUSDT", "CCCUSDT", "DDDUSDT", "EEEUSDT"} {
+		f := alertFiring{RuleID: 1, Symbol: symbol, CandleTS: 300, FiredAt: int64(i)}
+		if _, err := store.insertFiring(ctx, &f); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			if err := store.finishDelivery(ctx, []alertFiring{f}, alertStatusDelivered, 1, "", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var symbols []string
+	var afterID int64
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("paging did not terminate")
+		}
+		page, err := store.pendingFirings(ctx, afterID, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range page {
+			symbols = append(symbols, f.Symbol)
+		}
+		if len(page) < 2 {
+			break
+		}
+		afterID = page[len(page)-1].ID
+	}
+	if strings.Join(symbols, ",") != "AAAUSDT,BBBUSDT,DDDUSDT,EEEUSDT" {
+		t.Fatalf("pending firings = %v", symbols)
+	}
+}
+
+func TestAlertEngineRunWaitsForDeliveries(t *testing.T) {
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		close(received)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	db, err := openStateDB(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := &alertStore{db: db}
+	rule, err := store.createRule(context.Background(), alertRule{
+		Name: "movers", Metric: metricChange, Timeframe: "1h", Bars: 1, Threshold: 5, Direction: "up",
+		CooldownSeconds: 3600, WebhookURL: server.URL, WebhookFormat: "slack", Enabled: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	firing := alertFiring{RuleID: rule.ID, Symbol: "AAAUSDT", Value: 6.5, CandleTS: 300, FiredAt: time.Now().UnixMilli()}
+	if _, err := store.insertFiring(context.Background(), &firing); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := newAlertEngine(store, slog.New(slog.NewJSONHandler(io.Discard, nil)), []string{"127.0.0.1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		engine.run(ctx)
+		close(done)
+	}()
+	<-received
+	cancel()
+	<-done
+	// The resumed delivery holds a concurrency slot until it returns.
+	if n := len(engine.deliveries); n != 0 {
+		t.Fatalf("run returned with %d deliveries in flight", n)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// parseAsOf reads the optional as_of parameter; 0 means "now". A candle
+// belongs to as_of when its open time is at or before it.
+func parseAsOf(q url.Values, now time.Time) (int64, *paramError) {
+	raw := strings.TrimSpace(q.Get("as_of"))
+	if raw == "" {
+		return 0, nil
+	}
+	ms, err := parseTimeParam(raw)
+	if err != nil || ms <= 0 {
+		return 0, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "as_of はミリ秒のUNIX時刻またはRFC3339形式で指定してください"}
+	}
+	if ms > now.UnixMilli() {
+		return 0, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "as_of には現在以前の時刻を指定してください"}
+	}
+	return ms, nil
+}
+
+// asOfRangeError reports an as_of whose bars reach past the oldest candle the
+// database retains. earliestMS is the first as_of that has enough history.
+type asOfRangeError struct {
+	earliestMS int64
+}
+
+func (e *asOfRangeError) Error() string {
+	return fmt.Sprintf("as_of is older than the retained history (earliest %d)", e.earliestMS)
+}
+
+// snapshotAt returns live as it looked at asOfMS. When the cache still holds
+// the newest bars candles before asOfMS it is trimmed in memory; otherwise
+// the series are read from the database, limited to symbols when given. An
+// asOfRangeError is returned when the database no longer has those bars.
+func (s *apiServer) snapshotAt(ctx context.Context, live marketSnapshot, timeframe string, asOfMS int64, bars int, symbols []string) (marketSnapshot, error) {
+	if asOfMS == 0 || asOfMS >= live.newestTS {
+		return live, nil
+	}
+	stepMS, err := timeframeStepMS(timeframe)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	reach := int64(maxInt(bars-1, 0)) * stepMS
+	if len(live.seriesBySymbol) > 0 && asOfMS-reach >= retainedFrom(live.seriesBySymbol, symbols, s.marketCache.historyLimit) {
+		return trimSnapshot(live, asOfMS), nil
+	}
+
+	ctx, span := tracer.Start(ctx, "cache.loadAsOf")
+	defer span.End()
+	stored, err := s.marketCache.storedFrom(ctx, timeframe, symbols)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	if stored > asOfMS-reach {
+		return marketSnapshot{}, &asOfRangeError{earliestMS: stored + reach}
+	}
+	snapshot, err := s.marketCache.loadAsOf(ctx, timeframe, asOfMS, symbols)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	snapshot.refreshedAt = live.refreshedAt
+	snapshot.dataVersion = live.dataVersion
+	snapshot.versionUpdatedAt = live.versionUpdatedAt
+	return snapshot, nil
+}
+
+// trimSnapshot drops candles opened after asOfMS. Series are shared with
+// snapshot, which is never mutated.
+func trimSnapshot(snapshot marketSnapshot, asOfMS int64) marketSnapshot {
+	seriesBySymbol := make(map[string][]marketCandle, len(snapshot.seriesBySymbol))
+	for symbol, candles := range snapshot.seriesBySymbol {
+		i := 0
+		for i < len(candles) && candles[i].TS > asOfMS {
+			i++
+		}
+		if i < len(candles) {
+			seriesBySymbol[symbol] = candles[i:]
+		}
+	}
+	trimmed := snapshot
+	trimmed.seriesBySymbol = seriesBySymbol
+	trimmed.newestTS = newestCandleTS(seriesBySymbol)
+	return trimmed
+}
+
+// retainedFrom is the open time from which every series holding limit
+// candles, and so possibly cut short, is complete, looking only at symbols
+// when given. Shorter series hold the symbol's whole history and a symbol
+// that stopped updating keeps old rows, so neither decides coverage. 0 means
+// no series is cut short.
+func retainedFrom(seriesBySymbol map[string][]marketCandle, symbols []string, limit int) int64 {
+	var from int64
+	check := func(candles []marketCandle) {
+		if len(candles) > 0 && len(candles) >= limit {
+			from = max(from, candles[len(candles)-1].TS)
+		}
+	}
+	if len(symbols) > 0 {
+		for _, symbol := range symbols {
+			check(seriesBySymbol[symbol])
+		}
+		return from
+	}
+	for _, candles := range seriesBySymbol {
+		check(candles)
+	}
+	return from
+}
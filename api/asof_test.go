@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAsOf(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	cases := []struct {
+		raw     string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1699999999000", 1_699_999_999_000, false},
+		{"2023-11-14T22:13:20Z", 1_700_000_000_000, false},
+		{"1700000000001", 0, true},
+		{"yesterday", 0, true},
+	}
+	for _, tc := range cases {
+		got, perr := parseAsOf(url.Values{"as_of": {tc.raw}}, now)
+		if (perr != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseAsOf(%q) = %d, %v; want %d, err=%v", tc.raw, got, perr, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestSnapshotAtTrimsCacheOrFallsBackToDatabase(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE ohlcv_1h (symbol TEXT, timestamp INTEGER, open REAL, high REAL, low REAL, close REAL, volume REAL, turnover REAL)`); err != nil {
+		t.Fatal(err)
+	}
+	const step = int64(3_600_000)
+	for i := int64(1); i <= 10; i++ {
+		if _, err := db.Exec(`INSERT INTO ohlcv_1h VALUES ('BTCUSDT', ?, 1, 1, 1, ?, 1, 1)`, i*step, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &apiServer{marketCache: newMarketDataCache(context.Background(), db, 3, time.Second, time.Minute, 0)}
+	live := marketSnapshot{
+		seriesBySymbol: map[string][]marketCandle{"BTCUSDT": {{TS: 10 * step, Close: 10}, {TS: 9 * step, Close: 9}, {TS: 8 * step, Close: 8}}},
+		newestTS:       10 * step,
+		dataVersion:    7,
+	}
+
+	trimmed, err := s.snapshotAt(context.Background(), live, "1h", 9*step, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trimmed.seriesBySymbol["BTCUSDT"]; len(got) != 2 || got[0].Close != 9 || trimmed.newestTS != 9*step {
+		t.Fatalf("trimmed = %+v, newestTS %d", got, trimmed.newestTS)
+	}
+	if len(live.seriesBySymbol["BTCUSDT"]) != 3 {
+		t.Fatal("live snapshot was mutated")
+	}
+
+	loaded, err := s.snapshotAt(context.Background(), live, "1h", 5*step+1, 2, []string{"BTCUSDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.seriesBySymbol["BTCUSDT"]
+	if len(got) != 3 || got[0].TS != 5*step || got[2].TS != 3*step || loaded.dataVersion != 7 {
+		t.Fatalf("loaded = %+v, dataVersion %d", got, loaded.dataVersion)
+	}
+
+	if _, err := s.snapshotAt(context.Background(), live, "1h", 3*step, 3, nil); err != nil {
+		t.Fatalf("as_of at the oldest retained window: %v", err)
+	}
+	_, err = s.snapshotAt(context.Background(), live, "1h", 3*step-1, 3, nil)
+	var rangeErr *asOfRangeError
+	if !errors.As(err, &rangeErr) || rangeErr.earliestMS != 3*step {
+		t.Fatalf("as_of past retention = %v, want asOfRangeError at %d", err, 3*step)
+	}
+	rec := httptest.NewRecorder()
+	s.writeQueryError(rec, httptest.NewRequest(http.MethodGet, "/volatility", nil), err, "volatility query error")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "INSUFFICIENT_HISTORY") || !strings.Contains(rec.Body.String(), "1970-01-01T03:00:00Z") {
+		t.Fatalf("as_of past retention response = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSnapshotAtChecksCoveragePerSymbol(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE ohlcv_1h (symbol TEXT, timestamp INTEGER, open REAL, high REAL, low REAL, close REAL, volume REAL, turnover REAL)`); err != nil {
+		t.Fatal(err)
+	}
+	const step = int64(3_600_000)
+	series := func(ts ...int64) []marketCandle {
+		candles := make([]marketCandle, len(ts))
+		for i, v := range ts {
+			candles[i] = marketCandle{TS: v * step}
+		}
+		return candles
+	}
+	// STALEUSDT stopped updating long ago and NEWUSDT was just listed; the
+	// database retains the same rows as the cache.
+	live := marketSnapshot{
+		seriesBySymbol: map[string][]marketCandle{
+			"BTCUSDT":   series(10, 9, 8),
+			"STALEUSDT": series(3, 2, 1),
+			"NEWUSDT":   series(10),
+		},
+		newestTS: 10 * step,
+	}
+	for symbol, candles := range live.seriesBySymbol {
+		for _, c := range candles {
+			if _, err := db.Exec(`INSERT INTO ohlcv_1h VALUES (?, ?, 1, 1, 1, 1, 1, 1)`, symbol, c.TS); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	s := &apiServer{marketCache: newMarketDataCache(context.Background(), db, 3, time.Second, time.Minute, 0)}
+
+	trimmed, err := s.snapshotAt(context.Background(), live, "1h", 9*step, 2, nil)
+	if err != nil || len(trimmed.seriesBySymbol["BTCUSDT"]) != 2 {
+		t.Fatalf("as_of within every series = %+v, %v", trimmed.seriesBySymbol, err)
+	}
+	_, err = s.snapshotAt(context.Background(), live, "1h", 9*step, 3, nil)
+	var rangeErr *asOfRangeError
+	if !errors.As(err, &rangeErr) || rangeErr.earliestMS != 10*step {
+		t.Fatalf("as_of past BTCUSDT's history = %v, want asOfRangeError at %d", err, 10*step)
+	}
+	if _, err := s.snapshotAt(context.Background(), live, "1h", 2*step, 2, []string{"STALEUSDT"}); err != nil {
+		t.Fatalf("as_of within the requested symbol's history: %v", err)
+	}
+}
+
+func TestAsOfReadsOnlyTheBarsIndicatorsNeed(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE ohlcv_1h (symbol TEXT, timestamp INTEGER, open REAL, high REAL, low REAL, close REAL, volume REAL, turnover REAL)`); err != nil {
+		t.Fatal(err)
+	}
+	const step = int64(3_600_000)
+	// The database holds exactly historyLimit rows, as the fetcher keeps.
+	for i := int64(1); i <= 10; i++ {
+		if _, err := db.Exec(`INSERT INTO ohlcv_1h VALUES ('BTCUSDT', ?, 1, ?, 1, ?, 1, 1)`, i*step, float64(i+1), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	c := newMarketDataCache(ctx, db, 10, time.Minute, time.Hour, 0)
+	if _, err := c.refreshSnapshot(ctx, "1h", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	s := &apiServer{marketCache: c, ohlcvHistoryLimit: 10, screenMaxCost: 1_000_000}
+
+	indicators := func(asOf int64) int {
+		rec := httptest.NewRecorder()
+		s.indicatorsHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/indicators?symbol=BTCUSDT&timeframe=1h&set=rsi:3,ema:3,macd:2:3:2&as_of=%d", asOf), nil))
+		return rec.Code
+	}
+	if code := indicators(7 * step); code != http.StatusOK {
+		t.Fatalf("indicators three bars back = %d", code)
+	}
+	if code := indicators(3 * step); code != http.StatusUnprocessableEntity {
+		t.Fatalf("indicators without rsi:3's four bars = %d, want 422", code)
+	}
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"filter": "rsi(1h, 3) >= 0 and ema(1h, 3) > 0"}`)
+	s.screenHandler(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/screen?as_of=%d", 7*step), body))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "BTCUSDT") {
+		t.Fatalf("screen three bars back = %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scopeRead        = "read"
+	scopeStream      = "stream"
+	scopeAlertsAdmin = "alerts-admin"
+
+	defaultKeyRatePerMinute = 600
+	defaultKeyBurst         = 60
+)
+
+var apiKeyScopes = []string{scopeRead, scopeStream, scopeAlertsAdmin}
+
+// publicPatterns are served without a key: the root (which also catches the
+// Swagger UI and spec paths) and the readiness probe.
+var publicPatterns = []string{"/", "/readyz"}
+
+// apiKeyConfig is one entry of API_KEYS_FILE. Keys are normally stored as
+// key_sha256 (hex SHA-256 of the key); a plain key is accepted for local use.
+type apiKeyConfig struct {
+	Name          string   `json:"name"`
+	Key           string   `json:"key"`
+	KeySHA256     string   `json:"key_sha256"`
+	Scopes        []string `json:"scopes"`
+	RatePerMinute int      `json:"rate_per_minute"`
+	Burst         int      `json:"burst"`
+}
+
+type apiKey struct {
+	name   string
+	scopes []string
+	burst  int
+	bucket *tokenBucket
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyName returns the name of the key that authenticated the request, or
+// "" when auth is disabled or the path is public.
+func apiKeyName(ctx context.Context) string {
+	name, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return name
+}
+
+// loadAPIKeys reads the key store and indexes it by key hash.
+func loadAPIKeys(path string) (map[string]*apiKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var file struct {
+		Keys []apiKeyConfig `json:"keys"`
+	}
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	keys := make(map[string]*apiKey, len(file.Keys))
+	names := make(map[string]bool, len(file.Keys))
+	for i, cfg := range file.Keys {
+		if cfg.Name == "" || names[cfg.Name] {
+			return nil, fmt.Errorf("key %d: name must be unique and non-empty", i)
+		}
+		names[cfg.Name] = true
+
+		hash := strings.ToLower(strings.TrimSpace(cfg.KeySHA256))
+		switch {
+		case cfg.Key != "" && hash != "":
+			return nil, fmt.Errorf("key %q: set either key or key_sha256", cfg.Name)
+		case cfg.Key != "":
+			hash = hashAPIKey(cfg.Key)
+		case len(hash) != sha256.Size*2:
+			return nil, fmt.Errorf("key %q: key_sha256 must be a hex SHA-256 digest", cfg.Name)
+		}
+		if _, dup := keys[hash]; dup {
+			return nil, fmt.Errorf("key %q: duplicate key", cfg.Name)
+		}
+
+		if len(cfg.Scopes) == 0 {
+			return nil, fmt.Errorf("key %q: scopes must not be empty", cfg.Name)
+		}
+		for _, scope := range cfg.Scopes {
+			if !contains(apiKeyScopes, scope) {
+				return nil, fmt.Errorf("key %q: unknown scope %q", cfg.Name, scope)
+			}
+		}
+
+		rate, burst := cfg.RatePerMinute, cfg.Burst
+		if rate == 0 {
+			rate = defaultKeyRatePerMinute
+		}
+		if burst == 0 {
+			burst = defaultKeyBurst
+		}
+		if rate < 0 || burst < 0 {
+			return nil, fmt.Errorf("key %q: rate_per_minute and burst must be positive", cfg.Name)
+		}
+		keys[hash] = &apiKey{
+			name:   cfg.Name,
+			scopes: cfg.Scopes,
+			burst:  burst,
+			bucket: newTokenBucket(float64(rate)/60, float64(burst)),
+		}
+	}
+	return keys, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requiredScope maps a mux pattern to the scope it needs; "" means public.
+func requiredScope(pattern string) string {
+	switch {
+	case contains(publicPatterns, pattern):
+		return ""
+	case strings.HasPrefix(pattern, "/stream/"):
+		return scopeStream
+	case strings.HasPrefix(pattern, "/alerts/"):
+		return scopeAlertsAdmin
+	default:
+		return scopeRead
+	}
+}
+
+// presentedAPIKey reads the key from X-API-Key or an Authorization bearer.
+func presentedAPIKey(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// withAPIKeys authenticates requests against keys, enforces scopes and the
+// per-key rate limit, and counts requests per key and route. mux is only
+// used to resolve the route pattern for scopes and usage labels.
+func withAPIKeys(next http.Handler, mux *http.ServeMux, keys map[string]*apiKey, usage *usageRecorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		scope := requiredScope(pattern)
+		if scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		presented := presentedAPIKey(r)
+		if presented == "" {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "APIキーを X-API-Key ヘッダーで指定してください")
+			return
+		}
+		key, ok := keys[hashAPIKey(presented)]
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "APIキーが無効です")
+			return
+		}
+		if !contains(key.scopes, scope) {
+			writeError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("このAPIキーには %s スコープがありません", scope))
+			return
+		}
+
+		decision := key.bucket.take(time.Now())
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(key.burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.remaining))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.reset)))
+		usage.record(key.name, pattern, !decision.allowed)
+		if !decision.allowed {
+			h.Set("Retry-After", strconv.Itoa(maxInt(1, ceilSeconds(decision.retryAfter))))
+			writeError(w, http.StatusTooManyRequests, "RATE_LIMITED", "リクエスト数の上限を超えました。Retry-After 秒後に再試行してください")
+			return
+		}
+
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.apiKey = key.name
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key.name)))
+	})
+}
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+type rateDecision struct {
+	allowed   bool
+	remaining int
+	// reset is how long until the bucket is full again; retryAfter is how
+	// long until the next token.
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// take consumes one token if one is available.
+func (b *tokenBucket) take(now time.Time) rateDecision {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	d := rateDecision{allowed: b.tokens >= 1}
+	if d.allowed {
+		b.tokens--
+	} else {
+		d.retryAfter = secondsDuration((1 - b.tokens) / b.rate)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = secondsDuration((b.burst - b.tokens) / b.rate)
+	return d
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
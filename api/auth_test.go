@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	keysJSON := `{"keys": [
+		{"name": "quant", "key": "secret-read", "scopes": ["read"], "rate_per_minute": 60, "burst": 2},
+		{"name": "ops", "key_sha256": "` + hashAPIKey("secret-ops") + `", "scopes": ["read", "alerts-admin"]}
+	]}`
+	if err := os.WriteFile(keysPath, []byte(keysJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadAPIKeys(keysPath)
+	if err != nil {
+		t.Fatalf("loadAPIKeys: %v", err)
+	}
+
+	db, err := openStateDB(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatalf("openStateDB: %v", err)
+	}
+	defer db.Close()
+	usage := newUsageRecorder(db, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyName(r.Context()) == "" && r.URL.Path != "/readyz" {
+			t.Errorf("%s: key name missing from context", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	mux.HandleFunc("/readyz", ok)
+	mux.HandleFunc("/volatility", ok)
+	mux.HandleFunc("/alerts/rules/{id}", ok)
+	handler := withAPIKeys(mux, mux, keys, usage)
+
+	do := func(path, key string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	cases := []struct {
+		path, key string
+		want      int
+	}{
+		{"/readyz", "", http.StatusOK},
+		{"/volatility", "", http.StatusUnauthorized},
+		{"/volatility", "wrong", http.StatusUnauthorized},
+		{"/alerts/rules/1", "secret-read", http.StatusForbidden},
+		{"/alerts/rules/1", "secret-ops", http.StatusOK},
+		{"/volatility", "secret-read", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if rec := do(tc.path, tc.key); rec.Code != tc.want {
+			t.Fatalf("GET %s key=%q = %d, want %d", tc.path, tc.key, rec.Code, tc.want)
+		}
+	}
+
+	rec := do("/volatility", "secret-read")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("second request = %d limit=%q remaining=%q", rec.Code, rec.Header().Get("X-RateLimit-Limit"), rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	rec = do("/volatility", "secret-read")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third request = %d Retry-After=%q, want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if err := usage.flush(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	var requests, limited int
+	err = db.QueryRow(`SELECT requests, rate_limited FROM api_usage WHERE key_name = 'quant' AND endpoint = '/volatility'`).Scan(&requests, &limited)
+	if err != nil || requests != 3 || limited != 1 {
+		t.Fatalf("usage = (%d, %d, %v), want 3 requests with 1 rate limited", requests, limited, err)
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	b := newTokenBucket(1, 2)
+	now := time.Unix(0, 0)
+	for i := 0; i < 2; i++ {
+		if !b.take(now).allowed {
+			t.Fatalf("take %d should be allowed", i)
+		}
+	}
+	d := b.take(now)
+	if d.allowed || d.retryAfter != time.Second || d.reset != 2*time.Second {
+		t.Fatalf("empty bucket decision = %+v", d)
+	}
+	if d := b.take(now.Add(1500 * time.Millisecond)); !d.allowed || d.remaining != 0 {
+		t.Fatalf("after refill decision = %+v, want allowed with 0 remaining", d)
+	}
+}
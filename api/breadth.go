@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxBreadthBuckets = 50
+
+var defaultBreadthBuckets = []float64{-10, -5, -2, -1, 0, 1, 2, 5, 10}
+
+type breadthParams struct {
+	timeframe string
+	offset    int
+	lookback  int
+	buckets   []float64
+	asOf      int64
+}
+
+func (s *apiServer) breadthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	params, perr := parseBreadthParams(r.URL.Query(), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), params.timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "breadth query error", "timeframe", params.timeframe)
+		return
+	}
+	if s.notModified(w, r, snapshot, params) {
+		return
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, params.timeframe, params.asOf, maxInt(params.offset, params.lookback)+1, nil)
+	if err != nil {
+		s.writeQueryError(w, r, err, "breadth query error", "timeframe", params.timeframe)
+		return
+	}
+
+	_, span := tracer.Start(r.Context(), "computeBreadth")
+	resp, stats := computeBreadth(snapshot, params)
+	span.End()
+	resp.Meta = newResponseMeta(stats, params.echo(), map[string]marketSnapshot{params.timeframe: snapshot})
+
+	writeData(w, r, http.StatusOK, resp)
+}
+
+// parseBreadthParams validates the /breadth query string.
+func parseBreadthParams(q url.Values, historyLimit int) (breadthParams, *paramError) {
+	p := breadthParams{offset: 1, lookback: 20, buckets: defaultBreadthBuckets}
+
+	p.timeframe = strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, p.timeframe) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", "))}
+	}
+
+	var err error
+	if raw := strings.TrimSpace(q.Get("offset")); raw != "" {
+		p.offset, err = strconv.Atoi(raw)
+		if err != nil || p.offset <= 0 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "offset は1以上の整数を指定してください"}
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("lookback")); raw != "" {
+		p.lookback, err = strconv.Atoi(raw)
+		if err != nil || p.lookback <= 0 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "lookback は1以上の整数を指定してください"}
+		}
+	}
+	if bars := maxInt(p.offset, p.lookback) + 1; bars > historyLimit {
+		msg := fmt.Sprintf("offset/lookback には %d本のローソク足が必要です。これは現在利用可能な履歴の最大本数(%d本)を超えています。", bars, historyLimit)
+		return p, &paramError{http.StatusBadRequest, "INSUFFICIENT_HISTORY", msg}
+	}
+
+	if raw := strings.TrimSpace(q.Get("buckets")); raw != "" {
+		msg := fmt.Sprintf("buckets には昇順のカンマ区切りの数値を%d個以内で指定してください", maxBreadthBuckets)
+		parts := strings.Split(raw, ",")
+		if len(parts) > maxBreadthBuckets {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", msg}
+		}
+		p.buckets = make([]float64, 0, len(parts))
+		for _, part := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", msg}
+			}
+			if n := len(p.buckets); n > 0 && v <= p.buckets[n-1] {
+				return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", msg}
+			}
+			p.buckets = append(p.buckets, v)
+		}
+	}
+
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		return p, perr
+	}
+	p.asOf = asOf
+
+	return p, nil
+}
+
+// computeBreadth summarises the offset-bar close change of every symbol.
+// The high/low shares compare the newest close with the lookback bars before
+// it and only count symbols holding that many bars. Symbols without an
+// offset-bar change are reported as skipped.
+func computeBreadth(snapshot marketSnapshot, p breadthParams) (breadthResponse, rankStats) {
+	resp := breadthResponse{
+		Timeframe: p.timeframe,
+		Offset:    p.offset,
+		Lookback:  p.lookback,
+		AsOf:      p.asOf,
+		Histogram: make([]breadthBucket, len(p.buckets)+1),
+	}
+	for i := range resp.Histogram {
+		if i > 0 {
+			resp.Histogram[i].Min = &p.buckets[i-1]
+		}
+		if i < len(p.buckets) {
+			resp.Histogram[i].Max = &p.buckets[i]
+		}
+	}
+
+	changes := make([]float64, 0, len(snapshot.seriesBySymbol))
+	var sum, weighted, totalTurnover float64
+	for _, candles := range snapshot.seriesBySymbol {
+		if len(candles) > p.lookback {
+			latest, highest, lowest := candles[0].Close, math.Inf(-1), math.Inf(1)
+			for _, c := range candles[1 : p.lookback+1] {
+				highest = math.Max(highest, c.High)
+				lowest = math.Min(lowest, c.Low)
+			}
+			resp.AboveHigh.Evaluated++
+			resp.BelowLow.Evaluated++
+			if latest > highest {
+				resp.AboveHigh.Count++
+			}
+			if latest < lowest {
+				resp.BelowLow.Count++
+			}
+		}
+
+		if len(candles) <= p.offset || candles[p.offset].Close == 0 {
+			continue
+		}
+		pct := (candles[0].Close - candles[p.offset].Close) / candles[p.offset].Close * 100
+		switch {
+		case pct > 0:
+			resp.Up++
+		case pct < 0:
+			resp.Down++
+		default:
+			resp.Flat++
+		}
+		changes = append(changes, pct)
+		sum += pct
+
+		var turnover float64
+		for _, c := range candles[:p.offset] {
+			turnover += c.Turnover
+		}
+		weighted += pct * turnover
+		totalTurnover += turnover
+
+		bucket := sort.Search(len(p.buckets), func(i int) bool { return p.buckets[i] > pct })
+		resp.Histogram[bucket].Count++
+	}
+
+	resp.Symbols = len(changes)
+	if resp.Down > 0 {
+		resp.AdvanceDeclineRatio = roundedOrNil(float64(resp.Up) / float64(resp.Down))
+	}
+	if len(changes) > 0 {
+		resp.MeanChangePct = roundedOrNil(sum / float64(len(changes)))
+		resp.MedianChangePct = roundedOrNil(median(changes))
+	}
+	if totalTurnover > 0 {
+		resp.TurnoverWeightedChangePct = roundedOrNil(weighted / totalTurnover)
+	}
+	resp.AboveHigh.Pct = sharePct(resp.AboveHigh)
+	resp.BelowLow.Pct = sharePct(resp.BelowLow)
+	stats := rankStats{evaluated: len(changes), skipped: len(snapshot.seriesBySymbol) - len(changes), matched: len(changes)}
+	return resp, stats
+}
+
+func sharePct(share breadthShare) *float64 {
+	if share.Evaluated == 0 {
+		return nil
+	}
+	return roundedOrNil(float64(share.Count) / float64(share.Evaluated) * 100)
+}
+
+func roundedOrNil(v float64) *float64 {
+	return finiteOrNil(round4(v))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestComputeBreadth(t *testing.T) {
+	candle := func(close, high, low, turnover float64) marketCandle {
+		return marketCandle{Close: close, High: high, Low: low, Turnover: turnover}
+	}
+	snapshot := marketSnapshot{seriesBySymbol: map[string][]marketCandle{
+		// +10%, breaks above the previous two highs.
+		"AAAUSDT": {candle(110, 111, 100, 300), candle(100, 105, 95, 0), candle(100, 104, 96, 0)},
+		// -5%, breaks below the previous two lows.
+		"BBBUSDT": {candle(95, 100, 94, 100), candle(100, 105, 96, 0), candle(100, 104, 97, 0)},
+		"CCCUSDT": {candle(50, 50, 50, 0), candle(50, 50, 50, 0), candle(50, 50, 50, 0)},
+		// Too short for the high/low check but has a change.
+		"DDDUSDT": {candle(102, 102, 100, 0), candle(100, 100, 100, 0)},
+	}}
+	p := breadthParams{timeframe: "1h", offset: 1, lookback: 2, buckets: []float64{-2, 0, 2}}
+
+	got, stats := computeBreadth(snapshot, p)
+
+	if got.Symbols != 4 || got.Up != 2 || got.Down != 1 || got.Flat != 1 {
+		t.Fatalf("counts = %+v", got)
+	}
+	if stats != (rankStats{evaluated: 4, matched: 4}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if *got.AdvanceDeclineRatio != 2 || *got.MeanChangePct != 1.75 || *got.MedianChangePct != 1 {
+		t.Fatalf("ratio/mean/median = %v %v %v", *got.AdvanceDeclineRatio, *got.MeanChangePct, *got.MedianChangePct)
+	}
+	if *got.TurnoverWeightedChangePct != 6.25 {
+		t.Fatalf("turnover weighted = %v", *got.TurnoverWeightedChangePct)
+	}
+	wantCounts := []int{1, 0, 1, 2}
+	for i, bucket := range got.Histogram {
+		if bucket.Count != wantCounts[i] {
+			t.Fatalf("histogram[%d] = %d, want %d", i, bucket.Count, wantCounts[i])
+		}
+	}
+	if got.Histogram[0].Min != nil || *got.Histogram[0].Max != -2 || got.Histogram[3].Max != nil {
+		t.Fatalf("histogram bounds = %+v", got.Histogram)
+	}
+	if got.AboveHigh.Count != 1 || got.AboveHigh.Evaluated != 3 || got.BelowLow.Count != 1 || *got.BelowLow.Pct != 33.3333 {
+		t.Fatalf("above/below = %+v %+v", got.AboveHigh, got.BelowLow)
+	}
+}
+
+func TestParseBreadthParamsBuckets(t *testing.T) {
+	for raw, ok := range map[string]bool{"-5,0,5": true, "0": true, "5,0": false, "0,0": false, "1,x": false} {
+		_, perr := parseBreadthParams(url.Values{"timeframe": {"1h"}, "buckets": {raw}}, 100)
+		if (perr == nil) != ok {
+			t.Errorf("buckets=%q accepted=%v, want %v", raw, perr == nil, ok)
+		}
+	}
+}
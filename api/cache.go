@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type marketCandle struct {
 	TS       int64
+	Open     float64
+	High     float64
+	Low      float64
 	Close    float64
 	Volume   float64
 	Turnover float64
@@ -18,38 +28,81 @@ type marketCandle struct {
 type marketSnapshot struct {
 	seriesBySymbol map[string][]marketCandle
 	refreshedAt    time.Time
+	// dataVersion is the fetcher's data_version for the timeframe when the
+	// snapshot was loaded, or -1 when the table is unavailable.
+	dataVersion int64
+	// versionUpdatedAt is when dataVersion was committed (ms), 0 if unknown.
+	versionUpdatedAt int64
+	// newestTS is the newest candle timestamp across all symbols; incremental
+	// refreshes read rows from slightly before it.
+	newestTS        int64
+	fullRefreshedAt time.Time
 }
 
+// errCacheWarming is returned while a timeframe has not been loaded yet, so
+// handlers can answer 503 instead of an empty ranking.
+var errCacheWarming = errors.New("cache warming")
+
+// incrementalOverlapBars re-reads a few bars before the newest known one so
+// the still-forming candle and late fetcher writes are picked up.
+const incrementalOverlapBars = 3
+
 type marketDataCache struct {
-	db           *sql.DB
-	historyLimit int
-	refreshEvery time.Duration
+	// ctx bounds background refreshes and is cancelled on shutdown. Refreshes
+	// are shared by every waiting request, so they never use a request's ctx.
+	ctx              context.Context
+	db               *sql.DB
+	historyLimit     int
+	refreshEvery     time.Duration
+	fullRefreshEvery time.Duration
+	warmupWait       time.Duration
 
 	mu         sync.RWMutex
 	snapshots  map[string]marketSnapshot
 	refreshing map[string]bool
+	loaded     map[string]chan struct{}
+	// versioned is true while data_version can be read. The watcher then
+	// drives refreshes and the time-based refresh in getSnapshot is skipped.
+	versioned bool
+	// versionRetryAt holds back the watcher for a timeframe whose version
+	// could not be read, so a locked database is not reloaded on every poll.
+	versionRetryAt map[string]time.Time
+	// listeners are called after a timeframe's snapshot is replaced.
+	listeners []func(timeframe string, snapshot marketSnapshot)
 }
 
-func newMarketDataCache(db *sql.DB, historyLimit int, refreshEvery time.Duration) *marketDataCache {
+func newMarketDataCache(ctx context.Context, db *sql.DB, historyLimit int, refreshEvery, fullRefreshEvery, warmupWait time.Duration) *marketDataCache {
 	if refreshEvery <= 0 {
 		refreshEvery = 5 * time.Second
 	}
+	if fullRefreshEvery <= 0 {
+		fullRefreshEvery = 5 * time.Minute
+	}
 	return &marketDataCache{
-		db:           db,
-		historyLimit: historyLimit,
-		refreshEvery: refreshEvery,
-		snapshots:    make(map[string]marketSnapshot),
-		refreshing:   make(map[string]bool),
+		ctx:              ctx,
+		db:               db,
+		historyLimit:     historyLimit,
+		refreshEvery:     refreshEvery,
+		fullRefreshEvery: fullRefreshEvery,
+		warmupWait:       warmupWait,
+		snapshots:        make(map[string]marketSnapshot),
+		refreshing:       make(map[string]bool),
+		loaded:           make(map[string]chan struct{}),
+		versionRetryAt:   make(map[string]time.Time),
 	}
 }
 
-func (c *marketDataCache) getSnapshot(timeframe string) (marketSnapshot, error) {
+func (c *marketDataCache) getSnapshot(ctx context.Context, timeframe string) (marketSnapshot, error) {
+	_, span := tracer.Start(ctx, "cache.getSnapshot", trace.WithAttributes(attribute.String("timeframe", timeframe)))
+	defer span.End()
 	now := time.Now().UTC()
 
 	c.mu.RLock()
 	snapshot, ok := c.snapshots[timeframe]
+	versioned := c.versioned
 	c.mu.RUnlock()
-	if ok && now.Sub(snapshot.refreshedAt) < c.refreshEvery {
+	span.SetAttributes(attribute.Bool("cache.loaded", ok))
+	if ok && (versioned || now.Sub(snapshot.refreshedAt) < c.refreshEvery) {
 		return snapshot, nil
 	}
 	if ok {
@@ -57,10 +110,34 @@ func (c *marketDataCache) getSnapshot(timeframe string) (marketSnapshot, error)
 		return snapshot, nil
 	}
 
-	// First access: refresh in the background and return an empty snapshot
-	// immediately instead of blocking the request on a synchronous full scan.
+	// Not loaded yet: never answer with an empty snapshot, which callers
+	// would read as "no matches". Wait up to warmupWait, then report warming.
 	c.refreshSnapshotAsync(timeframe)
-	return marketSnapshot{seriesBySymbol: make(map[string][]marketCandle), refreshedAt: now}, nil
+	if c.warmupWait <= 0 {
+		return marketSnapshot{}, errCacheWarming
+	}
+	timer := time.NewTimer(c.warmupWait)
+	defer timer.Stop()
+	select {
+	case <-c.loadedSignal(timeframe):
+	case <-timer.C:
+		return marketSnapshot{}, errCacheWarming
+	case <-ctx.Done():
+		return marketSnapshot{}, ctx.Err()
+	}
+
+	c.mu.RLock()
+	snapshot = c.snapshots[timeframe]
+	c.mu.RUnlock()
+	return snapshot, nil
+}
+
+// onRefresh registers fn to run after every snapshot replacement. fn runs on
+// the refreshing goroutine and should hand work off rather than block.
+func (c *marketDataCache) onRefresh(fn func(timeframe string, snapshot marketSnapshot)) {
+	c.mu.Lock()
+	c.listeners = append(c.listeners, fn)
+	c.mu.Unlock()
 }
 
 func (c *marketDataCache) warmup(timeframes []string) {
@@ -69,66 +146,437 @@ func (c *marketDataCache) warmup(timeframes []string) {
 	}
 }
 
-func (c *marketDataCache) refreshSnapshot(timeframe string, now time.Time) (marketSnapshot, error) {
+// loadedSignal returns a channel that is closed once the timeframe has been
+// loaded for the first time.
+func (c *marketDataCache) loadedSignal(timeframe string) chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadedSignalLocked(timeframe)
+}
+
+func (c *marketDataCache) loadedSignalLocked(timeframe string) chan struct{} {
+	ch, ok := c.loaded[timeframe]
+	if !ok {
+		ch = make(chan struct{})
+		c.loaded[timeframe] = ch
+	}
+	return ch
+}
+
+// loadState reports, per timeframe, when it was last loaded. A zero time
+// means the timeframe is still warming.
+func (c *marketDataCache) loadState(timeframes []string) map[string]time.Time {
 	c.mu.RLock()
-	if snapshot, ok := c.snapshots[timeframe]; ok && now.Sub(snapshot.refreshedAt) < c.refreshEvery {
-		c.mu.RUnlock()
-		return snapshot, nil
+	defer c.mu.RUnlock()
+	state := make(map[string]time.Time, len(timeframes))
+	for _, tf := range timeframes {
+		state[tf] = c.snapshots[tf].refreshedAt
 	}
-	c.mu.RUnlock()
+	return state
+}
+
+// watchDataVersions polls the fetcher's data_version table and refreshes a
+// timeframe as soon as its version moves. While the table cannot be read the
+// cache falls back to refreshing every refreshEvery.
+func (c *marketDataCache) watchDataVersions(ctx context.Context, pollEvery time.Duration) {
+	ticker := time.NewTicker(pollEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		versions, err := c.readDataVersions(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.mu.Lock()
+		if (err == nil) != c.versioned {
+			if err != nil {
+				slog.Warn("data_version unavailable, falling back to periodic refresh", "err", err)
+			} else {
+				slog.Info("data_version available, refreshing on fetcher commits")
+			}
+		}
+		c.versioned = err == nil
+		stale := make([]string, 0)
+		if err == nil {
+			now := time.Now()
+			for tf, snapshot := range c.snapshots {
+				if now.Before(c.versionRetryAt[tf]) {
+					continue
+				}
+				if versions[tf] != snapshot.dataVersion {
+					stale = append(stale, tf)
+				}
+			}
+		}
+		c.mu.Unlock()
+
+		for _, tf := range stale {
+			c.refreshSnapshotAsync(tf)
+		}
+	}
+}
+
+func (c *marketDataCache) readDataVersions(ctx context.Context) (map[string]int64, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT timeframe, version FROM data_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var tf string
+		var version int64
+		if err := rows.Scan(&tf, &version); err != nil {
+			return nil, err
+		}
+		versions[tf] = version
+	}
+	return versions, rows.Err()
+}
+
+// readDataVersion returns the timeframe's version and its commit time (ms),
+// 0 when the fetcher has not committed it yet, or -1 when data_version does
+// not exist. Any other failure (e.g. a locked database) is returned as an
+// error.
+func (c *marketDataCache) readDataVersion(ctx context.Context, timeframe string) (version, updatedAt int64, err error) {
+	err = c.db.QueryRowContext(ctx, `SELECT version, updated_at FROM data_version WHERE timeframe = ?`, timeframe).Scan(&version, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		if exists, terr := tableExists(ctx, c.db, "data_version"); terr == nil && !exists {
+			return -1, 0, nil
+		}
+		return 0, 0, err
+	}
+	return version, updatedAt, nil
+}
+
+func (c *marketDataCache) refreshSnapshot(ctx context.Context, timeframe string, now time.Time) (_ marketSnapshot, err error) {
+	ctx, span := tracer.Start(ctx, "cache.refresh", trace.WithAttributes(attribute.String("timeframe", timeframe)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	// Read the version before the rows: a commit landing in between leaves
+	// the snapshot tagged with the older version, so the watcher reloads it.
+	version, versionUpdatedAt, verr := c.readDataVersion(ctx, timeframe)
 
+	c.mu.Lock()
+	prev, hasPrev := c.snapshots[timeframe]
+	if verr != nil {
+		c.versionRetryAt[timeframe] = now.Add(c.refreshEvery)
+	}
+	c.mu.Unlock()
+	if verr != nil {
+		if ctx.Err() != nil {
+			return marketSnapshot{}, ctx.Err()
+		}
+		slog.WarnContext(ctx, "data_version read failed, keeping the last known version", "timeframe", timeframe, "err", verr)
+		if hasPrev {
+			return prev, nil
+		}
+		// Nothing to keep yet: load anyway so the timeframe stops warming.
+		version = -1
+	}
+	if hasPrev {
+		if version >= 0 && prev.dataVersion == version {
+			return prev, nil
+		}
+		if version < 0 && now.Sub(prev.refreshedAt) < c.refreshEvery {
+			return prev, nil
+		}
+	}
+
+	var snapshot marketSnapshot
+	if hasPrev && prev.newestTS > 0 && now.Sub(prev.fullRefreshedAt) < c.fullRefreshEvery {
+		span.SetAttributes(attribute.String("cache.refresh_mode", "incremental"))
+		snapshot, err = c.loadIncremental(ctx, timeframe, prev)
+	} else {
+		span.SetAttributes(attribute.String("cache.refresh_mode", "full"))
+		snapshot, err = c.loadFull(ctx, timeframe)
+		snapshot.fullRefreshedAt = now
+	}
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	snapshot.refreshedAt = now
+	snapshot.dataVersion = version
+	snapshot.versionUpdatedAt = versionUpdatedAt
+
+	c.mu.Lock()
+	_, wasLoaded := c.snapshots[timeframe]
+	c.snapshots[timeframe] = snapshot
+	if !wasLoaded {
+		close(c.loadedSignalLocked(timeframe))
+	}
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(timeframe, snapshot)
+	}
+	return snapshot, nil
+}
+
+// loadFull rebuilds every series from the table. It also serves as the
+// periodic consistency pass that drops delisted symbols and picks up rows
+// backfilled behind the incremental window.
+func (c *marketDataCache) loadFull(ctx context.Context, timeframe string) (marketSnapshot, error) {
 	tableName, err := safeTableName(timeframe)
 	if err != nil {
 		return marketSnapshot{}, err
 	}
 
+	// A timeframe the fetcher does not collect has no table. Treat it as
+	// loaded and empty rather than warming forever.
+	exists, err := tableExists(ctx, c.db, tableName)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	if !exists {
+		return marketSnapshot{seriesBySymbol: make(map[string][]marketCandle)}, nil
+	}
+
 	query := fmt.Sprintf(`
 		WITH ranked AS (
 			SELECT
 				symbol,
 				timestamp,
+				open,
+				high,
+				low,
 				close,
 				volume,
 				turnover,
 				ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY timestamp DESC) AS rn
 			FROM %s
 		)
-		SELECT symbol, timestamp, close, volume, turnover
+		SELECT symbol, timestamp, open, high, low, close, volume, turnover
 		FROM ranked
 		WHERE rn <= ?
 		ORDER BY symbol ASC, timestamp DESC
 	`, tableName)
 
-	rows, err := c.db.Query(query, c.historyLimit)
+	seriesBySymbol, err := c.queryCandles(ctx, query, c.historyLimit)
 	if err != nil {
 		return marketSnapshot{}, err
 	}
+	return marketSnapshot{
+		seriesBySymbol: seriesBySymbol,
+		newestTS:       newestCandleTS(seriesBySymbol),
+	}, nil
+}
+
+// loadIncremental reads only rows at or after the previous newest bar minus
+// a small overlap and merges them into copies of the affected series. Series
+// without updates are shared with prev, which is never mutated.
+func (c *marketDataCache) loadIncremental(ctx context.Context, timeframe string, prev marketSnapshot) (marketSnapshot, error) {
+	tableName, err := safeTableName(timeframe)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	tfMinutes, err := parseTimeframeToMinutes(timeframe)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	since := prev.newestTS - int64(incrementalOverlapBars)*int64(tfMinutes)*60*1000
+
+	query := fmt.Sprintf(`
+		SELECT symbol, timestamp, open, high, low, close, volume, turnover
+		FROM %s
+		WHERE timestamp >= ?
+		ORDER BY symbol ASC, timestamp DESC
+	`, tableName)
+
+	updates, err := c.queryCandles(ctx, query, since)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+
+	seriesBySymbol := make(map[string][]marketCandle, len(prev.seriesBySymbol)+len(updates))
+	for symbol, candles := range prev.seriesBySymbol {
+		seriesBySymbol[symbol] = candles
+	}
+	for symbol, fresh := range updates {
+		seriesBySymbol[symbol] = mergeCandles(prev.seriesBySymbol[symbol], fresh, c.historyLimit)
+	}
+
+	return marketSnapshot{
+		seriesBySymbol:  seriesBySymbol,
+		newestTS:        newestCandleTS(seriesBySymbol),
+		fullRefreshedAt: prev.fullRefreshedAt,
+	}, nil
+}
+
+// loadAsOf reads the newest historyLimit candles at or before asOfMS per
+// symbol, for points in time the cached window no longer covers. An empty
+// symbols reads every symbol.
+func (c *marketDataCache) loadAsOf(ctx context.Context, timeframe string, asOfMS int64, symbols []string) (marketSnapshot, error) {
+	tableName, err := safeTableName(timeframe)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	exists, err := tableExists(ctx, c.db, tableName)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	if !exists {
+		return marketSnapshot{seriesBySymbol: make(map[string][]marketCandle)}, nil
+	}
+
+	args := []any{asOfMS}
+	symbolFilter := ""
+	if len(symbols) > 0 {
+		symbolFilter = " AND symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ")"
+		for _, symbol := range symbols {
+			args = append(args, symbol)
+		}
+	}
+	args = append(args, c.historyLimit)
+
+	query := fmt.Sprintf(`
+		WITH ranked AS (
+			SELECT
+				symbol,
+				timestamp,
+				open,
+				high,
+				low,
+				close,
+				volume,
+				turnover,
+				ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY timestamp DESC) AS rn
+			FROM %s
+			WHERE timestamp <= ?%s
+		)
+		SELECT symbol, timestamp, open, high, low, close, volume, turnover
+		FROM ranked
+		WHERE rn <= ?
+		ORDER BY symbol ASC, timestamp DESC
+	`, tableName, symbolFilter)
+
+	seriesBySymbol, err := c.queryCandles(ctx, query, args...)
+	if err != nil {
+		return marketSnapshot{}, err
+	}
+	return marketSnapshot{
+		seriesBySymbol: seriesBySymbol,
+		newestTS:       newestCandleTS(seriesBySymbol),
+	}, nil
+}
+
+// storedFrom is the database counterpart of retainedFrom: the newest of the
+// oldest open times of symbols holding historyLimit rows or more, limited to
+// symbols when given. 0 means the table is missing or no symbol is cut short.
+func (c *marketDataCache) storedFrom(ctx context.Context, timeframe string, symbols []string) (int64, error) {
+	tableName, err := safeTableName(timeframe)
+	if err != nil {
+		return 0, err
+	}
+	exists, err := tableExists(ctx, c.db, tableName)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var args []any
+	symbolFilter := ""
+	if len(symbols) > 0 {
+		symbolFilter = " WHERE symbol IN (?" + strings.Repeat(", ?", len(symbols)-1) + ")"
+		for _, symbol := range symbols {
+			args = append(args, symbol)
+		}
+	}
+	args = append(args, c.historyLimit)
+	query := fmt.Sprintf(`
+		SELECT MAX(oldest) FROM (
+			SELECT MIN(timestamp) AS oldest
+			FROM %s%s
+			GROUP BY symbol
+			HAVING COUNT(*) >= ?
+		)`, tableName, symbolFilter)
+	var from sql.NullInt64
+	if err := c.db.QueryRowContext(ctx, query, args...).Scan(&from); err != nil {
+		return 0, err
+	}
+	return from.Int64, nil
+}
+
+func (c *marketDataCache) queryCandles(ctx context.Context, query string, args ...any) (map[string][]marketCandle, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	seriesBySymbol := make(map[string][]marketCandle)
 	for rows.Next() {
 		var symbol string
 		var candle marketCandle
-		if err := rows.Scan(&symbol, &candle.TS, &candle.Close, &candle.Volume, &candle.Turnover); err != nil {
-			return marketSnapshot{}, err
+		if err := rows.Scan(&symbol, &candle.TS, &candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Volume, &candle.Turnover); err != nil {
+			return nil, err
 		}
 		seriesBySymbol[symbol] = append(seriesBySymbol[symbol], candle)
 	}
 	if err := rows.Err(); err != nil {
-		return marketSnapshot{}, err
+		return nil, err
 	}
+	return seriesBySymbol, nil
+}
 
-	snapshot := marketSnapshot{
-		seriesBySymbol: seriesBySymbol,
-		refreshedAt:    now,
+// mergeCandles merges two newest-first series into a new slice, preferring
+// fresh rows on equal timestamps, and trims the result to limit.
+func mergeCandles(existing, fresh []marketCandle, limit int) []marketCandle {
+	merged := make([]marketCandle, 0, minInt(len(existing)+len(fresh), limit))
+	i, j := 0, 0
+	for len(merged) < limit && (i < len(existing) || j < len(fresh)) {
+		switch {
+		case j >= len(fresh):
+			merged = append(merged, existing[i])
+			i++
+		case i >= len(existing):
+			merged = append(merged, fresh[j])
+			j++
+		case fresh[j].TS > existing[i].TS:
+			merged = append(merged, fresh[j])
+			j++
+		case fresh[j].TS < existing[i].TS:
+			merged = append(merged, existing[i])
+			i++
+		default:
+			merged = append(merged, fresh[j])
+			i++
+			j++
+		}
 	}
-	c.mu.Lock()
-	c.snapshots[timeframe] = snapshot
-	c.mu.Unlock()
-	return snapshot, nil
+	return merged
+}
+
+func newestCandleTS(seriesBySymbol map[string][]marketCandle) int64 {
+	var newest int64
+	for _, candles := range seriesBySymbol {
+		if len(candles) > 0 && candles[0].TS > newest {
+			newest = candles[0].TS
+		}
+	}
+	return newest
 }
 
 func (c *marketDataCache) refreshSnapshotAsync(timeframe string) {
+	if c.ctx.Err() != nil {
+		return
+	}
 	c.mu.Lock()
 	if c.refreshing[timeframe] {
 		c.mu.Unlock()
@@ -145,9 +593,11 @@ func (c *marketDataCache) refreshSnapshotAsync(timeframe string) {
 		}()
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("panic in snapshot refresh for %s: %v", timeframe, r)
+				slog.Error("panic in snapshot refresh", "timeframe", timeframe, "panic", fmt.Sprint(r))
 			}
 		}()
-		_, _ = c.refreshSnapshot(timeframe, time.Now().UTC())
+		if _, err := c.refreshSnapshot(c.ctx, timeframe, time.Now().UTC()); err != nil && c.ctx.Err() == nil {
+			slog.Error("snapshot refresh failed", "timeframe", timeframe, "err", err)
+		}
 	}()
 }
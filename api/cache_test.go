@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeCandlesPrefersFreshRowsAndTrims(t *testing.T) {
+	existing := []marketCandle{{TS: 300, Close: 3}, {TS: 200, Close: 2}, {TS: 100, Close: 1}}
+	fresh := []marketCandle{{TS: 400, Close: 4}, {TS: 300, Close: 30}}
+
+	merged := mergeCandles(existing, fresh, 3)
+
+	want := []marketCandle{{TS: 400, Close: 4}, {TS: 300, Close: 30}, {TS: 200, Close: 2}}
+	if len(merged) != len(want) {
+		t.Fatalf("len = %d, want %d", len(merged), len(want))
+	}
+	for i := range want {
+		if merged[i] != want[i] {
+			t.Fatalf("merged[%d] = %+v, want %+v", i, merged[i], want[i])
+		}
+	}
+	if existing[0].Close != 3 {
+		t.Fatalf("existing series was mutated: %+v", existing[0])
+	}
+}
+
+func TestRefreshSnapshotModesAndVersionErrors(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(`CREATE TABLE ohlcv_1h (symbol TEXT, timestamp INTEGER, open REAL, high REAL, low REAL, close REAL, volume REAL, turnover REAL)`)
+	exec(`CREATE TABLE data_version (timeframe TEXT PRIMARY KEY, version INTEGER NOT NULL, updated_at INTEGER NOT NULL)`)
+	const hour = int64(3_600_000)
+	insert := func(ts int64, close float64, version int) {
+		t.Helper()
+		exec(`DELETE FROM ohlcv_1h WHERE symbol = 'BTCUSDT' AND timestamp = ?`, ts)
+		exec(`INSERT INTO ohlcv_1h VALUES ('BTCUSDT', ?, ?, ?, ?, ?, 1, 1)`, ts, close, close, close, close)
+		exec(`INSERT OR REPLACE INTO data_version VALUES ('1h', ?, ?)`, version, version*1000)
+	}
+	for i := int64(1); i <= 3; i++ {
+		insert(i*hour, float64(i), 1)
+	}
+
+	ctx := context.Background()
+	c := newMarketDataCache(ctx, db, 3, time.Minute, time.Hour, 0)
+	now := time.Unix(1_700_000_000, 0)
+
+	full, err := c.refreshSnapshot(ctx, "1h", now)
+	if err != nil || !full.fullRefreshedAt.Equal(now) || full.dataVersion != 1 || full.versionUpdatedAt != 1000 || len(full.seriesBySymbol["BTCUSDT"]) != 3 {
+		t.Fatalf("first refresh = %+v, %v", full, err)
+	}
+	if same, _ := c.refreshSnapshot(ctx, "1h", now.Add(time.Second)); !same.refreshedAt.Equal(now) {
+		t.Fatalf("unchanged version reloaded: %+v", same)
+	}
+
+	// A newer version inside fullRefreshEvery merges rows into the window.
+	insert(3*hour, 30, 2)
+	insert(4*hour, 4, 2)
+	inc, err := c.refreshSnapshot(ctx, "1h", now.Add(time.Minute))
+	if err != nil || !inc.fullRefreshedAt.Equal(now) || inc.dataVersion != 2 || inc.versionUpdatedAt != 2000 {
+		t.Fatalf("incremental refresh = %+v, %v", inc, err)
+	}
+	if got := inc.seriesBySymbol["BTCUSDT"]; len(got) != 3 || got[0].TS != 4*hour || got[1].Close != 30 || got[2].TS != 2*hour {
+		t.Fatalf("incremental series = %+v", got)
+	}
+	if full.seriesBySymbol["BTCUSDT"][0].TS != 3*hour {
+		t.Fatal("incremental refresh mutated the previous snapshot")
+	}
+
+	later := now.Add(2 * time.Hour)
+	insert(5*hour, 5, 3)
+	if again, err := c.refreshSnapshot(ctx, "1h", later); err != nil || !again.fullRefreshedAt.Equal(later) || again.newestTS != 5*hour {
+		t.Fatalf("refresh past fullRefreshEvery = %+v, %v", again, err)
+	}
+
+	// An unreadable version keeps the last snapshot and holds off the watcher.
+	exec(`DROP TABLE data_version`)
+	exec(`CREATE TABLE data_version (timeframe TEXT PRIMARY KEY)`)
+	kept, err := c.refreshSnapshot(ctx, "1h", later.Add(time.Second))
+	if err != nil || kept.dataVersion != 3 || !kept.refreshedAt.Equal(later) {
+		t.Fatalf("refresh with a broken data_version = %+v, %v", kept, err)
+	}
+	if retry := c.versionRetryAt["1h"]; !retry.Equal(later.Add(time.Second + time.Minute)) {
+		t.Fatalf("versionRetryAt = %v", retry)
+	}
+}
+
+func TestGetSnapshotStopsWaitingWhenRequestCancelled(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "empty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	background, stop := context.WithCancel(context.Background())
+	defer stop()
+	// A table-less timeframe loads instantly, so block the refresh instead.
+	c := newMarketDataCache(background, db, 5, time.Second, time.Minute, time.Minute)
+	c.refreshing["1h"] = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.getSnapshot(ctx, "1h")
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("getSnapshot error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("getSnapshot kept waiting after the request was cancelled")
+	}
+}
+
+func TestReadyReportsDraining(t *testing.T) {
+	s := &apiServer{marketCache: newMarketDataCache(context.Background(), nil, 5, time.Second, time.Minute, 0)}
+	s.draining.Store(true)
+	rec := httptest.NewRecorder()
+	s.readyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"draining"`) {
+		t.Fatalf("readyz while draining = %d %s", rec.Code, rec.Body.String())
+	}
+}
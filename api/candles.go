@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCandlesLimit = 500
+	maxCandlesLimit     = 1000
+)
+
+// candlesCursor is serialised into the opaque next_cursor. It is bound to the
+// symbol, timeframe and order it was issued for.
+type candlesCursor struct {
+	Symbol    string `json:"s"`
+	Timeframe string `json:"tf"`
+	Order     string `json:"o"`
+	LastTS    int64  `json:"ts"`
+}
+
+type candlesQuery struct {
+	symbol    string
+	timeframe string
+	from      int64
+	to        int64
+	order     string
+	limit     int
+	after     *int64
+}
+
+func (s *apiServer) candlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	symbol := strings.ToUpper(strings.TrimSpace(q.Get("symbol")))
+	if !symbolRegex.MatchString(symbol) {
+		writeError(w, http.StatusBadRequest, "INVALID_SYMBOL", "symbol は英数字の銘柄シンボルを指定してください (例: BTCUSDT)")
+		return
+	}
+
+	timeframe := strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, timeframe) {
+		writeError(w, http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", ")))
+		return
+	}
+
+	query := candlesQuery{symbol: symbol, timeframe: timeframe, from: 0, to: maxTimestampMS, order: "asc", limit: defaultCandlesLimit}
+	var err error
+	if raw := strings.TrimSpace(q.Get("from")); raw != "" {
+		query.from, err = parseTimeParam(raw)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "from はミリ秒のUNIX時刻またはRFC3339形式で指定してください")
+			return
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("to")); raw != "" {
+		query.to, err = parseTimeParam(raw)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "to はミリ秒のUNIX時刻またはRFC3339形式で指定してください")
+			return
+		}
+	}
+	if query.from > query.to {
+		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "from は to 以前の時刻を指定してください")
+		return
+	}
+
+	if raw := strings.TrimSpace(q.Get("order")); raw != "" {
+		if raw != "asc" && raw != "desc" {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "order は asc/desc のいずれかを指定してください")
+			return
+		}
+		query.order = raw
+	}
+
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		query.limit, err = strconv.Atoi(raw)
+		if err != nil || query.limit <= 0 || query.limit > maxCandlesLimit {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("limit は1以上%d以下の整数を指定してください", maxCandlesLimit))
+			return
+		}
+	}
+
+	if raw := strings.TrimSpace(q.Get("cursor")); raw != "" {
+		cursor, err := decodeCandlesCursor(raw)
+		if err != nil || cursor.Symbol != symbol || cursor.Timeframe != timeframe || cursor.Order != query.order {
+			writeError(w, http.StatusBadRequest, "INVALID_CURSOR", "cursor が不正か、symbol/timeframe/order と一致しません")
+			return
+		}
+		query.after = &cursor.LastTS
+	}
+
+	items, hasMore, queryErr := s.queryCandles(r.Context(), query)
+	if queryErr != nil {
+		s.writeQueryError(w, r, queryErr, "candles query error", "symbol", symbol, "timeframe", timeframe)
+		return
+	}
+
+	resp := candlesResponse{Symbol: symbol, Timeframe: timeframe, Count: len(items), Data: items}
+	if hasMore {
+		resp.NextCursor = encodeCandlesCursor(candlesCursor{Symbol: symbol, Timeframe: timeframe, Order: query.order, LastTS: items[len(items)-1].TS})
+	}
+	writeData(w, r, http.StatusOK, resp)
+}
+
+// queryCandles reads raw OHLCV rows straight from the table, which holds more
+// history than the in-memory cache. It fetches one extra row to detect
+// whether another page exists.
+func (s *apiServer) queryCandles(ctx context.Context, q candlesQuery) ([]candleItem, bool, error) {
+	tableName, err := safeTableName(q.timeframe)
+	if err != nil {
+		return nil, false, err
+	}
+	exists, err := tableExists(ctx, s.db, tableName)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return []candleItem{}, false, nil
+	}
+
+	from, to := q.from, q.to
+	if q.after != nil {
+		if q.order == "asc" && *q.after+1 > from {
+			from = *q.after + 1
+		}
+		if q.order == "desc" && *q.after-1 < to {
+			to = *q.after - 1
+		}
+	}
+
+	direction := "ASC"
+	if q.order == "desc" {
+		direction = "DESC"
+	}
+	query := fmt.Sprintf(`
+		SELECT timestamp, open, high, low, close, volume, turnover
+		FROM %s
+		WHERE symbol = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp %s
+		LIMIT ?
+	`, tableName, direction)
+
+	rows, err := s.db.QueryContext(ctx, query, q.symbol, from, to, q.limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	items := make([]candleItem, 0, q.limit+1)
+	for rows.Next() {
+		var item candleItem
+		if err := rows.Scan(&item.TS, &item.Open, &item.High, &item.Low, &item.Close, &item.Volume, &item.Turnover); err != nil {
+			return nil, false, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(items) > q.limit
+	if hasMore {
+		items = items[:q.limit]
+	}
+	return items, hasMore, nil
+}
+
+func encodeCandlesCursor(c candlesCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCandlesCursor(raw string) (candlesCursor, error) {
+	var c candlesCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.Symbol == "" || c.Timeframe == "" || (c.Order != "asc" && c.Order != "desc") {
+		return c, errors.New("incomplete cursor")
+	}
+	return c, nil
+}
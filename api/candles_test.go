@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeCandlesCursorRejectsTampering(t *testing.T) {
+	valid := encodeCandlesCursor(candlesCursor{Symbol: "BTCUSDT", Timeframe: "1h", Order: "desc", LastTS: 42})
+	if got, err := decodeCandlesCursor(valid); err != nil || got.LastTS != 42 || got.Order != "desc" {
+		t.Fatalf("round trip = %+v, %v", got, err)
+	}
+
+	for name, raw := range map[string]string{
+		"not base64":    "!!!",
+		"not json":      "bm90LWpzb24",
+		"missing order": encodeCandlesCursor(candlesCursor{Symbol: "BTCUSDT", Timeframe: "1h", LastTS: 42}),
+		"bad order":     encodeCandlesCursor(candlesCursor{Symbol: "BTCUSDT", Timeframe: "1h", Order: "up", LastTS: 42}),
+		"truncated":     valid[:len(valid)-4],
+	} {
+		if _, err := decodeCandlesCursor(raw); err == nil {
+			t.Errorf("%s: cursor %q was accepted", name, raw)
+		}
+	}
+}
+
+func TestCandlesHandlerPagesWithCursor(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE ohlcv_1h (symbol TEXT, timestamp INTEGER, open REAL, high REAL, low REAL, close REAL, volume REAL, turnover REAL)`); err != nil {
+		t.Fatal(err)
+	}
+	for ts := int64(1); ts <= 5; ts++ {
+		if _, err := db.Exec(`INSERT INTO ohlcv_1h VALUES ('BTCUSDT', ?, 1, 1, 1, 1, 1, 1)`, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &apiServer{db: db}
+
+	get := func(query string) (int, candlesResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.candlesHandler(rec, httptest.NewRequest(http.MethodGet, "/candles?"+query, nil))
+		var resp candlesResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, resp
+	}
+
+	cases := []struct {
+		order string
+		pages [][]int64
+	}{
+		{"asc", [][]int64{{1, 2}, {3, 4}, {5}}},
+		{"desc", [][]int64{{5, 4}, {3, 2}, {1}}},
+	}
+	for _, tc := range cases {
+		cursor := ""
+		for i, want := range tc.pages {
+			code, resp := get("symbol=BTCUSDT&timeframe=1h&limit=2&order=" + tc.order + "&cursor=" + cursor)
+			if code != http.StatusOK || resp.Count != len(want) {
+				t.Fatalf("%s page %d = %d %+v", tc.order, i, code, resp)
+			}
+			for j, ts := range want {
+				if resp.Data[j].TS != ts {
+					t.Fatalf("%s page %d = %+v, want %v", tc.order, i, resp.Data, want)
+				}
+			}
+			if last := i == len(tc.pages)-1; last != (resp.NextCursor == "") {
+				t.Fatalf("%s page %d next_cursor = %q", tc.order, i, resp.NextCursor)
+			}
+			cursor = resp.NextCursor
+		}
+	}
+
+	// A cursor only continues the listing it was issued for.
+	_, first := get("symbol=BTCUSDT&timeframe=1h&limit=2")
+	for _, query := range []string{
+		"symbol=ETHUSDT&timeframe=1h&cursor=" + first.NextCursor,
+		"symbol=BTCUSDT&timeframe=4h&cursor=" + first.NextCursor,
+		"symbol=BTCUSDT&timeframe=1h&order=desc&cursor=" + first.NextCursor,
+		"symbol=BTCUSDT&timeframe=1h&cursor=" + first.NextCursor[1:],
+	} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, code)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notModified sets the validators for a response computed from snapshot and
+// answers 304 when the client's copy is still current. The ETag covers the
+// fetcher's data_version, the path, the negotiated format and the parsed
+// query (passed as keys), so equivalent spellings of a query share a tag and
+// every replica hands out the same tag for the same data. Last-Modified is
+// the time data_version was committed, so it moves with the ETag, including
+// when only the forming bar was rewritten; as RFC 9110 requires,
+// If-Modified-Since is only consulted when If-None-Match is absent. writeError
+// drops the validators again if the handler fails afterwards.
+func (s *apiServer) notModified(w http.ResponseWriter, r *http.Request, snapshot marketSnapshot, keys ...any) bool {
+	format, perr := negotiateFormat(r)
+	if perr != nil {
+		// writeData reports the bad format.
+		return false
+	}
+
+	scope := "public"
+	if s.privateResponses {
+		scope = "private"
+	}
+	header := w.Header()
+	header.Set("Cache-Control", scope+", max-age="+strconv.Itoa(s.cacheMaxAgeSeconds))
+	header.Set("Vary", "Accept")
+	// Without data_version nothing identifies the data a snapshot holds, so
+	// send no validators rather than ones that would miss an update.
+	if snapshot.dataVersion < 0 {
+		return false
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d", r.URL.Path, format, snapshot.dataVersion)
+	for _, key := range keys {
+		fmt.Fprintf(h, "\x00%+v", key)
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	header.Set("ETag", etag)
+	var lastModified time.Time
+	if snapshot.versionUpdatedAt > 0 {
+		lastModified = time.UnixMilli(snapshot.versionUpdatedAt).UTC()
+		header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	// A 304 carries no body, so drop the JSON content type set upstream.
+	header.Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches applies the weak comparison If-None-Match uses.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModifiedHonoursValidators(t *testing.T) {
+	s := &apiServer{cacheMaxAgeSeconds: 5}
+	snapshot := marketSnapshot{dataVersion: 7, versionUpdatedAt: 1700000000000, refreshedAt: time.Unix(1700000000, 0), newestTS: 1699999200000}
+	params := volatilityParams{timeframe: "1h", threshold: 5}
+
+	request := func(url string, header map[string]string, snap marketSnapshot, p volatilityParams) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		if s.notModified(rec, r, snap, p) {
+			return rec
+		}
+		rec.WriteHeader(http.StatusOK)
+		return rec
+	}
+
+	first := request("/volatility", nil, snapshot, params)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first response = %d etag=%q, want 200 with an ETag", first.Code, etag)
+	}
+	if got := first.Header().Get("Cache-Control"); got != "public, max-age=5" {
+		t.Fatalf("Cache-Control = %q", got)
+	}
+	s.privateResponses = true
+	if got := request("/volatility", nil, snapshot, params).Header().Get("Cache-Control"); got != "private, max-age=5" {
+		t.Fatalf("Cache-Control with API key auth = %q", got)
+	}
+	s.privateResponses = false
+	if got := first.Header().Get("Last-Modified"); got != "Tue, 14 Nov 2023 22:13:20 GMT" {
+		t.Fatalf("Last-Modified = %q", got)
+	}
+
+	if rec := request("/volatility", map[string]string{"If-None-Match": "W/" + etag}, snapshot, params); rec.Code != http.StatusNotModified {
+		t.Fatalf("matching If-None-Match = %d, want 304", rec.Code)
+	}
+
+	rebuilt := snapshot
+	rebuilt.refreshedAt = rebuilt.refreshedAt.Add(time.Minute)
+	if rec := request("/volatility", map[string]string{"If-None-Match": etag}, rebuilt, params); rec.Code != http.StatusNotModified {
+		t.Fatalf("rebuilt snapshot with the same version = %d, want 304", rec.Code)
+	}
+	refreshed := snapshot
+	refreshed.dataVersion++
+	if rec := request("/volatility", map[string]string{"If-None-Match": etag}, refreshed, params); rec.Code != http.StatusOK {
+		t.Fatalf("new snapshot version = %d, want 200", rec.Code)
+	}
+	unversioned := snapshot
+	unversioned.dataVersion = -1
+	if rec := request("/volatility", map[string]string{"If-None-Match": "*"}, unversioned, params); rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" || rec.Header().Get("Last-Modified") != "" {
+		t.Fatalf("unversioned snapshot = %d %v, want 200 without validators", rec.Code, rec.Header())
+	}
+	other := params
+	other.limit = 10
+	if rec := request("/volatility", map[string]string{"If-None-Match": etag}, snapshot, other); rec.Code != http.StatusOK {
+		t.Fatalf("different params = %d, want 200", rec.Code)
+	}
+	if rec := request("/volatility?format=csv", map[string]string{"If-None-Match": etag}, snapshot, params); rec.Code != http.StatusOK {
+		t.Fatalf("different format = %d, want 200", rec.Code)
+	}
+
+	lastModified := first.Header().Get("Last-Modified")
+	if rec := request("/volatility", map[string]string{"If-Modified-Since": lastModified}, snapshot, params); rec.Code != http.StatusNotModified {
+		t.Fatalf("If-Modified-Since = %d, want 304", rec.Code)
+	}
+	// Rewriting the forming bar bumps data_version without a newer candle.
+	formingRewrite := refreshed
+	formingRewrite.versionUpdatedAt += 1000
+	if rec := request("/volatility", map[string]string{"If-Modified-Since": lastModified}, formingRewrite, params); rec.Code != http.StatusOK {
+		t.Fatalf("If-Modified-Since after a forming bar rewrite = %d, want 200", rec.Code)
+	}
+	stale := map[string]string{"If-Modified-Since": lastModified, "If-None-Match": `"other"`}
+	if rec := request("/volatility", stale, snapshot, params); rec.Code != http.StatusOK {
+		t.Fatalf("If-None-Match must take precedence over If-Modified-Since, got %d", rec.Code)
+	}
+}
+
+func TestWriteErrorDropsSuccessValidators(t *testing.T) {
+	s := &apiServer{cacheMaxAgeSeconds: 5}
+	rec := httptest.NewRecorder()
+	snapshot := marketSnapshot{dataVersion: 7, versionUpdatedAt: 1700000000000}
+	if s.notModified(rec, httptest.NewRequest(http.MethodGet, "/volatility", nil), snapshot) {
+		t.Fatal("unconditional request answered 304")
+	}
+	writeError(rec, http.StatusInternalServerError, "INTERNAL_ERROR", "failed")
+	for _, name := range []string{"ETag", "Last-Modified", "Cache-Control"} {
+		if got := rec.Header().Get(name); got != "" {
+			t.Errorf("error response %s = %q", name, got)
+		}
+	}
+}
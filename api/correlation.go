@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxMatrixSymbols = 50
+	// minCorrelationCoverage is the share of the window that must have
+	// returns present for both series before a pair is reported.
+	minCorrelationCoverage = 0.8
+)
+
+var correlationSortKeys = []string{"correlation_desc", "correlation_asc", "beta_desc", "beta_asc", "residual_desc", "residual_asc", "symbol_asc"}
+
+type correlationParams struct {
+	timeframe string
+	window    int
+	benchmark string
+	sort      string
+	limit     int
+	asOf      int64
+}
+
+// correlationStats describes y regressed on x: beta is the slope, residual is
+// the sample stdev of y - beta*x.
+type correlationStats struct {
+	correlation float64
+	beta        float64
+	residual    float64
+}
+
+func (s *apiServer) correlationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	params, perr := parseCorrelationParams(r.URL.Query(), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), params.timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "correlation query error", "timeframe", params.timeframe)
+		return
+	}
+	if s.notModified(w, r, snapshot, params) {
+		return
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, params.timeframe, params.asOf, params.window+1, nil)
+	if err != nil {
+		s.writeQueryError(w, r, err, "correlation query error", "timeframe", params.timeframe)
+		return
+	}
+	benchmark, ok := snapshot.seriesBySymbol[params.benchmark]
+	if !ok || len(benchmark) == 0 {
+		writeError(w, http.StatusNotFound, "SYMBOL_NOT_FOUND", fmt.Sprintf("ベンチマーク銘柄 %s のデータはありません", params.benchmark))
+		return
+	}
+
+	stepMS, err := timeframeStepMS(params.timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "correlation query error", "timeframe", params.timeframe)
+		return
+	}
+
+	var stats rankStats
+	items := make([]correlationItem, 0, len(snapshot.seriesBySymbol))
+	for symbol, candles := range snapshot.seriesBySymbol {
+		if symbol == params.benchmark {
+			continue
+		}
+		ys, xs := alignedLogReturns(candles, benchmark, snapshot.newestTS, stepMS, params.window)
+		if !enoughOverlap(len(xs), params.window) {
+			stats.skipped++
+			continue
+		}
+		stats.evaluated++
+		corr, ok := computeCorrelation(xs, ys)
+		if !ok {
+			continue
+		}
+		items = append(items, correlationItem{
+			Symbol:         symbol,
+			Benchmark:      params.benchmark,
+			Timeframe:      params.timeframe,
+			Window:         params.window,
+			Observations:   len(xs),
+			Correlation:    round4(corr.correlation),
+			Beta:           round4(corr.beta),
+			ResidualVolPct: round4(corr.residual * 100),
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		var va, vb float64
+		switch {
+		case strings.HasPrefix(params.sort, "beta"):
+			va, vb = a.Beta, b.Beta
+		case strings.HasPrefix(params.sort, "residual"):
+			va, vb = a.ResidualVolPct, b.ResidualVolPct
+		case params.sort == "symbol_asc":
+			return a.Symbol < b.Symbol
+		default:
+			va, vb = a.Correlation, b.Correlation
+		}
+		if va == vb {
+			return a.Symbol < b.Symbol
+		}
+		if strings.HasSuffix(params.sort, "_asc") {
+			return va < vb
+		}
+		return va > vb
+	})
+	stats.matched = len(items)
+	if params.limit < len(items) {
+		items = items[:params.limit]
+	}
+
+	writeData(w, r, http.StatusOK, correlationResponse{
+		Count: len(items),
+		AsOf:  params.asOf,
+		Data:  items,
+		Meta:  newResponseMeta(stats, params.echo(), map[string]marketSnapshot{params.timeframe: snapshot}),
+	})
+}
+
+func (s *apiServer) correlationMatrixHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	timeframe := strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, timeframe) {
+		writeError(w, http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", ")))
+		return
+	}
+	window, perr := parseCorrelationWindow(q, s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+	symbols, perr := parseSymbolList(q.Get("symbols"))
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "correlation matrix query error", "timeframe", timeframe)
+		return
+	}
+	if s.notModified(w, r, snapshot, timeframe, window, symbols, asOf) {
+		return
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, timeframe, asOf, window+1, symbols)
+	if err != nil {
+		s.writeQueryError(w, r, err, "correlation matrix query error", "timeframe", timeframe)
+		return
+	}
+	missing := make([]string, 0)
+	for _, symbol := range symbols {
+		if len(snapshot.seriesBySymbol[symbol]) == 0 {
+			missing = append(missing, symbol)
+		}
+	}
+	if len(missing) > 0 {
+		writeError(w, http.StatusNotFound, "SYMBOL_NOT_FOUND", fmt.Sprintf("銘柄 %s のデータはありません", strings.Join(missing, ", ")))
+		return
+	}
+
+	stepMS, err := timeframeStepMS(timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "correlation matrix query error", "timeframe", timeframe)
+		return
+	}
+
+	n := len(symbols)
+	resp := correlationMatrixResponse{
+		Timeframe:    timeframe,
+		Window:       window,
+		Symbols:      symbols,
+		Matrix:       make([][]*float64, n),
+		Observations: make([][]int, n),
+		AsOf:         asOf,
+	}
+	for i := range symbols {
+		resp.Matrix[i] = make([]*float64, n)
+		resp.Observations[i] = make([]int, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			xs, ys := alignedLogReturns(snapshot.seriesBySymbol[symbols[i]], snapshot.seriesBySymbol[symbols[j]], snapshot.newestTS, stepMS, window)
+			resp.Observations[i][j], resp.Observations[j][i] = len(xs), len(xs)
+			if !enoughOverlap(len(xs), window) {
+				continue
+			}
+			corr, ok := computeCorrelation(xs, ys)
+			if !ok {
+				continue
+			}
+			v := round4(corr.correlation)
+			resp.Matrix[i][j], resp.Matrix[j][i] = &v, &v
+		}
+	}
+
+	writeData(w, r, http.StatusOK, resp)
+}
+
+func parseCorrelationParams(q url.Values, historyLimit int) (correlationParams, *paramError) {
+	p := correlationParams{benchmark: "BTCUSDT", sort: "correlation_desc", limit: 100}
+
+	p.timeframe = strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, p.timeframe) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", "))}
+	}
+
+	var perr *paramError
+	if p.window, perr = parseCorrelationWindow(q, historyLimit); perr != nil {
+		return p, perr
+	}
+
+	if raw := strings.ToUpper(strings.TrimSpace(q.Get("benchmark"))); raw != "" {
+		p.benchmark = raw
+	}
+	if !symbolRegex.MatchString(p.benchmark) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_SYMBOL", "benchmark は英数字の銘柄シンボルを指定してください (例: BTCUSDT)"}
+	}
+
+	if raw := strings.TrimSpace(q.Get("sort")); raw != "" {
+		p.sort = raw
+	}
+	if !contains(correlationSortKeys, p.sort) {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("sort は %s のいずれかを指定してください", strings.Join(correlationSortKeys, "/"))}
+	}
+
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		var err error
+		p.limit, err = strconv.Atoi(raw)
+		if err != nil || p.limit <= 0 || p.limit > 500 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください"}
+		}
+	}
+
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		return p, perr
+	}
+	p.asOf = asOf
+
+	return p, nil
+}
+
+// parseCorrelationWindow reads the number of returns; window returns need
+// window+1 candles of history.
+func parseCorrelationWindow(q url.Values, historyLimit int) (int, *paramError) {
+	window := 100
+	if raw := strings.TrimSpace(q.Get("window")); raw != "" {
+		var err error
+		window, err = strconv.Atoi(raw)
+		if err != nil || window < 3 {
+			return 0, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "window は3以上の整数を指定してください"}
+		}
+	}
+	if window+1 > historyLimit {
+		msg := fmt.Sprintf("window (%d本) には %d本のローソク足が必要です。これは現在利用可能な履歴の最大本数(%d本)を超えています。", window, window+1, historyLimit)
+		return 0, &paramError{http.StatusBadRequest, "INSUFFICIENT_HISTORY", msg}
+	}
+	return window, nil
+}
+
+func parseSymbolList(raw string) ([]string, *paramError) {
+	symbols, perr := splitSymbols("symbols", raw)
+	if perr != nil {
+		return nil, perr
+	}
+	if len(symbols) < 2 || len(symbols) > maxMatrixSymbols {
+		return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("symbols はカンマ区切りで2以上%d以下の銘柄を指定してください", maxMatrixSymbols)}
+	}
+	return symbols, nil
+}
+
+// splitSymbols reads a comma separated symbol list named name, upper-casing
+// and dropping duplicates in order.
+func splitSymbols(name, raw string) ([]string, *paramError) {
+	seen := make(map[string]bool)
+	symbols := make([]string, 0)
+	for _, part := range strings.Split(raw, ",") {
+		symbol := strings.ToUpper(strings.TrimSpace(part))
+		if symbol == "" || seen[symbol] {
+			continue
+		}
+		if !symbolRegex.MatchString(symbol) {
+			return nil, &paramError{http.StatusBadRequest, "INVALID_SYMBOL", fmt.Sprintf("%s に不正な銘柄シンボルが含まれています: %s", name, symbol)}
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	return symbols, nil
+}
+
+func timeframeStepMS(timeframe string) (int64, error) {
+	tfMinutes, err := parseTimeframeToMinutes(timeframe)
+	if err != nil {
+		return 0, err
+	}
+	return int64(tfMinutes) * 60 * 1000, nil
+}
+
+// alignedLogReturns builds log returns on the step grid ending at newestTS.
+// A bar contributes only when both series have the candle and its
+// predecessor, so the two slices always describe the same intervals.
+func alignedLogReturns(a, b []marketCandle, newestTS, stepMS int64, window int) ([]float64, []float64) {
+	oldestTS := newestTS - int64(window)*stepMS
+	closes := func(candles []marketCandle) map[int64]float64 {
+		m := make(map[int64]float64, window+1)
+		for _, c := range candles {
+			if c.TS < oldestTS {
+				break
+			}
+			if c.Close > 0 {
+				m[c.TS] = c.Close
+			}
+		}
+		return m
+	}
+	ca, cb := closes(a), closes(b)
+
+	ra := make([]float64, 0, window)
+	rb := make([]float64, 0, window)
+	for k := 0; k < window; k++ {
+		ts := newestTS - int64(k)*stepMS
+		prevTS := ts - stepMS
+		a1, okA1 := ca[ts]
+		a0, okA0 := ca[prevTS]
+		b1, okB1 := cb[ts]
+		b0, okB0 := cb[prevTS]
+		if !okA1 || !okA0 || !okB1 || !okB0 {
+			continue
+		}
+		ra = append(ra, math.Log(a1/a0))
+		rb = append(rb, math.Log(b1/b0))
+	}
+	return ra, rb
+}
+
+func enoughOverlap(observations, window int) bool {
+	return observations >= 3 && float64(observations) >= minCorrelationCoverage*float64(window)
+}
+
+// computeCorrelation regresses ys on xs. ok is false when either series has
+// no variance.
+func computeCorrelation(xs, ys []float64) (correlationStats, bool) {
+	n := len(xs)
+	if n < 3 || len(ys) != n {
+		return correlationStats{}, false
+	}
+	meanX, meanY := 0.0, 0.0
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+
+	covXY, varX, varY := 0.0, 0.0, 0.0
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		covXY += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return correlationStats{}, false
+	}
+
+	stats := correlationStats{
+		correlation: covXY / math.Sqrt(varX*varY),
+		beta:        covXY / varX,
+	}
+	residuals := make([]float64, n)
+	for i := range xs {
+		residuals[i] = ys[i] - stats.beta*xs[i]
+	}
+	stats.residual = sampleStdev(residuals)
+	return stats, true
+}
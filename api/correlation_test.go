@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAlignedLogReturnsSkipsMissingBars(t *testing.T) {
+	a := []marketCandle{{TS: 400, Close: 4}, {TS: 300, Close: 3}, {TS: 200, Close: 2}, {TS: 100, Close: 1}}
+	b := []marketCandle{{TS: 400, Close: 8}, {TS: 200, Close: 4}, {TS: 100, Close: 2}}
+
+	ra, rb := alignedLogReturns(a, b, 400, 100, 3)
+
+	if len(ra) != 1 || len(rb) != 1 {
+		t.Fatalf("returns = %v / %v, want one aligned pair", ra, rb)
+	}
+	if math.Abs(ra[0]-math.Log(2)) > 1e-12 || math.Abs(rb[0]-math.Log(2)) > 1e-12 {
+		t.Fatalf("returns = %v / %v, want log(2) for the 100->200 bar", ra, rb)
+	}
+}
+
+func TestComputeCorrelationRecoversBeta(t *testing.T) {
+	xs := []float64{0.01, -0.02, 0.015, 0.005, -0.01}
+	ys := make([]float64, len(xs))
+	for i, x := range xs {
+		ys[i] = 2*x + 0.001
+	}
+
+	stats, ok := computeCorrelation(xs, ys)
+	if !ok {
+		t.Fatal("computeCorrelation returned !ok")
+	}
+	if math.Abs(stats.beta-2) > 1e-9 || math.Abs(stats.correlation-1) > 1e-9 || stats.residual > 1e-9 {
+		t.Fatalf("stats = %+v, want beta 2, correlation 1, residual 0", stats)
+	}
+
+	if _, ok := computeCorrelation(xs, make([]float64, len(xs))); ok {
+		t.Fatal("flat series should not produce a correlation")
+	}
+}
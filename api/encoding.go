@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	formatJSON    = "json"
+	formatCSV     = "csv"
+	formatNDJSON  = "ndjson"
+	formatMsgpack = "msgpack"
+)
+
+var responseFormats = []string{formatJSON, formatCSV, formatNDJSON, formatMsgpack}
+
+var formatContentTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatCSV:     "text/csv; charset=utf-8",
+	formatNDJSON:  "application/x-ndjson",
+	formatMsgpack: "application/msgpack",
+}
+
+var acceptMediaTypes = map[string]string{
+	"application/json":        formatJSON,
+	"text/csv":                formatCSV,
+	"application/x-ndjson":    formatNDJSON,
+	"application/ndjson":      formatNDJSON,
+	"application/msgpack":     formatMsgpack,
+	"application/x-msgpack":   formatMsgpack,
+	"application/vnd.msgpack": formatMsgpack,
+}
+
+// negotiateFormat picks the response encoding. An explicit format= wins over
+// Accept; an Accept header naming nothing we support falls back to JSON.
+func negotiateFormat(r *http.Request) (string, *paramError) {
+	if raw := strings.TrimSpace(r.URL.Query().Get("format")); raw != "" {
+		if !contains(responseFormats, raw) {
+			return "", &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("format は %s のいずれかを指定してください", strings.Join(responseFormats, "/"))}
+		}
+		return raw, nil
+	}
+
+	best, bestQ := formatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := acceptMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, nil
+}
+
+// writeData writes a successful data response in the negotiated format.
+// Errors always use writeError so clients get the JSON envelope regardless.
+func writeData(w http.ResponseWriter, r *http.Request, status int, payload any) {
+	format, perr := negotiateFormat(r)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	_, span := tracer.Start(r.Context(), "encode", trace.WithAttributes(attribute.String("format", format)))
+	defer span.End()
+	if format == formatJSON {
+		writeJSONStatus(w, status, payload)
+		return
+	}
+
+	body, err := encodePayload(format, payload, w.Header())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode response", "format", format, "err", err)
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal error")
+		return
+	}
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// encodePayload goes through the JSON encoding so every format sees the same
+// field names, omissions and rounding as the JSON response.
+func encodePayload(format string, payload any, header http.Header) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tree, err := decodeOrderedJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == formatMsgpack {
+		var buf bytes.Buffer
+		err := writeMsgpack(&buf, tree)
+		return buf.Bytes(), err
+	}
+
+	rows := dataRows(tree)
+	if top, ok := tree.(*orderedObject); ok {
+		if cursor, ok := top.get("next_cursor").(string); ok {
+			header.Set("X-Next-Cursor", cursor)
+		}
+	}
+	if format == formatNDJSON {
+		var buf bytes.Buffer
+		for _, row := range rows {
+			line, err := json.Marshal(row)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+	return encodeCSV(rows)
+}
+
+// dataRows returns the elements of a top-level "data" array, or the payload
+// itself as a single row for endpoints that return one object.
+func dataRows(tree any) []any {
+	if top, ok := tree.(*orderedObject); ok {
+		if data, ok := top.get("data").([]any); ok {
+			return data
+		}
+	}
+	return []any{tree}
+}
+
+// encodeCSV flattens nested objects into dotted columns (price.close) and
+// writes arrays as JSON text. Columns are the union over all rows in
+// first-seen order; missing values are empty.
+func encodeCSV(rows []any) ([]byte, error) {
+	var columns []string
+	index := make(map[string]int)
+	records := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		record := make(map[string]string)
+		var cols []string
+		if err := flattenCSV("", row, record, &cols); err != nil {
+			return nil, err
+		}
+		for _, col := range cols {
+			if _, ok := index[col]; !ok {
+				index[col] = len(columns)
+				columns = append(columns, col)
+			}
+		}
+		records = append(records, record)
+	}
+
+	var buf bytes.Buffer
+	if len(columns) == 0 {
+		return buf.Bytes(), nil
+	}
+	cw := csv.NewWriter(&buf)
+	if err := cw.Write(columns); err != nil {
+		return nil, err
+	}
+	line := make([]string, len(columns))
+	for _, record := range records {
+		for i, col := range columns {
+			line[i] = record[col]
+		}
+		if err := cw.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+func flattenCSV(prefix string, v any, record map[string]string, cols *[]string) error {
+	set := func(value string) {
+		key := prefix
+		if key == "" {
+			key = "value"
+		}
+		*cols = append(*cols, key)
+		record[key] = value
+	}
+	switch val := v.(type) {
+	case *orderedObject:
+		for i, key := range val.keys {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			if err := flattenCSV(name, val.values[i], record, cols); err != nil {
+				return err
+			}
+		}
+	case []any:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		set(string(data))
+	case nil:
+		set("")
+	case bool:
+		set(strconv.FormatBool(val))
+	case json.Number:
+		set(val.String())
+	case string:
+		set(val)
+	default:
+		return fmt.Errorf("unexpected JSON value %T", v)
+	}
+	return nil
+}
+
+// orderedObject is a decoded JSON object that keeps its key order, so CSV
+// columns and re-encoded rows follow the struct field order.
+type orderedObject struct {
+	keys   []string
+	values []any
+}
+
+func (o *orderedObject) get(key string) any {
+	for i, k := range o.keys {
+		if k == key {
+			return o.values[i]
+		}
+	}
+	return nil
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrderedJSON reads one JSON value. Objects become *orderedObject,
+// arrays []any and numbers json.Number (the decoder must use UseNumber).
+func decodeOrderedJSON(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := &orderedObject{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrderedJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.keys = append(obj.keys, keyTok.(string))
+				obj.values = append(obj.values, value)
+			}
+			_, err := dec.Token()
+			return obj, err
+		case '[':
+			arr := []any{}
+			for dec.More() {
+				value, err := decodeOrderedJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err := dec.Token()
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	default:
+		return tok, nil
+	}
+}
+
+// writeMsgpack encodes a decoded JSON tree as MessagePack. Integral numbers
+// use the smallest integer encoding; everything else is a float64.
+func writeMsgpack(w io.Writer, v any) error {
+	var buf [9]byte
+	switch val := v.(type) {
+	case nil:
+		_, err := w.Write([]byte{0xc0})
+		return err
+	case bool:
+		b := byte(0xc2)
+		if val {
+			b = 0xc3
+		}
+		_, err := w.Write([]byte{b})
+		return err
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return writeMsgpackInt(w, i)
+		}
+		f, err := val.Float64()
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return err
+		}
+		buf[0] = 0xcb
+		binary.BigEndian.PutUint64(buf[1:], math.Float64bits(f))
+		_, err = w.Write(buf[:9])
+		return err
+	case string:
+		if err := writeMsgpackHeader(w, len(val), 0xa0, 32, 0xd9, 0xda, 0xdb); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, val)
+		return err
+	case []any:
+		if err := writeMsgpackHeader(w, len(val), 0x90, 16, 0, 0xdc, 0xdd); err != nil {
+			return err
+		}
+		for _, item := range val {
+			if err := writeMsgpack(w, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *orderedObject:
+		if err := writeMsgpackHeader(w, len(val.keys), 0x80, 16, 0, 0xde, 0xdf); err != nil {
+			return err
+		}
+		for i, key := range val.keys {
+			if err := writeMsgpack(w, key); err != nil {
+				return err
+			}
+			if err := writeMsgpack(w, val.values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected JSON value %T", v)
+}
+
+// writeMsgpackHeader writes a length-prefixed type header: the fix form when
+// n < fixLimit, otherwise the 8 (if available), 16 or 32 bit form.
+func writeMsgpackHeader(w io.Writer, n int, fix byte, fixLimit int, code8, code16, code32 byte) error {
+	var buf [5]byte
+	switch {
+	case n < fixLimit:
+		buf[0] = fix | byte(n)
+		_, err := w.Write(buf[:1])
+		return err
+	case code8 != 0 && n <= math.MaxUint8:
+		buf[0], buf[1] = code8, byte(n)
+		_, err := w.Write(buf[:2])
+		return err
+	case n <= math.MaxUint16:
+		buf[0] = code16
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		_, err := w.Write(buf[:3])
+		return err
+	default:
+		buf[0] = code32
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		_, err := w.Write(buf[:5])
+		return err
+	}
+}
+
+func writeMsgpackInt(w io.Writer, i int64) error {
+	var buf [9]byte
+	var n int
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		buf[0], n = byte(i), 1
+	case i < 0 && i >= -32:
+		buf[0], n = byte(i), 1
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf[0], buf[1], n = 0xd0, byte(i), 2
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf[0], n = 0xd1, 3
+		binary.BigEndian.PutUint16(buf[1:], uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf[0], n = 0xd2, 5
+		binary.BigEndian.PutUint32(buf[1:], uint32(i))
+	default:
+		buf[0], n = 0xd3, 9
+		binary.BigEndian.PutUint64(buf[1:], uint64(i))
+	}
+	_, err := w.Write(buf[:n])
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		url, accept, want string
+	}{
+		{"/volatility", "", formatJSON},
+		{"/volatility", "*/*", formatJSON},
+		{"/volatility", "text/csv", formatCSV},
+		{"/volatility", "application/json;q=0.5, application/x-msgpack", formatMsgpack},
+		{"/volatility", "text/csv;q=0.2, application/x-ndjson;q=0.8", formatNDJSON},
+		{"/volatility", "text/html", formatJSON},
+		{"/volatility?format=csv", "application/msgpack", formatCSV},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		r.Header.Set("Accept", tc.accept)
+		got, perr := negotiateFormat(r)
+		if perr != nil || got != tc.want {
+			t.Fatalf("negotiateFormat(%q, Accept=%q) = (%q, %v), want %q", tc.url, tc.accept, got, perr, tc.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/volatility?format=xml", nil)
+	if _, perr := negotiateFormat(r); perr == nil || perr.code != "INVALID_INPUT" {
+		t.Fatalf("format=xml error = %v, want INVALID_INPUT", perr)
+	}
+}
+
+func TestEncodePayloadCSVFlattensRows(t *testing.T) {
+	a := volatilityItem{Symbol: "AAAUSDT", Timeframe: "1h", CandleTS: 100}
+	a.Price.Close = 1.5
+	a.Change.Pct = 3.25
+	a.Change.Direction = "up"
+	b := a
+	b.Symbol = "BBB,USDT"
+	b.Volatility = &volatilityMeasure{Metric: "atr_pct", Value: 2, Bars: 14}
+
+	body, err := encodePayload(formatCSV, volatilityResponse{Count: 2, Data: []volatilityItem{a, b}}, http.Header{})
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+	want := "symbol,timeframe,candle_ts,price.close,price.prev_close,change.pct,change.direction,volatility.metric,volatility.value,volatility.bars,volatility.annualized\n" +
+		"AAAUSDT,1h,100,1.5,0,3.25,up,,,,\n" +
+		"\"BBB,USDT\",1h,100,1.5,0,3.25,up,atr_pct,2,14,false\n"
+	if string(body) != want {
+		t.Fatalf("csv =\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestEncodePayloadMsgpack(t *testing.T) {
+	body, err := encodePayload(formatMsgpack, map[string]any{"a": []any{1, -5, 300, 1.5, nil, true, "x"}}, http.Header{})
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+	want := []byte{
+		0x81, 0xa1, 'a',
+		0x97, 0x01, 0xfb, 0xd1, 0x01, 0x2c,
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xc0, 0xc3, 0xa1, 'x',
+	}
+	if !bytes.Equal(body, want) {
+		t.Fatalf("msgpack = % x, want % x", body, want)
+	}
+}
+
+func TestWriteDataKeepsJSONErrorsAndNextCursor(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/candles?format=ndjson", nil)
+	writeData(rec, r, http.StatusOK, candlesResponse{Symbol: "AAAUSDT", Timeframe: "1h", Count: 1, Data: []candleItem{{TS: 1}}, NextCursor: "abc"})
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Fatalf("content type = %q", got)
+	}
+	if got := rec.Header().Get("X-Next-Cursor"); got != "abc" {
+		t.Fatalf("X-Next-Cursor = %q, want abc", got)
+	}
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], `{"ts":1,`) {
+		t.Fatalf("ndjson body = %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/candles?format=yaml", nil)
+	writeData(rec, r, http.StatusOK, candlesResponse{})
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"code":"INVALID_INPUT"`) {
+		t.Fatalf("unsupported format response = %d %s, want JSON INVALID_INPUT", rec.Code, rec.Body.String())
+	}
+}
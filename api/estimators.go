@@ -0,0 +1,144 @@
+package main
+
+import "math"
+
+const (
+	metricChange         = "change"
+	metricRangePct       = "range_pct"
+	metricATRPct         = "atr_pct"
+	metricStdev          = "stdev"
+	metricParkinson      = "parkinson"
+	metricGarmanKlass    = "garman_klass"
+	metricRogersSatchell = "rogers_satchell"
+)
+
+var (
+	volatilityMetrics = []string{metricChange, metricRangePct, metricATRPct, metricStdev, metricParkinson, metricGarmanKlass, metricRogersSatchell}
+	// annualizableMetrics are per-bar standard deviation estimators, which
+	// scale with the square root of the number of bars per year.
+	annualizableMetrics = []string{metricStdev, metricParkinson, metricGarmanKlass, metricRogersSatchell}
+)
+
+// computeVolatilityMetric evaluates metric over the newest bars candles of a
+// newest-first series. Estimators that need the previous close (ATR and log
+// returns) read one extra candle. All results are percentages; ok is false
+// when the series is too short or contains non-positive prices.
+func computeVolatilityMetric(candles []marketCandle, metric string, bars int) (float64, bool) {
+	if bars <= 0 || len(candles) <= bars {
+		return 0, false
+	}
+	window := candles[:bars]
+
+	switch metric {
+	case metricChange:
+		prev := candles[bars].Close
+		if prev == 0 {
+			return 0, false
+		}
+		return (candles[0].Close - prev) / prev * 100, true
+
+	case metricRangePct:
+		high, low := window[0].High, window[0].Low
+		for _, c := range window[1:] {
+			high = math.Max(high, c.High)
+			low = math.Min(low, c.Low)
+		}
+		if low <= 0 {
+			return 0, false
+		}
+		return (high - low) / low * 100, true
+
+	case metricATRPct:
+		sum := 0.0
+		for i, c := range window {
+			prevClose := candles[i+1].Close
+			tr := math.Max(c.High-c.Low, math.Max(math.Abs(c.High-prevClose), math.Abs(c.Low-prevClose)))
+			sum += tr
+		}
+		if candles[0].Close <= 0 {
+			return 0, false
+		}
+		return sum / float64(bars) / candles[0].Close * 100, true
+
+	case metricStdev:
+		if bars < 2 {
+			return 0, false
+		}
+		returns := make([]float64, 0, bars)
+		for i := range window {
+			if candles[i].Close <= 0 || candles[i+1].Close <= 0 {
+				return 0, false
+			}
+			returns = append(returns, math.Log(candles[i].Close/candles[i+1].Close))
+		}
+		return sampleStdev(returns) * 100, true
+
+	case metricParkinson:
+		sum := 0.0
+		for _, c := range window {
+			if !positiveOHLC(c) {
+				return 0, false
+			}
+			hl := math.Log(c.High / c.Low)
+			sum += hl * hl
+		}
+		return math.Sqrt(sum/(4*math.Ln2*float64(bars))) * 100, true
+
+	case metricGarmanKlass:
+		sum := 0.0
+		for _, c := range window {
+			if !positiveOHLC(c) {
+				return 0, false
+			}
+			hl := math.Log(c.High / c.Low)
+			co := math.Log(c.Close / c.Open)
+			sum += 0.5*hl*hl - (2*math.Ln2-1)*co*co
+		}
+		if sum < 0 {
+			sum = 0
+		}
+		return math.Sqrt(sum/float64(bars)) * 100, true
+
+	case metricRogersSatchell:
+		sum := 0.0
+		for _, c := range window {
+			if !positiveOHLC(c) {
+				return 0, false
+			}
+			sum += math.Log(c.High/c.Close)*math.Log(c.High/c.Open) + math.Log(c.Low/c.Close)*math.Log(c.Low/c.Open)
+		}
+		if sum < 0 {
+			sum = 0
+		}
+		return math.Sqrt(sum/float64(bars)) * 100, true
+	}
+	return 0, false
+}
+
+// annualizationFactor is sqrt(bars per year) for a market trading 24/7.
+func annualizationFactor(timeframeMinutes int) float64 {
+	if timeframeMinutes <= 0 {
+		return 1
+	}
+	return math.Sqrt(365 * 24 * 60 / float64(timeframeMinutes))
+}
+
+func positiveOHLC(c marketCandle) bool {
+	return c.Open > 0 && c.High > 0 && c.Low > 0 && c.Close > 0
+}
+
+func sampleStdev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	sq := 0.0
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(values)-1))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeVolatilityMetric(t *testing.T) {
+	// Newest first. Log returns are ln(100/110) and ln(110/100).
+	candles := []marketCandle{
+		{Open: 110, High: 112, Low: 99, Close: 100},
+		{Open: 100, High: 111, Low: 100, Close: 110},
+		{Open: 100, High: 100, Low: 100, Close: 100},
+	}
+	r := math.Log(1.1)
+
+	tests := []struct {
+		metric string
+		bars   int
+		want   float64
+	}{
+		{metricChange, 2, 0},
+		{metricRangePct, 2, (112.0 - 99.0) / 99.0 * 100},
+		{metricATRPct, 2, (13.0 + 11.0) / 2 / 100 * 100},
+		{metricStdev, 2, math.Sqrt(2*r*r) * 100},
+		{metricParkinson, 1, math.Sqrt(math.Pow(math.Log(112.0/99.0), 2)/(4*math.Ln2)) * 100},
+		{metricGarmanKlass, 1, math.Sqrt(0.5*math.Pow(math.Log(112.0/99.0), 2)-(2*math.Ln2-1)*math.Pow(math.Log(100.0/110.0), 2)) * 100},
+		{metricRogersSatchell, 1, math.Sqrt(math.Log(112.0/100)*math.Log(112.0/110)+math.Log(99.0/100)*math.Log(99.0/110)) * 100},
+	}
+	for _, tt := range tests {
+		got, ok := computeVolatilityMetric(candles, tt.metric, tt.bars)
+		if !ok {
+			t.Fatalf("%s: ok = false", tt.metric)
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", tt.metric, got, tt.want)
+		}
+	}
+
+	if _, ok := computeVolatilityMetric(candles, metricStdev, 3); ok {
+		t.Errorf("stdev over the full series should need one more candle")
+	}
+}
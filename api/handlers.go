@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// statusClientClosedRequest follows nginx's convention for requests the
+// client abandoned before a response was written.
+const statusClientClosedRequest = 499
+
+// defaultLiquidityPeriod is the /volatility liquidity window used when a
+// floor is given without liquidity_period.
+const defaultLiquidityPeriod = "24h"
+
 func (s *apiServer) rootHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
@@ -18,101 +29,288 @@ func (s *apiServer) rootHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"message": "Welcome to CMMA API v2. See /volatility/docs for details."})
 }
 
+func (s *apiServer) readyHandler(w http.ResponseWriter, r *http.Request) {
+	resp := readyResponse{Status: "ready", Timeframes: make(map[string]timeframeReady, len(s.cacheTimeframes))}
+	if s.draining.Load() {
+		resp.Status = "draining"
+	}
+	for tf, refreshedAt := range s.marketCache.loadState(s.cacheTimeframes) {
+		entry := timeframeReady{Loaded: !refreshedAt.IsZero()}
+		if entry.Loaded {
+			entry.RefreshedAt = refreshedAt.Format(time.RFC3339)
+		} else if resp.Status == "ready" {
+			resp.Status = "warming"
+		}
+		resp.Timeframes[tf] = entry
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+// writeQueryError answers a failed query: 503 with Retry-After while the
+// cache is warming, 499 without a body when the client has gone away,
+// otherwise a logged 500. attrs are logged with the error.
+func (s *apiServer) writeQueryError(w http.ResponseWriter, r *http.Request, err error, msg string, attrs ...any) {
+	if r.Context().Err() != nil && errors.Is(err, r.Context().Err()) {
+		s.logger.DebugContext(r.Context(), "request cancelled", attrs...)
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	var rangeErr *asOfRangeError
+	if errors.As(err, &rangeErr) {
+		msg := fmt.Sprintf("as_of の時点の計算に必要なローソク足はデータベースの保持期間 (各銘柄 OHLCV_HISTORY_LIMIT 本) より前です。as_of には %s 以降を指定してください", time.UnixMilli(rangeErr.earliestMS).UTC().Format(time.RFC3339))
+		writeError(w, http.StatusUnprocessableEntity, "INSUFFICIENT_HISTORY", msg)
+		return
+	}
+	if errors.Is(err, errCacheWarming) {
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfterSeconds))
+		writeError(w, http.StatusServiceUnavailable, "CACHE_WARMING", "データを読み込み中です。しばらくしてから再試行してください")
+		return
+	}
+	s.logger.ErrorContext(r.Context(), msg, append(attrs, "err", err)...)
+	writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal server error")
+}
+
 func (s *apiServer) volatilityHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
 		return
 	}
 
-	timeframe := strings.TrimSpace(r.URL.Query().Get("timeframe"))
-	if !contains(validTimeframes, timeframe) {
-		writeError(w, http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", ")))
+	params, perr := parseVolatilityParams(r.URL.Query(), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
 		return
 	}
 
-	threshold, err := parsePositiveFloat(r.URL.Query().Get("threshold"))
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), params.timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "volatility query error", "timeframe", params.timeframe)
+		return
+	}
+	if s.notModified(w, r, snapshot, params) {
+		return
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, params.timeframe, params.asOf, maxInt(params.offset, params.liquidityBars)+1, nil)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "INVALID_INPUT", "threshold は0より大きい有限の数値を指定してください")
+		s.writeQueryError(w, r, err, "volatility query error", "timeframe", params.timeframe)
 		return
 	}
 
-	offset := 1
-	if offsetRaw := strings.TrimSpace(r.URL.Query().Get("offset")); offsetRaw != "" {
-		offset, err = strconv.Atoi(offsetRaw)
-		if err != nil || offset <= 0 {
-			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "offset は1以上の整数を指定してください")
-			return
+	_, span := tracer.Start(r.Context(), "rankVolatility")
+	items, stats, queryErr := rankVolatility(snapshot, params)
+	span.End()
+	if queryErr != nil {
+		s.writeQueryError(w, r, queryErr, "volatility query error", "timeframe", params.timeframe)
+		return
+	}
+
+	writeData(w, r, http.StatusOK, volatilityResponse{
+		Count: len(items),
+		AsOf:  params.asOf,
+		Data:  items,
+		Meta:  newResponseMeta(stats, params.echo(), map[string]marketSnapshot{params.timeframe: snapshot}),
+	})
+}
+
+// parseVolatilityParams validates the /volatility query string.
+func parseVolatilityParams(q url.Values, historyLimit int) (volatilityParams, *paramError) {
+	p := volatilityParams{offset: 1, direction: "both", sort: "volatility_desc", limit: 100, metric: metricChange, liquidityBars: 1}
+
+	p.timeframe = strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, p.timeframe) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", "))}
+	}
+
+	var err error
+	p.threshold, err = parsePositiveFloat(q.Get("threshold"))
+	if err != nil {
+		return p, &paramError{http.StatusBadRequest, "INVALID_INPUT", "threshold は0より大きい有限の数値を指定してください"}
+	}
+
+	if offsetRaw := strings.TrimSpace(q.Get("offset")); offsetRaw != "" {
+		p.offset, err = strconv.Atoi(offsetRaw)
+		if err != nil || p.offset <= 0 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "offset は1以上の整数を指定してください"}
 		}
 	}
 
-	direction := strings.TrimSpace(r.URL.Query().Get("direction"))
-	if direction == "" {
-		direction = "both"
+	if direction := strings.TrimSpace(q.Get("direction")); direction != "" {
+		p.direction = direction
 	}
-	if direction != "up" && direction != "down" && direction != "both" {
-		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "direction は up/down/both のいずれかを指定してください")
-		return
+	if p.direction != "up" && p.direction != "down" && p.direction != "both" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "direction は up/down/both のいずれかを指定してください"}
 	}
 
-	sort := strings.TrimSpace(r.URL.Query().Get("sort"))
-	if sort == "" {
-		sort = "volatility_desc"
+	if sortKey := strings.TrimSpace(q.Get("sort")); sortKey != "" {
+		p.sort = sortKey
 	}
-	if sort != "volatility_desc" && sort != "volatility_asc" && sort != "symbol_asc" {
-		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "sort は volatility_desc/volatility_asc/symbol_asc のいずれかを指定してください")
-		return
+	if p.sort != "volatility_desc" && p.sort != "volatility_asc" && p.sort != "symbol_asc" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "sort は volatility_desc/volatility_asc/symbol_asc のいずれかを指定してください"}
 	}
 
-	limit := 100
-	if limitRaw := strings.TrimSpace(r.URL.Query().Get("limit")); limitRaw != "" {
-		limit, err = strconv.Atoi(limitRaw)
-		if err != nil || limit <= 0 || limit > 500 {
-			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください")
-			return
+	if limitRaw := strings.TrimSpace(q.Get("limit")); limitRaw != "" {
+		p.limit, err = strconv.Atoi(limitRaw)
+		if err != nil || p.limit <= 0 || p.limit > 500 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください"}
 		}
 	}
 
-	items, queryErr := s.queryVolatility(timeframe, threshold, offset, direction, sort, limit)
-	if queryErr != nil {
-		s.logger.Printf("volatility query error timeframe=%s: %v", timeframe, queryErr)
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal server error")
-		return
+	if metric := strings.TrimSpace(q.Get("metric")); metric != "" {
+		p.metric = metric
+	}
+	if !contains(volatilityMetrics, p.metric) {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("metric は %s のいずれかを指定してください", strings.Join(volatilityMetrics, "/"))}
+	}
+	if p.metric == metricStdev && p.offset < 2 {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "metric=stdev では offset に2以上を指定してください"}
+	}
+
+	if raw := strings.TrimSpace(q.Get("annualize")); raw != "" {
+		if raw != "true" && raw != "false" {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "annualize は true/false のいずれかを指定してください"}
+		}
+		p.annualize = raw == "true"
+	}
+	if p.annualize && !contains(annualizableMetrics, p.metric) {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("annualize は metric が %s の場合のみ指定できます", strings.Join(annualizableMetrics, "/"))}
 	}
 
-	writeJSON(w, http.StatusOK, volatilityResponse{Count: len(items), Data: items})
+	if raw := strings.TrimSpace(q.Get("min_turnover")); raw != "" {
+		p.minTurnover, err = parsePositiveFloat(raw)
+		if err != nil {
+			return p, &paramError{http.StatusBadRequest, "INVALID_INPUT", "min_turnover は0より大きい有限の数値を指定してください"}
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("min_volume")); raw != "" {
+		p.minVolume, err = parsePositiveFloat(raw)
+		if err != nil {
+			return p, &paramError{http.StatusBadRequest, "INVALID_INPUT", "min_volume は0より大きい有限の数値を指定してください"}
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("liquidity_period")); raw != "" {
+		if p.minTurnover == 0 && p.minVolume == 0 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "liquidity_period は min_turnover または min_volume と併せて指定してください"}
+		}
+		if !contains(validPeriods, raw) {
+			return p, &paramError{http.StatusBadRequest, "INVALID_PERIOD", fmt.Sprintf("無効な期間指定です。有効な値: %s", strings.Join(validPeriods, ", "))}
+		}
+		bars, perr := periodBars(p.timeframe, raw, math.MaxInt32)
+		if perr != nil {
+			return p, perr
+		}
+		// liquid skips the forming candle, so the period needs one bar more.
+		if bars+1 > historyLimit {
+			msg := fmt.Sprintf("指定された liquidity_period (%s) とタイムフレーム (%s) の組み合わせでは、形成中の足を除いた確定足が%d本必要です。これは現在利用可能な確定足の最大本数(%d本)を超えています。より短い期間、またはより大きなタイムフレームを選択してください。", raw, p.timeframe, bars, historyLimit-1)
+			return p, &paramError{http.StatusBadRequest, "INSUFFICIENT_HISTORY", msg}
+		}
+		p.liquidityPeriod, p.liquidityBars = raw, bars
+	} else if p.minTurnover > 0 || p.minVolume > 0 {
+		// Judge the floors on a full day of closed bars rather than on the
+		// forming candle, which is nearly empty right after it opens.
+		bars, perr := periodBars(p.timeframe, defaultLiquidityPeriod, math.MaxInt32)
+		if perr != nil {
+			return p, perr
+		}
+		p.liquidityBars = maxInt(minInt(bars, historyLimit-1), 1)
+	}
+
+	var perr *paramError
+	if p.symbols, perr = splitSymbols("symbols", q.Get("symbols")); perr != nil {
+		return p, perr
+	}
+	if p.exclude, perr = splitSymbols("exclude", q.Get("exclude")); perr != nil {
+		return p, perr
+	}
+	if raw := strings.ToUpper(strings.TrimSpace(q.Get("base"))); raw != "" {
+		if !symbolRegex.MatchString(raw) {
+			return p, &paramError{http.StatusBadRequest, "INVALID_SYMBOL", "base は英数字で指定してください (例: BTC)"}
+		}
+		p.base = raw
+	}
+
+	if raw := strings.TrimSpace(q.Get("max_pct")); raw != "" {
+		p.maxPct, err = parsePositiveFloat(raw)
+		if err != nil || p.maxPct < p.threshold {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "max_pct には threshold 以上の有限の数値を指定してください"}
+		}
+	}
+
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		return p, perr
+	}
+	p.asOf = asOf
+
+	return p, nil
 }
 
-func (s *apiServer) queryVolatility(timeframe string, threshold float64, offset int, direction, sortKey string, limit int) ([]volatilityItem, error) {
-	snapshot, err := s.marketCache.getSnapshot(timeframe)
+func (s *apiServer) queryVolatility(ctx context.Context, p volatilityParams) ([]volatilityItem, error) {
+	snapshot, err := s.marketCache.getSnapshot(ctx, p.timeframe)
 	if err != nil {
 		return nil, err
 	}
+	items, _, err := rankVolatility(snapshot, p)
+	return items, err
+}
+
+// rankVolatility applies the /volatility filters and ordering to a snapshot.
+func rankVolatility(snapshot marketSnapshot, p volatilityParams) ([]volatilityItem, rankStats, error) {
+	var stats rankStats
+	scale := 1.0
+	if p.annualize {
+		tfMinutes, err := parseTimeframeToMinutes(p.timeframe)
+		if err != nil {
+			return nil, stats, err
+		}
+		scale = annualizationFactor(tfMinutes)
+	}
 
 	items := make([]volatilityItem, 0, len(snapshot.seriesBySymbol))
 	for symbol, candles := range snapshot.seriesBySymbol {
-		if len(candles) <= offset {
+		if !p.includes(symbol) {
 			continue
 		}
+		if len(candles) <= p.offset {
+			stats.skipped++
+			continue
+		}
+		stats.evaluated++
 		latest := candles[0]
-		prev := candles[offset]
+		prev := candles[p.offset]
 		if prev.Close == 0 {
 			continue
 		}
 
 		pct := ((latest.Close - prev.Close) / prev.Close) * 100
-		if math.Abs(pct) < threshold {
+		value := math.Abs(pct)
+		if p.metric != metricChange {
+			v, ok := computeVolatilityMetric(candles, p.metric, p.offset)
+			if !ok {
+				continue
+			}
+			value = v * scale
+		}
+		if value < p.threshold || (p.maxPct > 0 && value > p.maxPct) {
+			continue
+		}
+		if !p.liquid(candles) {
 			continue
 		}
-		if direction == "up" && pct <= 0 {
+		if p.direction == "up" && pct <= 0 {
 			continue
 		}
-		if direction == "down" && pct >= 0 {
+		if p.direction == "down" && pct >= 0 {
 			continue
 		}
 
 		item := volatilityItem{
 			Symbol:    symbol,
-			Timeframe: timeframe,
+			Timeframe: p.timeframe,
 			CandleTS:  latest.TS,
 		}
 		item.Price.Close = latest.Close
@@ -123,30 +321,61 @@ func (s *apiServer) queryVolatility(timeframe string, threshold float64, offset
 		} else {
 			item.Change.Direction = "down"
 		}
+		if p.metric != metricChange {
+			item.Volatility = &volatilityMeasure{Metric: p.metric, Value: round4(value), Bars: p.offset, Annualized: p.annualize}
+		}
 		items = append(items, item)
 	}
 
 	sort.Slice(items, func(i, j int) bool {
-		switch sortKey {
+		vi, vj := items[i].sortValue(), items[j].sortValue()
+		switch p.sort {
 		case "volatility_asc":
-			if items[i].Change.Pct == items[j].Change.Pct {
+			if vi == vj {
 				return items[i].Symbol < items[j].Symbol
 			}
-			return items[i].Change.Pct < items[j].Change.Pct
+			return vi < vj
 		case "symbol_asc":
 			return items[i].Symbol < items[j].Symbol
 		default:
-			if items[i].Change.Pct == items[j].Change.Pct {
+			if vi == vj {
 				return items[i].Symbol < items[j].Symbol
 			}
-			return items[i].Change.Pct > items[j].Change.Pct
+			return vi > vj
 		}
 	})
 
-	if limit < len(items) {
-		items = items[:limit]
+	stats.matched = len(items)
+	if p.limit < len(items) {
+		items = items[:p.limit]
+	}
+	return items, stats, nil
+}
+
+// includes applies the symbols, exclude and base filters.
+func (p volatilityParams) includes(symbol string) bool {
+	if len(p.symbols) > 0 && !contains(p.symbols, symbol) {
+		return false
+	}
+	if contains(p.exclude, symbol) {
+		return false
+	}
+	return strings.HasPrefix(symbol, p.base)
+}
+
+// liquid reports whether the liquidityBars closed candles before the forming
+// one meet the turnover and volume floors.
+func (p volatilityParams) liquid(candles []marketCandle) bool {
+	if p.minTurnover == 0 && p.minVolume == 0 {
+		return true
+	}
+	var turnover, volume float64
+	closed := candles[minInt(1, len(candles)):]
+	for _, c := range closed[:minInt(p.liquidityBars, len(closed))] {
+		turnover += c.Turnover
+		volume += c.Volume
 	}
-	return items, nil
+	return turnover >= p.minTurnover && volume >= p.minVolume
 }
 
 func (s *apiServer) volumeHandler(w http.ResponseWriter, r *http.Request) {
@@ -155,99 +384,172 @@ func (s *apiServer) volumeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	timeframe := strings.TrimSpace(r.URL.Query().Get("timeframe"))
-	if !contains(validTimeframes, timeframe) {
-		writeError(w, http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", ")))
+	params, perr := parseVolumeParams(r.URL.Query(), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
 		return
 	}
 
-	period := strings.TrimSpace(r.URL.Query().Get("period"))
-	if !contains(validPeriods, period) {
-		writeError(w, http.StatusBadRequest, "INVALID_PERIOD", fmt.Sprintf("無効な期間指定です。有効な値: %s", strings.Join(validPeriods, ", ")))
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), params.timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "volume query error", "timeframe", params.timeframe, "period", params.period)
 		return
 	}
-
-	timeframeMinutes, err := parseTimeframeToMinutes(timeframe)
+	now := time.Now().UTC()
+	if params.asOf != 0 {
+		now = time.UnixMilli(params.asOf)
+	}
+	startTSMS, stepMS, err := volumeWindowStart(params, now)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "INVALID_UNIT", err.Error())
+		s.writeQueryError(w, r, err, "volume query error", "timeframe", params.timeframe, "period", params.period)
 		return
 	}
-	periodMinutes, err := parsePeriodToMinutes(period)
+	// The window slides with the clock, so the result can change between
+	// refreshes whenever its start crosses a candle boundary.
+	if s.notModified(w, r, snapshot, params, startTSMS/stepMS) {
+		return
+	}
+	bars := params.periodBars
+	if params.compare == "previous" {
+		bars *= 2
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, params.timeframe, params.asOf, bars, nil)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "INVALID_UNIT", err.Error())
+		s.writeQueryError(w, r, err, "volume query error", "timeframe", params.timeframe, "period", params.period)
 		return
 	}
 
-	requiredCandles := periodMinutes / timeframeMinutes
-	if requiredCandles > s.ohlcvHistoryLimit {
-		msg := fmt.Sprintf("指定された期間 (%s) とタイムフレーム (%s) の組み合わせでは、%d本のローソク足が必要です。これは現在利用可能な履歴の最大本数(%d本)を超えています。より短い期間、またはより大きなタイムフレームを選択してください。", period, timeframe, requiredCandles, s.ohlcvHistoryLimit)
-		writeError(w, http.StatusBadRequest, "INSUFFICIENT_HISTORY", msg)
+	_, span := tracer.Start(r.Context(), "queryVolume")
+	items, stats, queryErr := queryVolume(snapshot, params, startTSMS)
+	span.End()
+	if queryErr != nil {
+		s.writeQueryError(w, r, queryErr, "volume query error", "timeframe", params.timeframe, "period", params.period)
 		return
 	}
 
-	minVolume := 0.0
-	if raw := strings.TrimSpace(r.URL.Query().Get("min_volume")); raw != "" {
-		minVolume, err = parsePositiveFloat(raw)
+	writeData(w, r, http.StatusOK, volumeResponse{
+		Count: len(items),
+		AsOf:  params.asOf,
+		Data:  items,
+		Meta:  newResponseMeta(stats, params.echo(), map[string]marketSnapshot{params.timeframe: snapshot}),
+	})
+}
+
+// parseVolumeParams validates the /volume query string.
+func parseVolumeParams(q url.Values, historyLimit int) (volumeParams, *paramError) {
+	p := volumeParams{minVolumeTarget: "turnover", sort: "volume_desc", limit: 100}
+
+	p.timeframe = strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, p.timeframe) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", "))}
+	}
+
+	p.period = strings.TrimSpace(q.Get("period"))
+	if !contains(validPeriods, p.period) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_PERIOD", fmt.Sprintf("無効な期間指定です。有効な値: %s", strings.Join(validPeriods, ", "))}
+	}
+
+	var perr *paramError
+	if p.periodBars, perr = periodBars(p.timeframe, p.period, historyLimit); perr != nil {
+		return p, perr
+	}
+
+	var err error
+	if raw := strings.TrimSpace(q.Get("min_volume")); raw != "" {
+		p.minVolume, err = parsePositiveFloat(raw)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, "INVALID_INPUT", "min_volume は0より大きい有限の数値を指定してください")
-			return
+			return p, &paramError{http.StatusBadRequest, "INVALID_INPUT", "min_volume は0より大きい有限の数値を指定してください"}
 		}
 	}
 
-	minVolumeTarget := strings.TrimSpace(r.URL.Query().Get("min_volume_target"))
-	if minVolumeTarget == "" {
-		minVolumeTarget = "turnover"
+	if raw := strings.TrimSpace(q.Get("min_volume_target")); raw != "" {
+		p.minVolumeTarget = raw
 	}
-	if minVolumeTarget != "volume" && minVolumeTarget != "turnover" {
-		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "min_volume_target は volume/turnover のいずれかを指定してください")
-		return
+	if p.minVolumeTarget != "volume" && p.minVolumeTarget != "turnover" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "min_volume_target は volume/turnover のいずれかを指定してください"}
 	}
 
-	sort := strings.TrimSpace(r.URL.Query().Get("sort"))
-	if sort == "" {
-		sort = "volume_desc"
+	p.compare = strings.TrimSpace(q.Get("compare"))
+	if p.compare != "" && p.compare != "previous" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "compare は previous のみ指定できます"}
 	}
-	if sort != "volume_desc" && sort != "volume_asc" && sort != "turnover_desc" && sort != "turnover_asc" && sort != "symbol_asc" {
-		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "sort は volume_desc/volume_asc/turnover_desc/turnover_asc/symbol_asc のいずれかを指定してください")
-		return
+
+	if raw := strings.TrimSpace(q.Get("sort")); raw != "" {
+		p.sort = raw
+	}
+	sortKeys := []string{"volume_desc", "volume_asc", "turnover_desc", "turnover_asc", "symbol_asc"}
+	if p.compare == "previous" {
+		sortKeys = append(sortKeys, volumeChangeSortKeys...)
+	}
+	if !contains(sortKeys, p.sort) {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("sort は %s のいずれかを指定してください", strings.Join(sortKeys, "/"))}
 	}
 
-	limit := 100
-	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
-		limit, err = strconv.Atoi(raw)
-		if err != nil || limit <= 0 || limit > 500 {
-			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください")
-			return
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		p.limit, err = strconv.Atoi(raw)
+		if err != nil || p.limit <= 0 || p.limit > 500 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください"}
 		}
 	}
 
-	items, queryErr := s.queryVolume(timeframe, period, sort, limit, minVolume, minVolumeTarget)
-	if queryErr != nil {
-		s.logger.Printf("volume query error timeframe=%s period=%s: %v", timeframe, period, queryErr)
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal server error")
-		return
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		return p, perr
 	}
+	p.asOf = asOf
 
-	writeJSON(w, http.StatusOK, volumeResponse{Count: len(items), Data: items})
+	return p, nil
 }
 
-func (s *apiServer) queryVolume(timeframe, period, sortKey string, limit int, minVolume float64, minVolumeTarget string) ([]volumeItem, error) {
-	snapshot, err := s.marketCache.getSnapshot(timeframe)
+// periodBars is the number of timeframe candles covering period, which must
+// fit in the cached history.
+func periodBars(timeframe, period string, historyLimit int) (int, *paramError) {
+	timeframeMinutes, err := parseTimeframeToMinutes(timeframe)
 	if err != nil {
-		return nil, err
+		return 0, &paramError{http.StatusBadRequest, "INVALID_UNIT", err.Error()}
 	}
-	periodSeconds, err := parsePeriodToSeconds(period)
+	periodMinutes, err := parsePeriodToMinutes(period)
 	if err != nil {
-		return nil, err
+		return 0, &paramError{http.StatusBadRequest, "INVALID_UNIT", err.Error()}
 	}
-	startTSMS := time.Now().UTC().Add(-time.Duration(periodSeconds) * time.Second).UnixMilli()
+
+	requiredCandles := periodMinutes / timeframeMinutes
+	if requiredCandles > historyLimit {
+		msg := fmt.Sprintf("指定された期間 (%s) とタイムフレーム (%s) の組み合わせでは、%d本のローソク足が必要です。これは現在利用可能な履歴の最大本数(%d本)を超えています。より短い期間、またはより大きなタイムフレームを選択してください。", period, timeframe, requiredCandles, historyLimit)
+		return 0, &paramError{http.StatusBadRequest, "INSUFFICIENT_HISTORY", msg}
+	}
+	return maxInt(requiredCandles, 1), nil
+}
+
+// volumeWindowStart returns the start of the /volume window ending at now,
+// along with the timeframe's bar length.
+func volumeWindowStart(p volumeParams, now time.Time) (int64, int64, error) {
+	periodSeconds, err := parsePeriodToSeconds(p.period)
+	if err != nil {
+		return 0, 0, err
+	}
+	stepMS, err := timeframeStepMS(p.timeframe)
+	if err != nil {
+		return 0, 0, err
+	}
+	return now.UnixMilli() - int64(periodSeconds)*1000, stepMS, nil
+}
+
+func queryVolume(snapshot marketSnapshot, p volumeParams, startTSMS int64) ([]volumeItem, rankStats, error) {
+	var stats rankStats
+	periodSeconds, err := parsePeriodToSeconds(p.period)
+	if err != nil {
+		return nil, stats, err
+	}
+	periodMS := int64(periodSeconds) * 1000
+	prevStartTSMS := startTSMS - periodMS
 
 	items := make([]volumeItem, 0, len(snapshot.seriesBySymbol))
 	for symbol, candles := range snapshot.seriesBySymbol {
 		item := volumeItem{
 			Symbol:    symbol,
-			Timeframe: timeframe,
-			Period:    period,
+			Timeframe: p.timeframe,
+			Period:    p.period,
 		}
 		hasRecent := false
 		for _, candle := range candles {
@@ -259,23 +561,41 @@ func (s *apiServer) queryVolume(timeframe, period, sortKey string, limit int, mi
 			item.TotalTurnover += candle.Turnover
 		}
 		if !hasRecent {
+			stats.skipped++
 			continue
 		}
-		if minVolume > 0 {
-			if minVolumeTarget == "volume" && item.TotalVolume <= minVolume {
+		stats.evaluated++
+		if p.compare == "previous" {
+			item.Comparison = previousWindow(candles, startTSMS, prevStartTSMS, p.periodBars)
+		}
+		items = append(items, item)
+	}
+
+	if p.compare == "previous" {
+		assignVolumeRanks(items, volumeRankBy(p.sort))
+	}
+
+	filtered := items[:0]
+	for _, item := range items {
+		if p.minVolume > 0 {
+			if p.minVolumeTarget == "volume" && item.TotalVolume <= p.minVolume {
 				continue
 			}
-			if minVolumeTarget == "turnover" && item.TotalTurnover <= minVolume {
+			if p.minVolumeTarget == "turnover" && item.TotalTurnover <= p.minVolume {
 				continue
 			}
 		}
 		item.TotalVolume = round4(item.TotalVolume)
 		item.TotalTurnover = round4(item.TotalTurnover)
-		items = append(items, item)
+		if item.Comparison != nil {
+			item.Comparison.round()
+		}
+		filtered = append(filtered, item)
 	}
+	items = filtered
 
 	sort.Slice(items, func(i, j int) bool {
-		switch sortKey {
+		switch p.sort {
 		case "volume_asc":
 			if items[i].TotalVolume == items[j].TotalVolume {
 				return items[i].Symbol < items[j].Symbol
@@ -293,6 +613,8 @@ func (s *apiServer) queryVolume(timeframe, period, sortKey string, limit int, mi
 			return items[i].TotalTurnover < items[j].TotalTurnover
 		case "symbol_asc":
 			return items[i].Symbol < items[j].Symbol
+		case "volume_change_desc", "volume_change_asc", "turnover_change_desc", "turnover_change_asc":
+			return lessByChange(items[i], items[j], p.sort)
 		default:
 			if items[i].TotalVolume == items[j].TotalVolume {
 				return items[i].Symbol < items[j].Symbol
@@ -301,8 +623,9 @@ func (s *apiServer) queryVolume(timeframe, period, sortKey string, limit int, mi
 		}
 	})
 
-	if limit < len(items) {
-		items = items[:limit]
+	stats.matched = len(items)
+	if p.limit < len(items) {
+		items = items[:p.limit]
 	}
-	return items, nil
+	return items, stats, nil
 }
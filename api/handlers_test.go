@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRankVolatilityLiquidityAndSymbolFilters(t *testing.T) {
+	candle := func(ts int64, close, turnover float64) marketCandle {
+		return marketCandle{TS: ts, Close: close, Volume: turnover / close, Turnover: turnover}
+	}
+	snapshot := marketSnapshot{seriesBySymbol: map[string][]marketCandle{
+		"BTCUSDT": {candle(3, 110, 5e6), candle(2, 100, 5e6), candle(1, 100, 5e6)},
+		// Only the forming candle is busy, so the floors filter it out.
+		"BTCPERP":  {candle(3, 110, 9e6), candle(2, 100, 1e3), candle(1, 100, 1e3)},
+		"ETHUSDT":  {candle(3, 120, 5e6), candle(2, 100, 5e6), candle(1, 100, 5e6)},
+		"THINUSDT": {candle(3, 200, 1e3), candle(2, 100, 1e3), candle(1, 100, 1e3)},
+	}}
+
+	rank := func(query string) []string {
+		t.Helper()
+		q, _ := url.ParseQuery("timeframe=1h&threshold=5&" + query)
+		params, perr := parseVolatilityParams(q, 5)
+		if perr != nil {
+			t.Fatalf("%s: %s", query, perr.message)
+		}
+		items, _, err := rankVolatility(snapshot, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		symbols := make([]string, 0, len(items))
+		for _, item := range items {
+			symbols = append(symbols, item.Symbol)
+		}
+		return symbols
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"min_turnover=1e6", []string{"ETHUSDT", "BTCUSDT"}},
+		{"min_turnover=1e6&liquidity_period=1h", []string{"ETHUSDT", "BTCUSDT"}},
+		{"max_pct=50", []string{"ETHUSDT", "BTCPERP", "BTCUSDT"}},
+		{"base=btc&exclude=BTCPERP", []string{"BTCUSDT"}},
+		{"symbols=ethusdt,thinusdt", []string{"THINUSDT", "ETHUSDT"}},
+	}
+	for _, tc := range cases {
+		got := rank(tc.query)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s = %v, want %v", tc.query, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s = %v, want %v", tc.query, got, tc.want)
+			}
+		}
+	}
+
+	q, _ := url.ParseQuery("timeframe=1h&threshold=5&liquidity_period=24h")
+	if _, perr := parseVolatilityParams(q, 5); perr == nil {
+		t.Fatal("liquidity_period without a floor was accepted")
+	}
+	for query, want := range map[string]int{
+		"timeframe=1h&min_turnover=1&threshold=5":                     4,
+		"timeframe=15m&min_volume=1&threshold=5":                      4,
+		"timeframe=1d&min_turnover=1&threshold=5":                     1,
+		"timeframe=1h&min_turnover=1&liquidity_period=1h&threshold=5": 1,
+		"timeframe=1h&threshold=5":                                    1,
+	} {
+		q, _ := url.ParseQuery(query)
+		if params, perr := parseVolatilityParams(q, 5); perr != nil || params.liquidityBars != want {
+			t.Errorf("%s: liquidity bars = %d, want %d (%v)", query, params.liquidityBars, want, perr)
+		}
+	}
+
+	// Six closed bars plus the forming one need a history of seven.
+	q, _ = url.ParseQuery("timeframe=1h&min_turnover=1&liquidity_period=6h&threshold=5")
+	if _, perr := parseVolatilityParams(q, 6); perr == nil || perr.code != "INSUFFICIENT_HISTORY" {
+		t.Fatalf("liquidity_period at the history limit = %v, want INSUFFICIENT_HISTORY", perr)
+	}
+	if params, perr := parseVolatilityParams(q, 7); perr != nil || params.liquidityBars != 6 {
+		t.Fatalf("liquidity_period one bar under the limit = %d, %v", params.liquidityBars, perr)
+	}
+}
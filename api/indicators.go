@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultIndicatorsLimit = 100
+	maxIndicatorsPerSet    = 10
+)
+
+// indicatorDefaults lists each indicator's parameters when omitted from the
+// set, e.g. "macd" is "macd:12:26:9".
+var indicatorDefaults = map[string][]float64{
+	"sma":     {20},
+	"ema":     {20},
+	"rsi":     {14},
+	"macd":    {12, 26, 9},
+	"bbands":  {20, 2},
+	"atr":     {14},
+	"vwap":    {20},
+	"highlow": {20},
+}
+
+// indicatorSpec is one entry of the set parameter with defaults filled in.
+// key is the canonical form used as the column name prefix.
+type indicatorSpec struct {
+	name string
+	args []float64
+	key  string
+}
+
+// minBars is the number of candles the newest value needs. Recursive
+// indicators (ema, rsi, macd) warm up on whatever older history is left.
+func (spec indicatorSpec) minBars() int {
+	if spec.name == "macd" {
+		return int(spec.args[1] + spec.args[2])
+	}
+	return int(spec.args[0]) + 1
+}
+
+func (s *apiServer) indicatorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	symbol := strings.ToUpper(strings.TrimSpace(q.Get("symbol")))
+	if !symbolRegex.MatchString(symbol) {
+		writeError(w, http.StatusBadRequest, "INVALID_SYMBOL", "symbol は英数字の銘柄シンボルを指定してください (例: BTCUSDT)")
+		return
+	}
+
+	timeframe := strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, timeframe) {
+		writeError(w, http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", ")))
+		return
+	}
+
+	specs, perr := parseIndicatorSet(q.Get("set"), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	limit := defaultIndicatorsLimit
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > s.ohlcvHistoryLimit {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("limit は1以上%d以下の整数を指定してください", s.ohlcvHistoryLimit))
+			return
+		}
+	}
+
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "indicators query error", "symbol", symbol, "timeframe", timeframe)
+		return
+	}
+	if s.notModified(w, r, snapshot, symbol, timeframe, specs, limit, asOf) {
+		return
+	}
+	bars := 1
+	for _, spec := range specs {
+		bars = maxInt(bars, spec.minBars())
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, timeframe, asOf, bars, []string{symbol})
+	if err != nil {
+		s.writeQueryError(w, r, err, "indicators query error", "symbol", symbol, "timeframe", timeframe)
+		return
+	}
+	series, ok := snapshot.seriesBySymbol[symbol]
+	if !ok || len(series) == 0 {
+		writeError(w, http.StatusNotFound, "SYMBOL_NOT_FOUND", fmt.Sprintf("銘柄 %s のデータはありません", symbol))
+		return
+	}
+
+	// The cache is newest-first; indicators are computed oldest-first over the
+	// whole cached series so that EMA-style warmup uses all available history.
+	candles := make([]marketCandle, len(series))
+	for i, c := range series {
+		candles[len(series)-1-i] = c
+	}
+
+	columns := make([]string, 0, len(specs))
+	values := make(map[string][]float64)
+	for _, spec := range specs {
+		names := make([]string, 0, 3)
+		for name, column := range computeIndicator(spec, candles) {
+			names = append(names, name)
+			values[name] = column
+		}
+		sort.Strings(names)
+		columns = append(columns, names...)
+	}
+
+	start := maxInt(len(candles)-limit, 0)
+	rows := make([]indicatorRow, 0, len(candles)-start)
+	for i := start; i < len(candles); i++ {
+		row := indicatorRow{TS: candles[i].TS, Close: candles[i].Close, Values: make(map[string]*float64, len(columns))}
+		for _, name := range columns {
+			row.Values[name] = finiteOrNil(values[name][i])
+		}
+		rows = append(rows, row)
+	}
+
+	writeData(w, r, http.StatusOK, indicatorsResponse{
+		Symbol:    symbol,
+		Timeframe: timeframe,
+		Columns:   columns,
+		Count:     len(rows),
+		AsOf:      asOf,
+		Data:      rows,
+	})
+}
+
+// parseIndicatorSet reads "rsi:14,ema:50,macd". Periods must be whole numbers
+// that fit in the cached history.
+func parseIndicatorSet(raw string, historyLimit int) ([]indicatorSpec, *paramError) {
+	specs := make([]indicatorSpec, 0)
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		name := parts[0]
+		defaults, ok := indicatorDefaults[name]
+		if !ok {
+			return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("未対応の指標です: %s (有効な値: sma, ema, rsi, macd, bbands, atr, vwap, highlow)", name)}
+		}
+		if len(parts)-1 > len(defaults) {
+			return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("%s のパラメータは最大%d個です", name, len(defaults))}
+		}
+
+		args := append([]float64(nil), defaults...)
+		for i, rawArg := range parts[1:] {
+			v, err := strconv.ParseFloat(rawArg, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v <= 0 {
+				return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("%s のパラメータが不正です: %s", name, rawArg)}
+			}
+			args[i] = v
+		}
+		for i, v := range args {
+			// bbands' second argument is the band width multiplier; every
+			// other argument is a bar count.
+			if name == "bbands" && i == 1 {
+				continue
+			}
+			if v != math.Trunc(v) || int(v) > historyLimit {
+				return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("%s の期間は1以上%d以下の整数を指定してください", name, historyLimit)}
+			}
+		}
+		if name == "macd" && args[0] >= args[1] {
+			return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "macd の短期期間は長期期間より小さい値を指定してください"}
+		}
+
+		keyParts := []string{name}
+		for _, v := range args {
+			keyParts = append(keyParts, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		spec := indicatorSpec{name: name, args: args, key: strings.Join(keyParts, ":")}
+		if seen[spec.key] {
+			continue
+		}
+		seen[spec.key] = true
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 || len(specs) > maxIndicatorsPerSet {
+		return nil, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("set は1以上%d以下の指標をカンマ区切りで指定してください (例: rsi:14,ema:50)", maxIndicatorsPerSet)}
+	}
+	return specs, nil
+}
+
+// computeIndicator returns one column per output, aligned with the
+// oldest-first candles. Multi-output indicators suffix the key, e.g.
+// "macd:12:26:9.signal". Undefined (warmup) positions are NaN.
+func computeIndicator(spec indicatorSpec, candles []marketCandle) map[string][]float64 {
+	closes := make([]float64, len(candles))
+	for i, c := range candles {
+		closes[i] = c.Close
+	}
+	n := int(spec.args[0])
+
+	switch spec.name {
+	case "sma":
+		return map[string][]float64{spec.key: smaSeries(closes, n)}
+	case "ema":
+		return map[string][]float64{spec.key: emaSeries(closes, n)}
+	case "rsi":
+		return map[string][]float64{spec.key: rsiSeries(closes, n)}
+	case "macd":
+		macd, signal, hist := macdSeries(closes, n, int(spec.args[1]), int(spec.args[2]))
+		return map[string][]float64{spec.key + ".macd": macd, spec.key + ".signal": signal, spec.key + ".hist": hist}
+	case "bbands":
+		middle, upper, lower := bollingerSeries(closes, n, spec.args[1])
+		return map[string][]float64{spec.key + ".middle": middle, spec.key + ".upper": upper, spec.key + ".lower": lower}
+	case "atr":
+		return map[string][]float64{spec.key: atrSeries(candles, n)}
+	case "vwap":
+		return map[string][]float64{spec.key: vwapSeries(candles, n)}
+	case "highlow":
+		high, low := rollingHighLow(candles, n)
+		return map[string][]float64{spec.key + ".high": high, spec.key + ".low": low}
+	}
+	return nil
+}
+
+func nanSeries(n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	return out
+}
+
+func smaSeries(values []float64, n int) []float64 {
+	out := nanSeries(len(values))
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		if i >= n {
+			sum -= values[i-n]
+		}
+		if i >= n-1 {
+			out[i] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// emaSeries seeds with the SMA of the first n defined values and then applies
+// alpha = 2/(n+1). Leading NaNs in values (e.g. the MACD line) are skipped.
+func emaSeries(values []float64, n int) []float64 {
+	out := nanSeries(len(values))
+	first := 0
+	for first < len(values) && math.IsNaN(values[first]) {
+		first++
+	}
+	seedAt := first + n - 1
+	if seedAt >= len(values) {
+		return out
+	}
+
+	sum := 0.0
+	for _, v := range values[first : seedAt+1] {
+		sum += v
+	}
+	out[seedAt] = sum / float64(n)
+	alpha := 2 / float64(n+1)
+	for i := seedAt + 1; i < len(values); i++ {
+		out[i] = alpha*values[i] + (1-alpha)*out[i-1]
+	}
+	return out
+}
+
+// rsiSeries is Wilder's RSI: the first average gain/loss is a simple mean
+// over n changes, later ones are smoothed with weight 1/n.
+func rsiSeries(closes []float64, n int) []float64 {
+	out := nanSeries(len(closes))
+	if len(closes) <= n {
+		return out
+	}
+
+	avgGain, avgLoss := 0.0, 0.0
+	for i := 1; i <= n; i++ {
+		change := closes[i] - closes[i-1]
+		avgGain += math.Max(change, 0)
+		avgLoss += math.Max(-change, 0)
+	}
+	avgGain /= float64(n)
+	avgLoss /= float64(n)
+	out[n] = rsiValue(avgGain, avgLoss)
+
+	for i := n + 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		avgGain = (avgGain*float64(n-1) + math.Max(change, 0)) / float64(n)
+		avgLoss = (avgLoss*float64(n-1) + math.Max(-change, 0)) / float64(n)
+		out[i] = rsiValue(avgGain, avgLoss)
+	}
+	return out
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		if avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+avgGain/avgLoss)
+}
+
+func macdSeries(closes []float64, fast, slow, signal int) ([]float64, []float64, []float64) {
+	fastEMA := emaSeries(closes, fast)
+	slowEMA := emaSeries(closes, slow)
+	macd := nanSeries(len(closes))
+	for i := range closes {
+		macd[i] = fastEMA[i] - slowEMA[i]
+	}
+	signalLine := emaSeries(macd, signal)
+	hist := nanSeries(len(closes))
+	for i := range closes {
+		hist[i] = macd[i] - signalLine[i]
+	}
+	return macd, signalLine, hist
+}
+
+// bollingerSeries uses the population standard deviation, as in Bollinger's
+// original definition.
+func bollingerSeries(closes []float64, n int, k float64) ([]float64, []float64, []float64) {
+	middle := smaSeries(closes, n)
+	upper := nanSeries(len(closes))
+	lower := nanSeries(len(closes))
+	for i := n - 1; i < len(closes); i++ {
+		sq := 0.0
+		for _, v := range closes[i-n+1 : i+1] {
+			sq += (v - middle[i]) * (v - middle[i])
+		}
+		width := k * math.Sqrt(sq/float64(n))
+		upper[i] = middle[i] + width
+		lower[i] = middle[i] - width
+	}
+	return middle, upper, lower
+}
+
+// atrSeries is Wilder's ATR. The first bar has no previous close, so its true
+// range is high - low; the first ATR is the mean of the first n true ranges.
+func atrSeries(candles []marketCandle, n int) []float64 {
+	out := nanSeries(len(candles))
+	if len(candles) < n {
+		return out
+	}
+	tr := make([]float64, len(candles))
+	for i, c := range candles {
+		tr[i] = c.High - c.Low
+		if i > 0 {
+			prevClose := candles[i-1].Close
+			tr[i] = math.Max(tr[i], math.Max(math.Abs(c.High-prevClose), math.Abs(c.Low-prevClose)))
+		}
+	}
+
+	sum := 0.0
+	for _, v := range tr[:n] {
+		sum += v
+	}
+	out[n-1] = sum / float64(n)
+	for i := n; i < len(candles); i++ {
+		out[i] = (out[i-1]*float64(n-1) + tr[i]) / float64(n)
+	}
+	return out
+}
+
+// vwapSeries is a rolling VWAP over n bars. Bybit reports turnover (quote
+// volume) per bar, so sum(turnover)/sum(volume) is exact rather than the
+// usual typical-price approximation.
+func vwapSeries(candles []marketCandle, n int) []float64 {
+	out := nanSeries(len(candles))
+	volume, turnover := 0.0, 0.0
+	for i, c := range candles {
+		volume += c.Volume
+		turnover += c.Turnover
+		if i >= n {
+			volume -= candles[i-n].Volume
+			turnover -= candles[i-n].Turnover
+		}
+		if i >= n-1 && volume > 0 {
+			out[i] = turnover / volume
+		}
+	}
+	return out
+}
+
+func rollingHighLow(candles []marketCandle, n int) ([]float64, []float64) {
+	high := nanSeries(len(candles))
+	low := nanSeries(len(candles))
+	for i := n - 1; i < len(candles); i++ {
+		h, l := candles[i].High, candles[i].Low
+		for _, c := range candles[i-n+1 : i] {
+			h = math.Max(h, c.High)
+			l = math.Min(l, c.Low)
+		}
+		high[i], low[i] = h, l
+	}
+	return high, low
+}
+
+func finiteOrNil(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
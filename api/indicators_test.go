@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Reference series and values from the StockCharts ChartSchool worked
+// examples for EMA(10) and Wilder's RSI(14), rounded to two decimals. The
+// published RSI table rounds its intermediate averages, so its values drift
+// from the exact computation by up to ~0.07.
+var (
+	emaReferenceCloses = []float64{
+		22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+		22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+		23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+	}
+	emaReferenceValues = []float64{
+		22.22, 22.21, 22.24, 22.27, 22.33, 22.52, 22.80, 22.97, 23.13, 23.28,
+		23.34, 23.43, 23.51, 23.53, 23.47, 23.40, 23.39, 23.26, 23.23, 23.08, 22.92,
+	}
+	rsiReferenceCloses = []float64{
+		44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+		45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+		46.21, 46.25, 45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57,
+		43.42, 42.66, 43.13,
+	}
+	rsiReferenceValues = []float64{
+		70.53, 66.32, 66.55, 69.41, 66.36, 57.97, 62.93, 63.26, 56.06, 62.38,
+		54.71, 50.42, 39.99, 41.46, 41.87, 45.46, 37.30, 33.08, 37.77,
+	}
+)
+
+func assertSeries(t *testing.T, name string, got []float64, warmup int, want []float64, tolerance float64) {
+	t.Helper()
+	if len(got) != warmup+len(want) {
+		t.Fatalf("%s: len = %d, want %d", name, len(got), warmup+len(want))
+	}
+	for i := 0; i < warmup; i++ {
+		if !math.IsNaN(got[i]) {
+			t.Fatalf("%s[%d] = %v, want NaN during warmup", name, i, got[i])
+		}
+	}
+	for i, w := range want {
+		if math.Abs(got[warmup+i]-w) > tolerance {
+			t.Fatalf("%s[%d] = %.4f, want %.2f", name, warmup+i, got[warmup+i], w)
+		}
+	}
+}
+
+func TestEMAMatchesReference(t *testing.T) {
+	assertSeries(t, "ema", emaSeries(emaReferenceCloses, 10), 9, emaReferenceValues, 0.006)
+}
+
+func TestRSIMatchesReference(t *testing.T) {
+	assertSeries(t, "rsi", rsiSeries(rsiReferenceCloses, 14), 14, rsiReferenceValues, 0.1)
+}
+
+func TestSMAAndBollinger(t *testing.T) {
+	closes := []float64{1, 2, 3, 4, 5}
+
+	assertSeries(t, "sma", smaSeries(closes, 3), 2, []float64{2, 3, 4}, 1e-12)
+
+	// Population stdev of {1,2,3} is sqrt(2/3).
+	middle, upper, lower := bollingerSeries(closes, 3, 2)
+	width := 2 * math.Sqrt(2.0/3.0)
+	assertSeries(t, "bbands.middle", middle, 2, []float64{2, 3, 4}, 1e-12)
+	assertSeries(t, "bbands.upper", upper, 2, []float64{2 + width, 3 + width, 4 + width}, 1e-12)
+	assertSeries(t, "bbands.lower", lower, 2, []float64{2 - width, 3 - width, 4 - width}, 1e-12)
+}
+
+func TestMACDIsFastMinusSlowWithSignal(t *testing.T) {
+	closes := []float64{1, 2, 3, 4, 5, 6, 7}
+	macd, signal, hist := macdSeries(closes, 2, 3, 2)
+
+	// EMA(2) and EMA(3) of a unit ramp settle to lags of 0.5 and 1 bar.
+	assertSeries(t, "macd", macd, 2, []float64{0.5, 0.5, 0.5, 0.5, 0.5}, 1e-12)
+	assertSeries(t, "signal", signal, 3, []float64{0.5, 0.5, 0.5, 0.5}, 1e-12)
+	assertSeries(t, "hist", hist, 3, []float64{0, 0, 0, 0}, 1e-12)
+}
+
+func TestATRVWAPAndHighLow(t *testing.T) {
+	candles := []marketCandle{
+		{High: 10, Low: 8, Close: 9, Volume: 1, Turnover: 9},
+		{High: 12, Low: 9, Close: 11, Volume: 2, Turnover: 21},
+		{High: 11, Low: 7, Close: 8, Volume: 1, Turnover: 8},
+		{High: 14, Low: 10, Close: 13, Volume: 4, Turnover: 50},
+	}
+
+	// True ranges: 2, 3, 4, max(4, |14-8|, |10-8|) = 6.
+	// ATR(2): (2+3)/2 = 2.5, (2.5+4)/2 = 3.25, (3.25+6)/2 = 4.625.
+	assertSeries(t, "atr", atrSeries(candles, 2), 1, []float64{2.5, 3.25, 4.625}, 1e-12)
+
+	assertSeries(t, "vwap", vwapSeries(candles, 2), 1, []float64{30.0 / 3, 29.0 / 3, 58.0 / 5}, 1e-12)
+
+	high, low := rollingHighLow(candles, 3)
+	assertSeries(t, "high", high, 2, []float64{12, 14}, 0)
+	assertSeries(t, "low", low, 2, []float64{7, 7}, 0)
+}
+
+func TestParseIndicatorSetFillsDefaults(t *testing.T) {
+	specs, perr := parseIndicatorSet("rsi, macd, bbands:20:2.5, rsi:14", 1000)
+	if perr != nil {
+		t.Fatalf("unexpected error: %+v", perr)
+	}
+	keys := []string{"rsi:14", "macd:12:26:9", "bbands:20:2.5"}
+	if len(specs) != len(keys) {
+		t.Fatalf("specs = %+v, want keys %v", specs, keys)
+	}
+	for i, key := range keys {
+		if specs[i].key != key {
+			t.Fatalf("specs[%d].key = %s, want %s", i, specs[i].key, key)
+		}
+	}
+
+	for _, bad := range []string{"", "foo:3", "ema:2.5", "ema:5000", "macd:26:12", "sma:1:2"} {
+		if _, perr := parseIndicatorSet(bad, 1000); perr == nil {
+			t.Fatalf("parseIndicatorSet(%q) accepted invalid input", bad)
+		}
+	}
+}
@@ -1,29 +1,50 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-openapi/runtime/middleware"
-	_ "modernc.org/sqlite"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "", log.LstdFlags)
+	logger := newLogger(getEnv("LOG_LEVEL", "info"))
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := setupTracing(context.Background(), "api")
+	if err != nil {
+		logger.Error("tracing setup failed", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("tracing shutdown failed", "err", err)
+		}
+	}()
+
 	dbPath := getEnv("DB_PATH", "/app/data/cmma.db")
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := openSQLite(dbPath)
 	if err != nil {
-		logger.Fatalf("db open failed: %v", err)
+		logger.Error("db open failed", "err", err)
+		os.Exit(1)
 	}
 	defer db.Close()
 
 	if err := configureSQLiteForReader(db); err != nil {
-		logger.Fatalf("set sqlite pragma failed: %v", err)
+		logger.Error("set sqlite pragma failed", "err", err)
+		os.Exit(1)
 	}
 
 	historyLimit, _ := strconv.Atoi(getEnv("OHLCV_HISTORY_LIMIT", "5"))
@@ -36,21 +57,118 @@ func main() {
 		cacheRefreshSeconds = 5
 	}
 
+	fullRefreshSeconds, _ := strconv.Atoi(getEnv("CACHE_FULL_REFRESH_SECONDS", "300"))
+	if fullRefreshSeconds <= 0 {
+		fullRefreshSeconds = 300
+	}
+
+	warmupWaitMillis, _ := strconv.Atoi(getEnv("CACHE_WARMUP_WAIT_MS", "0"))
+	if warmupWaitMillis < 0 {
+		warmupWaitMillis = 0
+	}
+
+	versionPollMillis, _ := strconv.Atoi(getEnv("CACHE_VERSION_POLL_MS", "1000"))
+	if versionPollMillis <= 0 {
+		versionPollMillis = 1000
+	}
+
+	screenMaxCost, _ := strconv.Atoi(getEnv("SCREEN_MAX_COST", "10000000"))
+	if screenMaxCost <= 0 {
+		screenMaxCost = 10000000
+	}
+
+	streamMaxSubscribers, _ := strconv.Atoi(getEnv("STREAM_MAX_SUBSCRIBERS", "100"))
+	if streamMaxSubscribers <= 0 {
+		streamMaxSubscribers = 100
+	}
+
+	streamHeartbeatSeconds, _ := strconv.Atoi(getEnv("STREAM_HEARTBEAT_SECONDS", "15"))
+	if streamHeartbeatSeconds <= 0 {
+		streamHeartbeatSeconds = 15
+	}
+
+	shutdownReadyDelaySeconds, err := strconv.Atoi(getEnv("SHUTDOWN_READY_DELAY_SECONDS", "5"))
+	if err != nil || shutdownReadyDelaySeconds < 0 {
+		shutdownReadyDelaySeconds = 5
+	}
+
+	shutdownTimeoutSeconds, _ := strconv.Atoi(getEnv("SHUTDOWN_TIMEOUT_SECONDS", "20"))
+	if shutdownTimeoutSeconds <= 0 {
+		shutdownTimeoutSeconds = 20
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// background outlives the signal: the cache and the alert engine keep
+	// serving while in-flight requests drain, and stop afterwards.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	var workers sync.WaitGroup
+
 	s := &apiServer{
-		logger:            logger,
-		db:                db,
-		ohlcvHistoryLimit: historyLimit,
-		marketCache:       newMarketDataCache(db, historyLimit, time.Duration(cacheRefreshSeconds)*time.Second),
+		logger:             logger,
+		db:                 db,
+		ohlcvHistoryLimit:  historyLimit,
+		cacheTimeframes:    loadCacheTimeframes(),
+		retryAfterSeconds:  cacheRefreshSeconds,
+		screenMaxCost:      screenMaxCost,
+		cacheMaxAgeSeconds: cacheRefreshSeconds,
+		streams:            newVolatilityStreams(streamMaxSubscribers),
+		streamHeartbeat:    time.Duration(streamHeartbeatSeconds) * time.Second,
+		marketCache:        newMarketDataCache(background, db, historyLimit, time.Duration(cacheRefreshSeconds)*time.Second, time.Duration(fullRefreshSeconds)*time.Second, time.Duration(warmupWaitMillis)*time.Millisecond),
 	}
+	s.marketCache.onRefresh(s.streams.publish)
+	var stateDB *sql.DB
+	// An explicitly empty API_STATE_DB_PATH disables alerts; unset uses the
+	// default path.
+	statePath, ok := os.LookupEnv("API_STATE_DB_PATH")
+	if !ok {
+		statePath = "/app/state/api_state.db"
+	}
+	if statePath = strings.TrimSpace(statePath); statePath != "" {
+		stateDB, err = openStateDB(statePath)
+		if err != nil {
+			logger.Error("state db open failed", "err", err)
+			os.Exit(1)
+		}
+		defer stateDB.Close()
+		var webhookHosts []string
+		for _, host := range strings.Split(getEnv("ALERT_WEBHOOK_ALLOWED_HOSTS", ""), ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				webhookHosts = append(webhookHosts, host)
+			}
+		}
+		s.alerts = newAlertEngine(&alertStore{db: stateDB}, logger, webhookHosts)
+		s.marketCache.onRefresh(s.alerts.notify)
+		workers.Go(func() { s.alerts.run(background) })
+	} else {
+		logger.Warn("API_STATE_DB_PATH is empty; alerts are disabled")
+	}
+
 	openAPISpec, err := buildOpenAPISpec()
 	if err != nil {
-		logger.Fatalf("openapi spec build failed: %v", err)
+		logger.Error("openapi spec build failed", "err", err)
+		os.Exit(1)
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.rootHandler)
 	mux.HandleFunc("/volatility", s.volatilityHandler)
+	mux.HandleFunc("/stream/volatility", s.volatilityStreamHandler)
 	mux.HandleFunc("/volume", s.volumeHandler)
+	mux.HandleFunc("/volume/spikes", s.volumeSpikesHandler)
+	mux.HandleFunc("/correlation", s.correlationHandler)
+	mux.HandleFunc("/correlation/matrix", s.correlationMatrixHandler)
+	mux.HandleFunc("/breadth", s.breadthHandler)
+	mux.HandleFunc("/indicators", s.indicatorsHandler)
+	mux.HandleFunc("/screen", s.screenHandler)
+	mux.HandleFunc("/alerts/rules", s.alertRulesHandler)
+	mux.HandleFunc("/alerts/rules/{id}", s.alertRuleHandler)
+	mux.HandleFunc("/alerts/firings", s.alertFiringsHandler)
+	mux.HandleFunc("/candles", s.candlesHandler)
+	mux.HandleFunc("/symbols", s.symbolsHandler)
+	mux.HandleFunc("/symbols/{symbol}", s.symbolHandler)
+	mux.HandleFunc("/readyz", s.readyHandler)
 
 	var handler http.Handler = mux
 	handler = middleware.SwaggerUI(middleware.SwaggerUIOpts{
@@ -61,22 +179,72 @@ func main() {
 	}, handler)
 	handler = middleware.Spec("/", openAPISpec, handler, middleware.WithSpecPath("volatility"), middleware.WithSpecDocument("openapi.json"))
 
-	s.marketCache.warmup(validTimeframes)
+	if keysPath := getEnv("API_KEYS_FILE", ""); keysPath != "" {
+		keys, err := loadAPIKeys(keysPath)
+		if err != nil {
+			logger.Error("api keys load failed", "err", err)
+			os.Exit(1)
+		}
+		var usage *usageRecorder
+		if stateDB != nil {
+			usage = newUsageRecorder(stateDB, logger)
+			workers.Go(func() { usage.run(background, 10*time.Second) })
+		} else {
+			logger.Warn("API_STATE_DB_PATH is empty; API key usage is not recorded")
+		}
+		handler = withAPIKeys(handler, mux, keys, usage)
+		s.privateResponses = true
+		logger.Info("api key auth enabled", "keys", len(keys))
+	} else {
+		logger.Warn("API_KEYS_FILE is empty; api key auth is disabled")
+	}
+
+	s.marketCache.warmup(s.cacheTimeframes)
+	workers.Go(func() { s.marketCache.watchDataVersions(background, time.Duration(versionPollMillis)*time.Millisecond) })
 
 	addr := ":8000"
 	server := &http.Server{
 		Addr:              addr,
-		Handler:           withJSONContentType(handler),
+		Handler:           withTracing(withRequestLog(withJSONContentType(handler), logger), mux),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    1 << 20,
 	}
-	logger.Printf("api started on %s", addr)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		logger.Fatalf("server error: %v", err)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
+	logger.Info("api started", "addr", addr)
+
+	select {
+	case err := <-serveErr:
+		logger.Error("server error", "err", err)
+		os.Exit(1)
+	case <-signalCtx.Done():
 	}
+	// A second signal now terminates immediately.
+	stop()
+
+	// Report not-ready first so the load balancer stops routing here, then
+	// stop accepting and wait for in-flight requests.
+	s.draining.Store(true)
+	logger.Info("shutdown started, draining", "ready_delay_seconds", shutdownReadyDelaySeconds, "timeout_seconds", shutdownTimeoutSeconds)
+	time.Sleep(time.Duration(shutdownReadyDelaySeconds) * time.Second)
+
+	s.streams.close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warn("drain timed out, closing remaining connections", "err", err)
+		server.Close()
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("server error", "err", err)
+	}
+
+	stopBackground()
+	workers.Wait()
+	logger.Info("api stopped")
 }
 
 func withJSONContentType(next http.Handler) http.Handler {
@@ -0,0 +1,115 @@
+package main
+
+import "time"
+
+// newResponseMeta builds the meta object of a ranking response from the
+// snapshots it was computed on, keyed by timeframe.
+func newResponseMeta(stats rankStats, params map[string]any, snapshots map[string]marketSnapshot) *responseMeta {
+	meta := &responseMeta{
+		Total:      stats.matched,
+		Evaluated:  stats.evaluated,
+		Skipped:    stats.skipped,
+		Params:     params,
+		Timeframes: make(map[string]timeframeMeta, len(snapshots)),
+	}
+	for timeframe, snapshot := range snapshots {
+		entry := timeframeMeta{NewestTS: snapshot.newestTS}
+		if !snapshot.refreshedAt.IsZero() {
+			entry.RefreshedAt = snapshot.refreshedAt.UTC().Format(time.RFC3339)
+		}
+		meta.Timeframes[timeframe] = entry
+	}
+	return meta
+}
+
+// withAsOf adds as_of to an echoed parameter set when it was given.
+func withAsOf(params map[string]any, asOf int64) map[string]any {
+	if asOf != 0 {
+		params["as_of"] = asOf
+	}
+	return params
+}
+
+func (p volatilityParams) echo() map[string]any {
+	params := map[string]any{
+		"timeframe": p.timeframe,
+		"threshold": p.threshold,
+		"offset":    p.offset,
+		"direction": p.direction,
+		"sort":      p.sort,
+		"limit":     p.limit,
+		"metric":    p.metric,
+		"annualize": p.annualize,
+	}
+	if p.minTurnover > 0 || p.minVolume > 0 {
+		params["min_turnover"] = p.minTurnover
+		params["min_volume"] = p.minVolume
+		params["liquidity_bars"] = p.liquidityBars
+	}
+	if p.liquidityPeriod != "" {
+		params["liquidity_period"] = p.liquidityPeriod
+	}
+	if len(p.symbols) > 0 {
+		params["symbols"] = p.symbols
+	}
+	if len(p.exclude) > 0 {
+		params["exclude"] = p.exclude
+	}
+	if p.base != "" {
+		params["base"] = p.base
+	}
+	if p.maxPct > 0 {
+		params["max_pct"] = p.maxPct
+	}
+	return withAsOf(params, p.asOf)
+}
+
+func (p volumeParams) echo() map[string]any {
+	params := map[string]any{
+		"timeframe":         p.timeframe,
+		"period":            p.period,
+		"period_bars":       p.periodBars,
+		"min_volume":        p.minVolume,
+		"min_volume_target": p.minVolumeTarget,
+		"sort":              p.sort,
+		"limit":             p.limit,
+	}
+	if p.compare != "" {
+		params["compare"] = p.compare
+	}
+	return withAsOf(params, p.asOf)
+}
+
+func (p volumeSpikeParams) echo() map[string]any {
+	return withAsOf(map[string]any{
+		"timeframe":    p.timeframe,
+		"window":       p.window,
+		"lookback":     p.lookback,
+		"target":       p.target,
+		"method":       p.method,
+		"threshold":    p.threshold,
+		"direction":    p.direction,
+		"min_turnover": p.minTurnover,
+		"sort":         p.sort,
+		"limit":        p.limit,
+	}, p.asOf)
+}
+
+func (p correlationParams) echo() map[string]any {
+	return withAsOf(map[string]any{
+		"timeframe": p.timeframe,
+		"window":    p.window,
+		"benchmark": p.benchmark,
+		"sort":      p.sort,
+		"limit":     p.limit,
+	}, p.asOf)
+}
+
+func (p breadthParams) echo() map[string]any {
+	return withAsOf(map[string]any{
+		"timeframe": p.timeframe,
+		"offset":    p.offset,
+		"lookback":  p.lookback,
+		"buckets":   p.buckets,
+	}, p.asOf)
+}
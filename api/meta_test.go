@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRankVolatilityReportsTotalsBeforeLimit(t *testing.T) {
+	snapshot := marketSnapshot{seriesBySymbol: map[string][]marketCandle{
+		"AAAUSDT": {{TS: 2, Close: 110}, {TS: 1, Close: 100}},
+		"BBBUSDT": {{TS: 2, Close: 120}, {TS: 1, Close: 100}},
+		"CCCUSDT": {{TS: 2, Close: 101}, {TS: 1, Close: 100}},
+		"DDDUSDT": {{TS: 2, Close: 130}},
+	}}
+	params := volatilityParams{timeframe: "1h", threshold: 5, offset: 1, direction: "both", sort: "volatility_desc", limit: 1, metric: metricChange}
+
+	items, stats, err := rankVolatility(snapshot, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Symbol != "BBBUSDT" {
+		t.Fatalf("items = %+v", items)
+	}
+	if stats != (rankStats{evaluated: 3, skipped: 1, matched: 2}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	resp := volatilityResponse{Count: len(items), Data: items, Meta: newResponseMeta(stats, params.echo(), map[string]marketSnapshot{"1h": snapshot})}
+	for _, format := range []string{formatJSON, formatMsgpack} {
+		if _, err := encodePayload(format, resp, http.Header{}); err != nil {
+			t.Fatalf("encode %s: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogPropagatesRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(requestIDHandler{slog.NewJSONHandler(&logs, nil)})
+	handler := withRequestLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.ErrorContext(r.Context(), "query failed")
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal server error")
+	}), logger)
+
+	do := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/volatility?timeframe=1h", nil)
+		if id != "" {
+			r.Header.Set("X-Request-ID", id)
+		}
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := do("abc-123")
+	if got := rec.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Fatalf("X-Request-ID = %q, want the client's ID", got)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.RequestID != "abc-123" {
+		t.Fatalf("error body request_id = %q (%v)", body.Error.RequestID, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want error and access lines", len(lines))
+	}
+	for _, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry["request_id"] != "abc-123" {
+			t.Fatalf("log line without request_id: %s", line)
+		}
+	}
+	var access map[string]any
+	_ = json.Unmarshal([]byte(lines[1]), &access)
+	if access["status"] != float64(500) || access["query"] != "timeframe=1h" || access["bytes"] == float64(0) {
+		t.Fatalf("access log = %v", access)
+	}
+
+	for _, bad := range []string{"", "has space", strings.Repeat("x", maxRequestIDLength+1)} {
+		got := do(bad).Header().Get("X-Request-ID")
+		if got == bad || len(got) != 32 {
+			t.Fatalf("X-Request-ID for %q = %q, want a generated ID", bad, got)
+		}
+	}
+}
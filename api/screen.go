@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxScreenBodyBytes = 64 << 10
+	maxScreenColumns   = 20
+)
+
+// screenMetric is a per-symbol value available to screener expressions.
+// bars is the number of cached candles one evaluation reads and is the unit
+// of the cost limit. needs, when set, is the smaller number a value requires
+// at all; the rest only warms up the indicator.
+type screenMetric struct {
+	params  []string
+	bars    func(args []int, historyLimit int) int
+	needs   func(args []int) int
+	compute func(candles []marketCandle, args []int) (float64, bool)
+}
+
+// minBars is the number of candles before as_of that must be retained.
+func (m screenMetric) minBars(args []int, historyLimit int) int {
+	if m.needs != nil {
+		return m.needs(args)
+	}
+	return m.bars(args, historyLimit)
+}
+
+var screenMetrics = map[string]screenMetric{
+	"price": {
+		bars: func([]int, int) int { return 1 },
+		compute: func(candles []marketCandle, _ []int) (float64, bool) {
+			return candles[0].Close, true
+		},
+	},
+	"volume":   sumMetric(func(c marketCandle) float64 { return c.Volume }),
+	"turnover": sumMetric(func(c marketCandle) float64 { return c.Turnover }),
+	"sma": {
+		params: []string{"n"},
+		bars:   func(args []int, _ int) int { return args[0] },
+		compute: func(candles []marketCandle, args []int) (float64, bool) {
+			if len(candles) < args[0] {
+				return 0, false
+			}
+			sum := 0.0
+			for _, c := range candles[:args[0]] {
+				sum += c.Close
+			}
+			return sum / float64(args[0]), true
+		},
+	},
+	"ema": fullSeriesMetric(emaSeries),
+	"rsi": fullSeriesMetric(rsiSeries),
+	"volume_ratio": {
+		params: []string{"window", "lookback"},
+		bars:   func(args []int, _ int) int { return args[0] + args[1] + 1 },
+		compute: func(candles []marketCandle, args []int) (float64, bool) {
+			stats, ok := computeVolumeSpike(candles, args[0], args[1], "turnover")
+			if !ok || stats.mean <= 0 {
+				return 0, false
+			}
+			return stats.recentPerBar / stats.mean, true
+		},
+	},
+}
+
+func init() {
+	for _, metric := range volatilityMetrics {
+		name := metric
+		screenMetrics[name] = screenMetric{
+			params: []string{"n"},
+			bars:   func(args []int, _ int) int { return args[0] + 1 },
+			compute: func(candles []marketCandle, args []int) (float64, bool) {
+				return computeVolatilityMetric(candles, name, args[0])
+			},
+		}
+	}
+}
+
+func sumMetric(value func(marketCandle) float64) screenMetric {
+	return screenMetric{
+		params: []string{"n"},
+		bars:   func(args []int, _ int) int { return args[0] },
+		compute: func(candles []marketCandle, args []int) (float64, bool) {
+			if len(candles) < args[0] {
+				return 0, false
+			}
+			sum := 0.0
+			for _, c := range candles[:args[0]] {
+				sum += value(c)
+			}
+			return sum, true
+		},
+	}
+}
+
+// fullSeriesMetric wraps a recursive indicator, which reads the whole cached
+// series to warm up and therefore costs historyLimit bars. The warmup is best
+// effort: n+1 bars are enough for a value.
+func fullSeriesMetric(series func([]float64, int) []float64) screenMetric {
+	return screenMetric{
+		params: []string{"n"},
+		bars: func(args []int, historyLimit int) int {
+			return maxInt(args[0], historyLimit)
+		},
+		needs: func(args []int) int { return args[0] + 1 },
+		compute: func(candles []marketCandle, args []int) (float64, bool) {
+			closes := make([]float64, len(candles))
+			for i, c := range candles {
+				closes[len(candles)-1-i] = c.Close
+			}
+			v := series(closes, args[0])[len(closes)-1]
+			return v, !math.IsNaN(v)
+		},
+	}
+}
+
+type screenRequest struct {
+	Filter  string   `json:"filter"`
+	Columns []string `json:"columns"`
+	Sort    string   `json:"sort"`
+	Order   string   `json:"order"`
+	Limit   int      `json:"limit"`
+}
+
+// screenQuery is a validated request: parsed expressions plus the distinct
+// metric calls they reference.
+type screenQuery struct {
+	filter  exprNode
+	columns []string
+	values  []exprNode
+	sort    exprNode
+	desc    bool
+	limit   int
+	calls   map[string]*metricCall
+}
+
+// symbolEnv evaluates metric calls for one symbol, memoising each call so a
+// metric shared by the filter, columns and sort is computed once.
+type symbolEnv struct {
+	symbol    string
+	snapshots map[string]marketSnapshot
+	memo      map[string]*float64
+}
+
+func (e *symbolEnv) metricValue(call *metricCall) (float64, bool) {
+	if v, ok := e.memo[call.key]; ok {
+		if v == nil {
+			return 0, false
+		}
+		return *v, true
+	}
+	var result *float64
+	candles := e.snapshots[call.timeframe].seriesBySymbol[e.symbol]
+	if len(candles) > 0 {
+		if v, ok := screenMetrics[call.name].compute(candles, call.args); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			result = &v
+		}
+	}
+	e.memo[call.key] = result
+	if result == nil {
+		return 0, false
+	}
+	return *result, true
+}
+
+func (s *apiServer) screenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	var req screenRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxScreenBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_BODY", fmt.Sprintf("リクエストボディが不正なJSONです: %v", err))
+		return
+	}
+
+	query, perr := parseScreenRequest(req, s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	asOf, perr := parseAsOf(r.URL.Query(), time.Now())
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	snapshots := make(map[string]marketSnapshot)
+	for _, call := range query.calls {
+		if _, ok := snapshots[call.timeframe]; ok {
+			continue
+		}
+		snapshot, err := s.marketCache.getSnapshot(r.Context(), call.timeframe)
+		if err != nil {
+			s.writeQueryError(w, r, err, "screen query error", "timeframe", call.timeframe)
+			return
+		}
+		snapshots[call.timeframe] = snapshot
+	}
+
+	universe := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		for symbol := range snapshot.seriesBySymbol {
+			universe[symbol] = true
+		}
+	}
+
+	perSymbol := 0
+	barsByTimeframe := make(map[string]int)
+	for _, call := range query.calls {
+		metric := screenMetrics[call.name]
+		perSymbol += metric.bars(call.args, s.ohlcvHistoryLimit)
+		barsByTimeframe[call.timeframe] = maxInt(barsByTimeframe[call.timeframe], metric.minBars(call.args, s.ohlcvHistoryLimit))
+	}
+	cost := perSymbol * len(universe)
+	if cost > s.screenMaxCost {
+		msg := fmt.Sprintf("評価コスト (%d) が上限 (%d) を超えています。指標の数や本数を減らしてください", cost, s.screenMaxCost)
+		writeError(w, http.StatusUnprocessableEntity, "QUERY_TOO_EXPENSIVE", msg)
+		return
+	}
+	// The cost is judged on the live universe so an expensive query is
+	// rejected before any database fallback.
+	for timeframe, snapshot := range snapshots {
+		snapshot, err := s.snapshotAt(r.Context(), snapshot, timeframe, asOf, barsByTimeframe[timeframe], nil)
+		if err != nil {
+			s.writeQueryError(w, r, err, "screen query error", "timeframe", timeframe)
+			return
+		}
+		snapshots[timeframe] = snapshot
+	}
+	if asOf != 0 {
+		universe = make(map[string]bool)
+		for _, snapshot := range snapshots {
+			for symbol := range snapshot.seriesBySymbol {
+				universe[symbol] = true
+			}
+		}
+	}
+
+	type scored struct {
+		item    screenItem
+		sortKey *float64
+	}
+	matches := make([]scored, 0)
+	for symbol := range universe {
+		if err := r.Context().Err(); err != nil {
+			s.writeQueryError(w, r, err, "screen query error", "matched", len(matches))
+			return
+		}
+		env := &symbolEnv{symbol: symbol, snapshots: snapshots, memo: make(map[string]*float64)}
+		if v, ok := query.filter.eval(env); !ok || v == 0 {
+			continue
+		}
+
+		item := screenItem{Symbol: symbol, Values: make(map[string]*float64, len(query.columns))}
+		for i, column := range query.columns {
+			item.Values[column] = evalOrNil(query.values[i], env)
+		}
+		m := scored{item: item}
+		if query.sort != nil {
+			m.sortKey = evalOrNil(query.sort, env)
+		}
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.sortKey == nil && b.sortKey == nil:
+			return a.item.Symbol < b.item.Symbol
+		case a.sortKey == nil:
+			return false
+		case b.sortKey == nil:
+			return true
+		case *a.sortKey == *b.sortKey:
+			return a.item.Symbol < b.item.Symbol
+		case query.desc:
+			return *a.sortKey > *b.sortKey
+		default:
+			return *a.sortKey < *b.sortKey
+		}
+	})
+
+	items := make([]screenItem, 0, minInt(len(matches), query.limit))
+	for _, m := range matches {
+		if len(items) == query.limit {
+			break
+		}
+		items = append(items, m.item)
+	}
+
+	writeData(w, r, http.StatusOK, screenResponse{
+		Count:     len(items),
+		Matched:   len(matches),
+		Evaluated: len(universe),
+		Cost:      cost,
+		Columns:   query.columns,
+		AsOf:      asOf,
+		Data:      items,
+	})
+}
+
+func parseScreenRequest(req screenRequest, historyLimit int) (screenQuery, *paramError) {
+	q := screenQuery{desc: true, limit: 100, calls: make(map[string]*metricCall)}
+	exprErr := func(field string, err error) *paramError {
+		var ee *exprError
+		if errors.As(err, &ee) {
+			return &paramError{http.StatusUnprocessableEntity, "INVALID_EXPRESSION", fmt.Sprintf("%s の式が不正です (%s)", field, ee.Error())}
+		}
+		return &paramError{http.StatusUnprocessableEntity, "INVALID_EXPRESSION", fmt.Sprintf("%s の式が不正です", field)}
+	}
+
+	var err error
+	if q.filter, err = parseScreenExpr(req.Filter, exprBool, historyLimit, q.calls); err != nil {
+		return q, exprErr("filter", err)
+	}
+
+	if len(req.Columns) > maxScreenColumns {
+		return q, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", fmt.Sprintf("columns は%d個以内で指定してください", maxScreenColumns)}
+	}
+	seen := make(map[string]bool)
+	for i, raw := range req.Columns {
+		column := strings.TrimSpace(raw)
+		if seen[column] {
+			continue
+		}
+		node, err := parseScreenExpr(column, exprNumber, historyLimit, q.calls)
+		if err != nil {
+			return q, exprErr(fmt.Sprintf("columns[%d]", i), err)
+		}
+		seen[column] = true
+		q.columns = append(q.columns, column)
+		q.values = append(q.values, node)
+	}
+	if q.columns == nil {
+		q.columns = []string{}
+	}
+
+	if strings.TrimSpace(req.Sort) != "" {
+		if q.sort, err = parseScreenExpr(req.Sort, exprNumber, historyLimit, q.calls); err != nil {
+			return q, exprErr("sort", err)
+		}
+	}
+	switch req.Order {
+	case "", "desc":
+	case "asc":
+		q.desc = false
+	default:
+		return q, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "order は asc/desc のいずれかを指定してください"}
+	}
+
+	if req.Limit != 0 {
+		if req.Limit < 0 || req.Limit > 500 {
+			return q, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください"}
+		}
+		q.limit = req.Limit
+	}
+
+	if len(q.calls) == 0 {
+		return q, &paramError{http.StatusUnprocessableEntity, "INVALID_EXPRESSION", "式には少なくとも1つの指標 (例: change(1h, 1)) を含めてください"}
+	}
+	return q, nil
+}
+
+func evalOrNil(node exprNode, env screenEnv) *float64 {
+	v, ok := node.eval(env)
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The screener expression language is deliberately small: numbers, metric
+// calls such as change(1h, 1), abs/min/max, arithmetic, comparisons and
+// and/or/not. Expressions are parsed into a typed AST and evaluated by
+// walking it; nothing is executed outside the nodes below.
+
+const (
+	maxExprLength = 2000
+	maxExprNodes  = 200
+	maxExprDepth  = 32
+)
+
+type exprKind int
+
+const (
+	exprNumber exprKind = iota
+	exprBool
+)
+
+func (k exprKind) String() string {
+	if k == exprBool {
+		return "真偽値"
+	}
+	return "数値"
+}
+
+// screenEnv supplies metric values for the symbol being evaluated. ok is
+// false when the symbol lacks enough history for the metric.
+type screenEnv interface {
+	metricValue(call *metricCall) (float64, bool)
+}
+
+// exprNode evaluates to a number, or to 1/0 for booleans. ok is false when
+// the value is undefined (missing metric, division by zero).
+type exprNode interface {
+	kind() exprKind
+	eval(env screenEnv) (float64, bool)
+}
+
+type numberNode struct{ value float64 }
+
+func (n *numberNode) kind() exprKind                 { return exprNumber }
+func (n *numberNode) eval(screenEnv) (float64, bool) { return n.value, true }
+
+type negateNode struct{ x exprNode }
+
+func (n *negateNode) kind() exprKind { return exprNumber }
+func (n *negateNode) eval(env screenEnv) (float64, bool) {
+	v, ok := n.x.eval(env)
+	return -v, ok
+}
+
+type notNode struct{ x exprNode }
+
+func (n *notNode) kind() exprKind { return exprBool }
+func (n *notNode) eval(env screenEnv) (float64, bool) {
+	v, ok := n.x.eval(env)
+	return 1 - v, ok
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) kind() exprKind {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return exprNumber
+	}
+	return exprBool
+}
+
+// eval uses three-valued logic for and/or so that a missing metric on one
+// side does not hide a decisive value on the other.
+func (n *binaryNode) eval(env screenEnv) (float64, bool) {
+	l, lok := n.left.eval(env)
+	switch n.op {
+	case "and":
+		if lok && l == 0 {
+			return 0, true
+		}
+		r, rok := n.right.eval(env)
+		if rok && r == 0 {
+			return 0, true
+		}
+		return 1, lok && rok
+	case "or":
+		if lok && l != 0 {
+			return 1, true
+		}
+		r, rok := n.right.eval(env)
+		if rok && r != 0 {
+			return 1, true
+		}
+		return 0, lok && rok
+	}
+
+	r, rok := n.right.eval(env)
+	if !lok || !rok {
+		return 0, false
+	}
+	switch n.op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	case ">":
+		return boolValue(l > r), true
+	case ">=":
+		return boolValue(l >= r), true
+	case "<":
+		return boolValue(l < r), true
+	case "<=":
+		return boolValue(l <= r), true
+	case "==":
+		return boolValue(l == r), true
+	case "!=":
+		return boolValue(l != r), true
+	}
+	return 0, false
+}
+
+type funcNode struct {
+	name string
+	args []exprNode
+}
+
+func (n *funcNode) kind() exprKind { return exprNumber }
+func (n *funcNode) eval(env screenEnv) (float64, bool) {
+	values := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, ok := arg.eval(env)
+		if !ok {
+			return 0, false
+		}
+		values[i] = v
+	}
+	switch n.name {
+	case "abs":
+		if values[0] < 0 {
+			return -values[0], true
+		}
+		return values[0], true
+	case "min":
+		out := values[0]
+		for _, v := range values[1:] {
+			if v < out {
+				out = v
+			}
+		}
+		return out, true
+	case "max":
+		out := values[0]
+		for _, v := range values[1:] {
+			if v > out {
+				out = v
+			}
+		}
+		return out, true
+	}
+	return 0, false
+}
+
+// metricCall is a per-symbol metric reference. key is its canonical text and
+// identifies it for memoisation and cost accounting.
+type metricCall struct {
+	name      string
+	timeframe string
+	args      []int
+	key       string
+}
+
+func (n *metricCall) kind() exprKind                     { return exprNumber }
+func (n *metricCall) eval(env screenEnv) (float64, bool) { return env.metricValue(n) }
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokWord
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// exprError carries the 1-based character position of a syntax error.
+type exprError struct {
+	pos int
+	msg string
+}
+
+func (e *exprError) Error() string {
+	return fmt.Sprintf("位置 %d: %s", e.pos, e.msg)
+}
+
+func tokenizeExpr(src string) ([]token, error) {
+	runes := []rune(src)
+	tokens := make([]token, 0, len(runes)/2)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					for j < len(runes) && unicode.IsDigit(runes[j]) {
+						j++
+					}
+					i = j
+				}
+			}
+			// Digits followed by letters form a word such as "1h".
+			if i < len(runes) && unicode.IsLetter(runes[i]) {
+				for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+					i++
+				}
+				tokens = append(tokens, token{tokWord, string(runes[start:i]), start + 1})
+				continue
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start + 1})
+		case unicode.IsLetter(c) || c == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, string(runes[start:i]), start + 1})
+		case c == '(':
+			i++
+			tokens = append(tokens, token{tokLParen, "(", start + 1})
+		case c == ')':
+			i++
+			tokens = append(tokens, token{tokRParen, ")", start + 1})
+		case c == ',':
+			i++
+			tokens = append(tokens, token{tokComma, ",", start + 1})
+		case strings.ContainsRune("+-*/", c):
+			i++
+			tokens = append(tokens, token{tokOp, string(c), start + 1})
+		case strings.ContainsRune("<>=!", c):
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "=" || op == "!" {
+				return nil, &exprError{start + 1, fmt.Sprintf("不明な演算子 %q です (== または != を使用してください)", op)}
+			}
+			tokens = append(tokens, token{tokOp, op, start + 1})
+		default:
+			return nil, &exprError{start + 1, fmt.Sprintf("使用できない文字 %q があります", c)}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(runes) + 1})
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens       []token
+	pos          int
+	nodes        int
+	depth        int
+	historyLimit int
+	calls        map[string]*metricCall
+}
+
+// parseScreenExpr parses src and checks that it evaluates to want. Metric
+// calls are deduplicated into calls, keyed by their canonical text.
+func parseScreenExpr(src string, want exprKind, historyLimit int, calls map[string]*metricCall) (exprNode, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, &exprError{1, "式が空です"}
+	}
+	if len([]rune(src)) > maxExprLength {
+		return nil, &exprError{1, fmt.Sprintf("式は%d文字以内で指定してください", maxExprLength)}
+	}
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, historyLimit: historyLimit, calls: calls}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &exprError{tok.pos, fmt.Sprintf("予期しないトークン %q です", tok.text)}
+	}
+	if node.kind() != want {
+		return nil, &exprError{1, fmt.Sprintf("式の結果は%sである必要があります", want)}
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() token { return p.tokens[p.pos] }
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokWord && strings.EqualFold(tok.text, word)
+}
+
+func (p *exprParser) node(n exprNode, pos int) (exprNode, error) {
+	p.nodes++
+	if p.nodes > maxExprNodes {
+		return nil, &exprError{pos, fmt.Sprintf("式の要素数が上限 (%d) を超えています", maxExprNodes)}
+	}
+	return n, nil
+}
+
+func (p *exprParser) expect(kind exprKind, n exprNode, pos int) error {
+	if n.kind() != kind {
+		return &exprError{pos, fmt.Sprintf("%sが必要な位置に%sがあります", kind, n.kind())}
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseLogical("or", p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseLogical("and", p.parseNot)
+}
+
+func (p *exprParser) parseLogical(op string, operand func() (exprNode, error)) (exprNode, error) {
+	pos := p.peek().pos
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(op) {
+		opTok := p.next()
+		rightPos := p.peek().pos
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(exprBool, left, pos); err != nil {
+			return nil, err
+		}
+		if err := p.expect(exprBool, right, rightPos); err != nil {
+			return nil, err
+		}
+		if left, err = p.node(&binaryNode{op: op, left: left, right: right}, opTok.pos); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if !p.isKeyword("not") {
+		return p.parseComparison()
+	}
+	tok := p.next()
+	if err := p.enter(tok.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	pos := p.peek().pos
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(exprBool, x, pos); err != nil {
+		return nil, err
+	}
+	return p.node(&notNode{x: x}, tok.pos)
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	pos := p.peek().pos
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokOp || !contains([]string{">", ">=", "<", "<=", "==", "!="}, tok.text) {
+		return left, nil
+	}
+	p.next()
+	rightPos := p.peek().pos
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(exprNumber, left, pos); err != nil {
+		return nil, err
+	}
+	if err := p.expect(exprNumber, right, rightPos); err != nil {
+		return nil, err
+	}
+	return p.node(&binaryNode{op: tok.text, left: left, right: right}, tok.pos)
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseArithmetic([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseArithmetic([]string{"*", "/"}, p.parseUnary)
+}
+
+func (p *exprParser) parseArithmetic(ops []string, operand func() (exprNode, error)) (exprNode, error) {
+	pos := p.peek().pos
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || !contains(ops, tok.text) {
+			return left, nil
+		}
+		p.next()
+		rightPos := p.peek().pos
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(exprNumber, left, pos); err != nil {
+			return nil, err
+		}
+		if err := p.expect(exprNumber, right, rightPos); err != nil {
+			return nil, err
+		}
+		if left, err = p.node(&binaryNode{op: tok.text, left: left, right: right}, tok.pos); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	tok := p.peek()
+	if tok.kind != tokOp || tok.text != "-" {
+		return p.parsePrimary()
+	}
+	p.next()
+	if err := p.enter(tok.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(exprNumber, x, tok.pos+1); err != nil {
+		return nil, err
+	}
+	return p.node(&negateNode{x: x}, tok.pos)
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &exprError{tok.pos, fmt.Sprintf("不正な数値 %q です", tok.text)}
+		}
+		return p.node(&numberNode{value: v}, tok.pos)
+	case tokLParen:
+		if err := p.enter(tok.pos); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &exprError{closing.pos, "閉じ括弧 ) がありません"}
+		}
+		return inner, nil
+	case tokWord:
+		name := strings.ToLower(tok.text)
+		if p.peek().kind != tokLParen {
+			return nil, &exprError{tok.pos, fmt.Sprintf("%q の後には ( が必要です", tok.text)}
+		}
+		p.next()
+		if err := p.enter(tok.pos); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		if _, ok := screenMetrics[name]; ok {
+			return p.parseMetricCall(name, tok.pos)
+		}
+		if name == "abs" || name == "min" || name == "max" {
+			return p.parseFuncCall(name, tok.pos)
+		}
+		return nil, &exprError{tok.pos, fmt.Sprintf("不明な関数 %q です", tok.text)}
+	case tokEOF:
+		return nil, &exprError{tok.pos, "式が途中で終わっています"}
+	}
+	return nil, &exprError{tok.pos, fmt.Sprintf("予期しないトークン %q です", tok.text)}
+}
+
+func (p *exprParser) parseFuncCall(name string, pos int) (exprNode, error) {
+	args := make([]exprNode, 0, 2)
+	for {
+		argPos := p.peek().pos
+		arg, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(exprNumber, arg, argPos); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		tok := p.next()
+		if tok.kind == tokRParen {
+			break
+		}
+		if tok.kind != tokComma {
+			return nil, &exprError{tok.pos, "引数の区切りには , を使用してください"}
+		}
+	}
+	if name == "abs" && len(args) != 1 {
+		return nil, &exprError{pos, "abs の引数は1つです"}
+	}
+	if name != "abs" && len(args) < 2 {
+		return nil, &exprError{pos, fmt.Sprintf("%s の引数は2つ以上です", name)}
+	}
+	return p.node(&funcNode{name: name, args: args}, pos)
+}
+
+// parseMetricCall reads "name(timeframe, n...)". Bar counts must be integer
+// literals so the evaluation cost is known before anything runs.
+func (p *exprParser) parseMetricCall(name string, pos int) (exprNode, error) {
+	metric := screenMetrics[name]
+	tfTok := p.next()
+	if tfTok.kind != tokWord || !contains(validTimeframes, tfTok.text) {
+		return nil, &exprError{tfTok.pos, fmt.Sprintf("%s の第1引数にはタイムフレーム (%s) を指定してください", name, strings.Join(validTimeframes, ", "))}
+	}
+
+	args := make([]int, 0, len(metric.params))
+	for {
+		tok := p.next()
+		if tok.kind == tokRParen {
+			break
+		}
+		if tok.kind != tokComma {
+			return nil, &exprError{tok.pos, "引数の区切りには , を使用してください"}
+		}
+		argTok := p.next()
+		n, err := strconv.Atoi(argTok.text)
+		if argTok.kind != tokNumber || err != nil || n <= 0 {
+			return nil, &exprError{argTok.pos, fmt.Sprintf("%s の引数には1以上の整数を指定してください", name)}
+		}
+		args = append(args, n)
+	}
+	if len(args) != len(metric.params) {
+		usage := strings.Join(append([]string{name + "(timeframe"}, metric.params...), ", ") + ")"
+		return nil, &exprError{pos, fmt.Sprintf("引数の数が正しくありません: %s", usage)}
+	}
+	if bars := metric.bars(args, p.historyLimit); bars > p.historyLimit {
+		return nil, &exprError{pos, fmt.Sprintf("%s には%d本のローソク足が必要ですが、利用可能な履歴は最大%d本です", name, bars, p.historyLimit)}
+	}
+
+	keyParts := []string{tfTok.text}
+	for _, n := range args {
+		keyParts = append(keyParts, strconv.Itoa(n))
+	}
+	key := name + "(" + strings.Join(keyParts, ",") + ")"
+	if existing, ok := p.calls[key]; ok {
+		return p.node(existing, pos)
+	}
+	call := &metricCall{name: name, timeframe: tfTok.text, args: args, key: key}
+	p.calls[key] = call
+	return p.node(call, pos)
+}
+
+func (p *exprParser) enter(pos int) error {
+	p.depth++
+	if p.depth > maxExprDepth {
+		return &exprError{pos, fmt.Sprintf("式の入れ子が深すぎます (最大%d)", maxExprDepth)}
+	}
+	return nil
+}
+
+func (p *exprParser) leave() { p.depth-- }
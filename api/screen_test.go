@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+type fakeScreenEnv map[string]float64
+
+func (e fakeScreenEnv) metricValue(call *metricCall) (float64, bool) {
+	v, ok := e[call.key]
+	return v, ok
+}
+
+func TestScreenExprEvaluatesFilter(t *testing.T) {
+	calls := make(map[string]*metricCall)
+	node, err := parseScreenExpr("change(1h, 1) > 5 and turnover(1h,24) > 50e6 and not rsi(1h, 14) >= 80", exprBool, 1000, calls)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("calls = %v, want 3 distinct metric calls", calls)
+	}
+
+	cases := []struct {
+		name string
+		env  fakeScreenEnv
+		want float64
+		ok   bool
+	}{
+		{"match", fakeScreenEnv{"change(1h,1)": 6, "turnover(1h,24)": 6e7, "rsi(1h,14)": 70}, 1, true},
+		{"rsi too high", fakeScreenEnv{"change(1h,1)": 6, "turnover(1h,24)": 6e7, "rsi(1h,14)": 85}, 0, true},
+		{"missing rsi is undecided", fakeScreenEnv{"change(1h,1)": 6, "turnover(1h,24)": 6e7}, 1, false},
+		{"false side decides despite missing", fakeScreenEnv{"change(1h,1)": 1}, 0, true},
+	}
+	for _, tc := range cases {
+		got, ok := node.eval(tc.env)
+		if got != tc.want || ok != tc.ok {
+			t.Fatalf("%s: eval = (%v, %v), want (%v, %v)", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestScreenExprArithmeticAndFunctions(t *testing.T) {
+	node, err := parseScreenExpr("-abs(change(4h,6)) + max(2, 3) * 2 / (1 - 0.5)", exprNumber, 1000, map[string]*metricCall{})
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	got, ok := node.eval(fakeScreenEnv{"change(4h,6)": -3})
+	if !ok || got != 9 {
+		t.Fatalf("eval = (%v, %v), want (9, true)", got, ok)
+	}
+
+	div, _ := parseScreenExpr("price(1h) / 0", exprNumber, 1000, map[string]*metricCall{})
+	if _, ok := div.eval(fakeScreenEnv{"price(1h)": 1}); ok {
+		t.Fatal("division by zero should be undefined")
+	}
+}
+
+func TestScreenExprRejectsInvalidInput(t *testing.T) {
+	cases := map[string]string{
+		"change(1h, 1)":               "真偽値",
+		"change(2h, 1) > 1":           "タイムフレーム",
+		"change(1h) > 1":              "引数の数",
+		"change(1h, 1.5) > 1":         "整数",
+		"change(1h, 5000) > 1":        "履歴",
+		"exec(1h) > 1":                "不明な関数",
+		"change(1h,1) > 1 and 5":      "真偽値",
+		"change(1h,1) = 1":            "==",
+		"(change(1h,1) > 1":           "閉じ括弧",
+		strings.Repeat("(", 40) + "1": "入れ子",
+	}
+	for src, wantMsg := range cases {
+		_, err := parseScreenExpr(src, exprBool, 1000, map[string]*metricCall{})
+		if err == nil || !strings.Contains(err.Error(), wantMsg) {
+			t.Fatalf("parseScreenExpr(%q) error = %v, want message containing %q", src, err, wantMsg)
+		}
+	}
+}
@@ -15,17 +15,65 @@ func buildOpenAPISpec() ([]byte, error) {
 			Consumes: []string{"application/json"},
 			Produces: []string{"application/json"},
 			Paths: &spec.Paths{Paths: map[string]spec.PathItem{
-				"/":           {PathItemProps: spec.PathItemProps{Get: spec.NewOperation("root").WithSummary("Root endpoint").WithDescription("Service root endpoint").RespondsWith(200, schemaResponse("Root response", "#/definitions/RootResponse"))}},
-				"/volatility": {PathItemProps: spec.PathItemProps{Get: volatilityOperation()}},
-				"/volume":     {PathItemProps: spec.PathItemProps{Get: volumeOperation()}},
+				"/":                   {PathItemProps: spec.PathItemProps{Get: spec.NewOperation("root").WithSummary("Root endpoint").WithDescription("Service root endpoint").RespondsWith(200, schemaResponse("Root response", "#/definitions/RootResponse"))}},
+				"/volatility":         {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(volatilityOperation()))}},
+				"/stream/volatility":  {PathItemProps: spec.PathItemProps{Get: volatilityStreamOperation()}},
+				"/volume":             {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(volumeOperation()))}},
+				"/volume/spikes":      {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(volumeSpikesOperation()))}},
+				"/correlation":        {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(correlationOperation()))}},
+				"/correlation/matrix": {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(correlationMatrixOperation()))}},
+				"/breadth":            {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(breadthOperation()))}},
+				"/indicators":         {PathItemProps: spec.PathItemProps{Get: withConditional(withFormats(indicatorsOperation()))}},
+				"/screen":             {PathItemProps: spec.PathItemProps{Post: withFormats(screenOperation())}},
+				"/alerts/rules":       {PathItemProps: spec.PathItemProps{Get: withFormats(listAlertRulesOperation()), Post: withFormats(createAlertRuleOperation())}},
+				"/alerts/rules/{id}":  {PathItemProps: spec.PathItemProps{Get: withFormats(getAlertRuleOperation()), Put: withFormats(updateAlertRuleOperation()), Delete: deleteAlertRuleOperation()}},
+				"/alerts/firings":     {PathItemProps: spec.PathItemProps{Get: withFormats(alertFiringsOperation())}},
+				"/candles":            {PathItemProps: spec.PathItemProps{Get: withFormats(candlesOperation())}},
+				"/symbols":            {PathItemProps: spec.PathItemProps{Get: withFormats(symbolsOperation())}},
+				"/symbols/{symbol}":   {PathItemProps: spec.PathItemProps{Get: withFormats(symbolOperation())}},
+				"/readyz":             {PathItemProps: spec.PathItemProps{Get: readyOperation()}},
 			}},
 			Definitions: apiDefinitions(),
+			SecurityDefinitions: spec.SecurityDefinitions{
+				"ApiKey": apiKeySecurityScheme(),
+			},
+			Security: []map[string][]string{{"ApiKey": {}}},
 		},
 	}
+	// The root and the readiness probe never require a key.
+	for _, path := range []string{"/", "/readyz"} {
+		sw.Paths.Paths[path].Get.Security = []map[string][]string{}
+	}
 
 	return sw.MarshalJSON()
 }
 
+func apiKeySecurityScheme() *spec.SecurityScheme {
+	scheme := spec.APIKeyAuth("X-API-Key", "header")
+	scheme.Description = "API_KEYS_FILE 設定時のみ必要。Authorization: Bearer <key> も使用できます。スコープ: read (データ取得), stream (/stream/*), alerts-admin (/alerts/*)。"
+	return scheme
+}
+
+// withFormats documents the content negotiation shared by data endpoints.
+func withFormats(op *spec.Operation) *spec.Operation {
+	formatParam := spec.QueryParam("format").Typed("string", "").WithDescription("レスポンス形式。Accept ヘッダーより優先されます。csv はネストした項目を price.close のような列に展開し、csv/ndjson は data の各要素を1行として出力します (next_cursor は X-Next-Cursor ヘッダー)。エラーは常にJSONです。")
+	formatParam.Default = formatJSON
+	formatParam.Enum = toAnySlice(responseFormats)
+
+	op.Produces = []string{"application/json", "text/csv", "application/x-ndjson", "application/msgpack"}
+	op.Parameters = append(op.Parameters, *formatParam)
+	return op
+}
+
+// withConditional documents the validators of snapshot-backed endpoints.
+func withConditional(op *spec.Operation) *spec.Operation {
+	ifNoneMatch := spec.HeaderParam("If-None-Match").Typed("string", "").WithDescription("以前のレスポンスの ETag。キャッシュが更新されていなければ 304 を返します。")
+	ifModifiedSince := spec.HeaderParam("If-Modified-Since").Typed("string", "").WithDescription("If-None-Match がない場合のみ使用。最新のローソク足がこの時刻以前なら 304 を返します。")
+	op.Parameters = append(op.Parameters, *ifNoneMatch, *ifModifiedSince)
+	op.Responses.StatusCodeResponses[304] = *spec.NewResponse().WithDescription("未更新 (本文なし)")
+	return op
+}
+
 func volatilityOperation() *spec.Operation {
 	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("分析したいタイムフレーム。")
 	tfParam.Required = true
@@ -53,16 +101,59 @@ func volatilityOperation() *spec.Operation {
 	limitParam.Minimum = float64Ptr(1)
 	limitParam.Maximum = float64Ptr(500)
 
+	metricParam := spec.QueryParam("metric").Typed("string", "").WithDescription("ランキングに使う指標。change は offset 本前との終値変化率、それ以外は直近 offset 本で計算: range_pct は(高値-安値)/安値、atr_pct はATR/終値、stdev は対数リターンの標準偏差、parkinson/garman_klass/rogers_satchell は各推定量 (いずれも%)。change 以外では threshold をこの値に適用します。")
+	metricParam.Default = metricChange
+	metricParam.Enum = toAnySlice(volatilityMetrics)
+
+	annualizeParam := spec.QueryParam("annualize").Typed("boolean", "").WithDescription("true の場合、stdev/parkinson/garman_klass/rogers_satchell を年率換算 (√(年間本数)倍) します。")
+	annualizeParam.Default = false
+
+	minTurnoverParam := spec.QueryParam("min_turnover").Typed("number", "double").WithDescription("liquidity_period の合計売買代金での足切り値。")
+	minTurnoverParam.Minimum = float64Ptr(0)
+	minTurnoverParam.ExclusiveMinimum = true
+
+	minVolumeParam := spec.QueryParam("min_volume").Typed("number", "double").WithDescription("liquidity_period の合計出来高での足切り値。")
+	minVolumeParam.Minimum = float64Ptr(0)
+	minVolumeParam.ExclusiveMinimum = true
+
+	liquidityPeriodParam := spec.QueryParam("liquidity_period").Typed("string", "").WithDescription("min_turnover/min_volume を集計する期間。形成中の足を除く確定足で集計します。省略時は24h (履歴が足りない場合は利用可能な本数、24h より長いタイムフレームでは1本)。明示した期間が OHLCV_HISTORY_LIMIT - 1 本を超える場合は 400 INSUFFICIENT_HISTORY。")
+	liquidityPeriodParam.Enum = toAnySlice(validPeriods)
+
+	symbolsParam := spec.QueryParam("symbols").Typed("string", "").WithDescription("対象とする銘柄のカンマ区切りリスト。例: BTCUSDT,ETHUSDT")
+	excludeParam := spec.QueryParam("exclude").Typed("string", "").WithDescription("除外する銘柄のカンマ区切りリスト。")
+	baseParam := spec.QueryParam("base").Typed("string", "").WithDescription("銘柄シンボルの先頭一致で絞り込みます。例: BTC")
+
+	maxPctParam := spec.QueryParam("max_pct").Typed("number", "double").WithDescription("threshold と同じ値に適用する上限(%)。異常値の除外に使います。threshold 以上を指定してください。")
+	maxPctParam.Minimum = float64Ptr(0)
+	maxPctParam.ExclusiveMinimum = true
+
 	op := spec.NewOperation("getVolatility").
 		WithSummary("価格変動率の高い銘柄を取得").
 		WithDescription("指定閾値を超える銘柄の変動率データを返します。").
 		WithTags("volatility")
-	op.Parameters = []spec.Parameter{*tfParam, *thresholdParam, *offsetParam, *directionParam, *sortParam, *limitParam}
+	op.Parameters = []spec.Parameter{*tfParam, *thresholdParam, *offsetParam, *directionParam, *sortParam, *limitParam, *metricParam, *annualizeParam, *minTurnoverParam, *minVolumeParam, *liquidityPeriodParam, *symbolsParam, *excludeParam, *baseParam, *maxPctParam, *asOfParam()}
 	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
 		200: *schemaResponse("成功", "#/definitions/VolatilityResponse"),
 		400: *schemaResponse("不正なtimeframe", "#/definitions/ErrorResponse"),
 		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
 		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)。Retry-After ヘッダーを返します", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func volatilityStreamOperation() *spec.Operation {
+	op := spec.NewOperation("streamVolatility").
+		WithSummary("価格変動率ランキングの更新をストリーム配信").
+		WithDescription("Server-Sent Events で配信します。接続直後に /volatility と同じ内容の snapshot イベントを送り、以降はキャッシュ更新のたびにランキングが変わった場合だけ diff イベント (VolatilityStreamDiff) を送ります。接続維持のためコメント行のハートビートを送り、受信が追いつかないクライアントには SLOW_CONSUMER、サーバー停止時には SHUTTING_DOWN の error イベントを送って切断します。").
+		WithTags("volatility")
+	op.Produces = []string{"text/event-stream"}
+	op.Parameters = volatilityOperation().Parameters
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *spec.NewResponse().WithDescription("イベントストリーム (snapshot: VolatilityResponse, diff: VolatilityStreamDiff, error: ErrorResponse)"),
+		400: *schemaResponse("不正なtimeframe", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING) または同時接続数の上限 (TOO_MANY_SUBSCRIBERS)", "#/definitions/ErrorResponse"),
 	}}}
 	return op
 }
@@ -84,9 +175,12 @@ func volumeOperation() *spec.Operation {
 	minVolumeTargetParam.Default = "turnover"
 	minVolumeTargetParam.Enum = []any{"volume", "turnover"}
 
-	sortParam := spec.QueryParam("sort").Typed("string", "").WithDescription("結果のソート順。")
+	compareParam := spec.QueryParam("compare").Typed("string", "").WithDescription("previous を指定すると直前の同じ長さの期間と比較します。")
+	compareParam.Enum = []any{"previous"}
+
+	sortParam := spec.QueryParam("sort").Typed("string", "").WithDescription("結果のソート順。*_change_* は compare=previous の場合のみ指定できます。")
 	sortParam.Default = "volume_desc"
-	sortParam.Enum = []any{"volume_desc", "volume_asc", "turnover_desc", "turnover_asc", "symbol_asc"}
+	sortParam.Enum = append([]any{"volume_desc", "volume_asc", "turnover_desc", "turnover_asc", "symbol_asc"}, toAnySlice(volumeChangeSortKeys)...)
 
 	limitParam := spec.QueryParam("limit").Typed("integer", "int32").WithDescription("取得する最大件数。")
 	limitParam.Default = 100
@@ -97,12 +191,424 @@ func volumeOperation() *spec.Operation {
 		WithSummary("指定期間の出来高ランキングを取得").
 		WithDescription("指定期間内の合計出来高・合計売買代金ランキングを返します。").
 		WithTags("volume")
-	op.Parameters = []spec.Parameter{*tfParam, *periodParam, *minVolumeParam, *minVolumeTargetParam, *sortParam, *limitParam}
+	op.Parameters = []spec.Parameter{*tfParam, *periodParam, *minVolumeParam, *minVolumeTargetParam, *compareParam, *sortParam, *limitParam, *asOfParam()}
 	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
 		200: *schemaResponse("成功", "#/definitions/VolumeResponse"),
 		400: *schemaResponse("不正なtimeframe/period", "#/definitions/ErrorResponse"),
 		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
 		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)。Retry-After ヘッダーを返します", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func volumeSpikesOperation() *spec.Operation {
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("出来高比較に使うOHLCVのタイムフレーム。")
+	tfParam.Required = true
+	tfParam.Enum = toAnySlice(validTimeframes)
+
+	windowParam := spec.QueryParam("window").Typed("integer", "int32").WithDescription("直近何本 (形成中の足を除く確定足) の平均を比較対象とするか。")
+	windowParam.Default = 1
+	windowParam.Minimum = float64Ptr(1)
+
+	lookbackParam := spec.QueryParam("lookback").Typed("integer", "int32").WithDescription("基準とする直前の本数。window + lookback + 1 が履歴の上限以下である必要があります。")
+	lookbackParam.Default = 20
+	lookbackParam.Minimum = float64Ptr(2)
+
+	targetParam := spec.QueryParam("target").Typed("string", "").WithDescription("比較対象 (volume or turnover)。")
+	targetParam.Default = "turnover"
+	targetParam.Enum = []any{"volume", "turnover"}
+
+	methodParam := spec.QueryParam("method").Typed("string", "").WithDescription("スコアの算出方法。mean/median は基準の平均/中央値に対する倍率、zscore は基準の平均・標準偏差によるzスコア。")
+	methodParam.Default = "mean"
+	methodParam.Enum = toAnySlice(spikeMethods)
+
+	thresholdParam := spec.QueryParam("threshold").Typed("number", "double").WithDescription("スコアの閾値。up は score >= threshold、down は倍率が 1/threshold 以下 (zscore は -threshold 以下)。mean/median では1より大きい値が必要です。")
+	thresholdParam.Minimum = float64Ptr(0)
+	thresholdParam.ExclusiveMinimum = true
+
+	directionParam := spec.QueryParam("direction").Typed("string", "").WithDescription("増加 (up) / 減少 (down) / 両方 (both)。")
+	directionParam.Default = "up"
+	directionParam.Enum = []any{"up", "down", "both"}
+
+	minTurnoverParam := spec.QueryParam("min_turnover").Typed("number", "double").WithDescription("直近 window 本の合計売買代金での足切り値。")
+	minTurnoverParam.Minimum = float64Ptr(0)
+	minTurnoverParam.ExclusiveMinimum = true
+
+	sortParam := spec.QueryParam("sort").Typed("string", "").WithDescription("結果のソート順。")
+	sortParam.Default = "spike_desc"
+	sortParam.Enum = []any{"spike_desc", "spike_asc", "symbol_asc"}
+
+	limitParam := spec.QueryParam("limit").Typed("integer", "int32").WithDescription("取得する最大件数。")
+	limitParam.Default = 100
+	limitParam.Minimum = float64Ptr(1)
+	limitParam.Maximum = float64Ptr(500)
+
+	op := spec.NewOperation("getVolumeSpikes").
+		WithSummary("出来高急増銘柄を取得").
+		WithDescription("直近の出来高/売買代金を直前の基準期間と比較し、倍率とzスコアを返します。").
+		WithTags("volume")
+	op.Parameters = []spec.Parameter{*tfParam, *windowParam, *lookbackParam, *targetParam, *methodParam, *thresholdParam, *directionParam, *minTurnoverParam, *sortParam, *limitParam, *asOfParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/VolumeSpikesResponse"),
+		400: *schemaResponse("不正なtimeframe/履歴不足", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func correlationWindowParam() *spec.Parameter {
+	windowParam := spec.QueryParam("window").Typed("integer", "int32").WithDescription("相関・ベータの計算に使うリターンの本数 (window+1 本のローソク足が必要)。")
+	windowParam.Default = 100
+	windowParam.Minimum = float64Ptr(3)
+	return windowParam
+}
+
+func asOfParam() *spec.Parameter {
+	return spec.QueryParam("as_of").Typed("string", "").WithDescription("評価時刻。ミリ秒のUNIX時刻またはRFC3339。この時刻以前に始まったローソク足だけで計算します。キャッシュの範囲外ならデータベースから読み込みます。データベースの保持期間 (各銘柄 OHLCV_HISTORY_LIMIT 本) より前にさかのぼる場合は 422 INSUFFICIENT_HISTORY。省略時は最新。")
+}
+
+func correlationOperation() *spec.Operation {
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("リターンの計算に使うタイムフレーム。")
+	tfParam.Required = true
+	tfParam.Enum = toAnySlice(validTimeframes)
+
+	benchmarkParam := spec.QueryParam("benchmark").Typed("string", "").WithDescription("ベンチマーク銘柄。")
+	benchmarkParam.Default = "BTCUSDT"
+
+	sortParam := spec.QueryParam("sort").Typed("string", "").WithDescription("結果のソート順。")
+	sortParam.Default = "correlation_desc"
+	sortParam.Enum = toAnySlice(correlationSortKeys)
+
+	limitParam := spec.QueryParam("limit").Typed("integer", "int32").WithDescription("取得する最大件数。")
+	limitParam.Default = 100
+	limitParam.Minimum = float64Ptr(1)
+	limitParam.Maximum = float64Ptr(500)
+
+	op := spec.NewOperation("getCorrelation").
+		WithSummary("ベンチマークに対する相関・ベータを取得").
+		WithDescription("タイムスタンプで揃えた対数リターンから、各銘柄のベンチマークに対する相関係数、ベータ、残差ボラティリティを返します。").
+		WithTags("correlation")
+	op.Parameters = []spec.Parameter{*tfParam, *correlationWindowParam(), *benchmarkParam, *sortParam, *limitParam, *asOfParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/CorrelationResponse"),
+		400: *schemaResponse("不正なtimeframe/銘柄/履歴不足", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("ベンチマーク銘柄のデータなし (SYMBOL_NOT_FOUND)", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func correlationMatrixOperation() *spec.Operation {
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("リターンの計算に使うタイムフレーム。")
+	tfParam.Required = true
+	tfParam.Enum = toAnySlice(validTimeframes)
+
+	symbolsParam := spec.QueryParam("symbols").Typed("string", "").WithDescription("カンマ区切りの銘柄リスト (2〜50銘柄)。例: BTCUSDT,ETHUSDT,SOLUSDT")
+	symbolsParam.Required = true
+
+	op := spec.NewOperation("getCorrelationMatrix").
+		WithSummary("銘柄間の相関行列を取得").
+		WithDescription("指定した銘柄の対数リターンのペアごとの相関係数を返します。重なりが不足するペアは null です。").
+		WithTags("correlation")
+	op.Parameters = []spec.Parameter{*tfParam, *correlationWindowParam(), *symbolsParam, *asOfParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/CorrelationMatrixResponse"),
+		400: *schemaResponse("不正なtimeframe/銘柄/履歴不足", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("銘柄のデータなし (SYMBOL_NOT_FOUND)", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func breadthOperation() *spec.Operation {
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("変化率の計算に使うタイムフレーム。")
+	tfParam.Required = true
+	tfParam.Enum = toAnySlice(validTimeframes)
+
+	offsetParam := spec.QueryParam("offset").Typed("integer", "int32").WithDescription("何本前の終値と比較するか。")
+	offsetParam.Default = 1
+	offsetParam.Minimum = float64Ptr(1)
+
+	lookbackParam := spec.QueryParam("lookback").Typed("integer", "int32").WithDescription("高値・安値の更新判定に使う直前の本数。")
+	lookbackParam.Default = 20
+	lookbackParam.Minimum = float64Ptr(1)
+
+	bucketsParam := spec.QueryParam("buckets").Typed("string", "").WithDescription("ヒストグラムの境界 (%) を昇順のカンマ区切りで指定します (最大50個)。")
+	bucketsParam.Default = "-10,-5,-2,-1,0,1,2,5,10"
+
+	op := spec.NewOperation("getBreadth").
+		WithSummary("市場全体の騰落状況を取得").
+		WithDescription("全銘柄の offset 本前からの終値変化率について、上昇・下落・横ばいの銘柄数、騰落比率、平均・中央値・売買代金加重平均、ヒストグラム、および直前 lookback 本の高値を上回る / 安値を下回る銘柄の割合を返します。").
+		WithTags("breadth")
+	op.Parameters = []spec.Parameter{*tfParam, *offsetParam, *lookbackParam, *bucketsParam, *asOfParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/BreadthResponse"),
+		400: *schemaResponse("不正なtimeframe/履歴不足", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func indicatorsOperation() *spec.Operation {
+	symbolParam := spec.QueryParam("symbol").Typed("string", "").WithDescription("銘柄シンボル (例: BTCUSDT)。")
+	symbolParam.Required = true
+
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("指標の計算に使うタイムフレーム。")
+	tfParam.Required = true
+	tfParam.Enum = toAnySlice(validTimeframes)
+
+	setParam := spec.QueryParam("set").Typed("string", "").WithDescription("カンマ区切りの指標 (name:param...)。sma:N, ema:N, rsi:N, macd:FAST:SLOW:SIGNAL, bbands:N:K, atr:N, vwap:N, highlow:N。パラメータ省略時は既定値。例: rsi:14,ema:50,macd")
+	setParam.Required = true
+
+	limitParam := spec.QueryParam("limit").Typed("integer", "int32").WithDescription("返却する最新の行数。指標はキャッシュ済みの全履歴で計算されます。")
+	limitParam.Default = defaultIndicatorsLimit
+	limitParam.Minimum = float64Ptr(1)
+
+	op := spec.NewOperation("getIndicators").
+		WithSummary("テクニカル指標を取得").
+		WithDescription("キャッシュ済みのローソク足からテクニカル指標を計算して古い順に返します。").
+		WithTags("indicators")
+	op.Parameters = []spec.Parameter{*symbolParam, *tfParam, *setParam, *limitParam, *asOfParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/IndicatorsResponse"),
+		400: *schemaResponse("不正なtimeframe/銘柄", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("銘柄のデータなし (SYMBOL_NOT_FOUND)", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func screenOperation() *spec.Operation {
+	bodyParam := spec.BodyParam("body", spec.RefSchema("#/definitions/ScreenRequest")).WithDescription("スクリーニング条件。")
+	bodyParam.Required = true
+
+	op := spec.NewOperation("screen").
+		WithSummary("条件式で銘柄をスクリーニング").
+		WithDescription("複数タイムフレームの指標を組み合わせた条件式 (例: change(1h,1) > 5 and turnover(1h,24) > 50e6 and rsi(1h,14) < 80) をキャッシュ済みデータで評価します。評価コスト (読み込むローソク足の本数 × 銘柄数) が SCREEN_MAX_COST を超える場合は QUERY_TOO_EXPENSIVE を返します。").
+		WithTags("screen")
+	op.Consumes = []string{"application/json"}
+	op.Parameters = []spec.Parameter{*bodyParam, *asOfParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/ScreenResponse"),
+		400: *schemaResponse("不正なJSON (INVALID_BODY)", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("式の誤り (INVALID_EXPRESSION)、コスト超過 (QUERY_TOO_EXPENSIVE)、入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func listAlertRulesOperation() *spec.Operation {
+	op := spec.NewOperation("listAlertRules").
+		WithSummary("アラートルール一覧を取得").
+		WithTags("alerts")
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/AlertRulesResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("アラート機能が無効 (ALERTS_DISABLED)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func createAlertRuleOperation() *spec.Operation {
+	bodyParam := spec.BodyParam("body", spec.RefSchema("#/definitions/AlertRuleInput")).WithDescription("作成するルール。")
+	bodyParam.Required = true
+
+	op := spec.NewOperation("createAlertRule").
+		WithSummary("アラートルールを作成").
+		WithDescription("キャッシュ更新のたびに評価され、閾値を超えた銘柄をWebhookへ通知します。同じルール・銘柄・ローソク足の組み合わせは一度だけ発火し、cooldown_seconds の間は同じ銘柄で再発火しません。").
+		WithTags("alerts")
+	op.Consumes = []string{"application/json"}
+	op.Parameters = []spec.Parameter{*bodyParam}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		201: *schemaResponse("作成成功", "#/definitions/AlertRule"),
+		400: *schemaResponse("不正なJSON/timeframe/銘柄", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("アラート機能が無効 (ALERTS_DISABLED)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func alertRuleIDParam() *spec.Parameter {
+	p := spec.PathParam("id").Typed("integer", "int64").WithDescription("ルールID。")
+	p.Required = true
+	return p
+}
+
+func getAlertRuleOperation() *spec.Operation {
+	op := spec.NewOperation("getAlertRule").
+		WithSummary("アラートルールを取得").
+		WithTags("alerts")
+	op.Parameters = []spec.Parameter{*alertRuleIDParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/AlertRule"),
+		400: *schemaResponse("不正なルールID", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("ルールが存在しない (RULE_NOT_FOUND)", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("アラート機能が無効 (ALERTS_DISABLED)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func updateAlertRuleOperation() *spec.Operation {
+	bodyParam := spec.BodyParam("body", spec.RefSchema("#/definitions/AlertRuleInput")).WithDescription("置き換えるルール。省略した項目はデフォルト値になります。")
+	bodyParam.Required = true
+
+	op := spec.NewOperation("updateAlertRule").
+		WithSummary("アラートルールを更新").
+		WithTags("alerts")
+	op.Consumes = []string{"application/json"}
+	op.Parameters = []spec.Parameter{*alertRuleIDParam(), *bodyParam}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/AlertRule"),
+		400: *schemaResponse("不正なルールID/JSON/timeframe/銘柄", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("ルールが存在しない (RULE_NOT_FOUND)", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("アラート機能が無効 (ALERTS_DISABLED)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func deleteAlertRuleOperation() *spec.Operation {
+	op := spec.NewOperation("deleteAlertRule").
+		WithSummary("アラートルールを削除").
+		WithDescription("ルールを削除します。発火履歴は残ります。").
+		WithTags("alerts")
+	op.Parameters = []spec.Parameter{*alertRuleIDParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		204: *spec.NewResponse().WithDescription("削除成功"),
+		400: *schemaResponse("不正なルールID", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("ルールが存在しない (RULE_NOT_FOUND)", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("アラート機能が無効 (ALERTS_DISABLED)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func alertFiringsOperation() *spec.Operation {
+	ruleParam := spec.QueryParam("rule_id").Typed("integer", "int64").WithDescription("ルールIDで絞り込みます。")
+	statusParam := spec.QueryParam("status").Typed("string", "").WithDescription("配信状態で絞り込みます。")
+	statusParam.Enum = []any{alertStatusPending, alertStatusDelivered, alertStatusFailed}
+
+	limitParam := spec.QueryParam("limit").Typed("integer", "int32").WithDescription("取得する最大件数。")
+	limitParam.Default = 100
+	limitParam.Minimum = float64Ptr(1)
+	limitParam.Maximum = float64Ptr(500)
+
+	op := spec.NewOperation("listAlertFirings").
+		WithSummary("アラートの発火履歴を取得").
+		WithDescription("新しい順に発火履歴とWebhookの配信状態を返します。").
+		WithTags("alerts")
+	op.Parameters = []spec.Parameter{*ruleParam, *statusParam, *limitParam}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/AlertFiringsResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("アラート機能が無効 (ALERTS_DISABLED)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func candlesOperation() *spec.Operation {
+	symbolParam := spec.QueryParam("symbol").Typed("string", "").WithDescription("銘柄シンボル (例: BTCUSDT)。")
+	symbolParam.Required = true
+
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("ローソク足のタイムフレーム。")
+	tfParam.Required = true
+	tfParam.Enum = toAnySlice(validTimeframes)
+
+	fromParam := spec.QueryParam("from").Typed("string", "").WithDescription("開始時刻 (含む)。ミリ秒のUNIX時刻またはRFC3339。")
+	toParam := spec.QueryParam("to").Typed("string", "").WithDescription("終了時刻 (含む)。ミリ秒のUNIX時刻またはRFC3339。")
+
+	orderParam := spec.QueryParam("order").Typed("string", "").WithDescription("タイムスタンプの並び順。")
+	orderParam.Default = "asc"
+	orderParam.Enum = []any{"asc", "desc"}
+
+	limitParam := spec.QueryParam("limit").Typed("integer", "int32").WithDescription("1ページの最大件数。")
+	limitParam.Default = defaultCandlesLimit
+	limitParam.Minimum = float64Ptr(1)
+	limitParam.Maximum = float64Ptr(maxCandlesLimit)
+
+	cursorParam := spec.QueryParam("cursor").Typed("string", "").WithDescription("前のレスポンスの next_cursor。symbol/timeframe/order は同じ値を指定してください。")
+
+	op := spec.NewOperation("getCandles").
+		WithSummary("ローソク足 (OHLCV) を取得").
+		WithDescription("指定銘柄・タイムフレームのOHLCVを時刻範囲とカーソルページングで返します。").
+		WithTags("candles")
+	op.Parameters = []spec.Parameter{*symbolParam, *tfParam, *fromParam, *toParam, *orderParam, *limitParam, *cursorParam}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/CandlesResponse"),
+		400: *schemaResponse("不正なsymbol/timeframe/cursor", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func coverageTimeframeParam() *spec.Parameter {
+	tfParam := spec.QueryParam("timeframe").Typed("string", "").WithDescription("対象のタイムフレーム。省略時はキャッシュ対象の全タイムフレーム。")
+	tfParam.Enum = toAnySlice(validTimeframes)
+	return tfParam
+}
+
+func symbolsOperation() *spec.Operation {
+	staleParam := spec.QueryParam("stale").Typed("boolean", "").WithDescription("true の場合、更新が止まっているタイムフレームを持つ銘柄のみ返します。")
+	staleParam.Default = false
+
+	op := spec.NewOperation("getSymbols").
+		WithSummary("銘柄一覧を取得").
+		WithDescription("追跡中の銘柄と、タイムフレーム別の保持本数・最古/最新時刻・最新足の経過秒数・欠損本数・銘柄情報を返します。").
+		WithTags("symbols")
+	op.Parameters = []spec.Parameter{*coverageTimeframeParam(), *staleParam}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/SymbolsResponse"),
+		400: *schemaResponse("不正なtimeframe", "#/definitions/ErrorResponse"),
+		422: *schemaResponse("入力検証エラー", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func symbolOperation() *spec.Operation {
+	symbolParam := spec.PathParam("symbol").Typed("string", "").WithDescription("銘柄シンボル (例: BTCUSDT)。")
+
+	op := spec.NewOperation("getSymbol").
+		WithSummary("銘柄の詳細を取得").
+		WithDescription("指定銘柄のタイムフレーム別カバレッジと銘柄情報を返します。").
+		WithTags("symbols")
+	op.Parameters = []spec.Parameter{*symbolParam, *coverageTimeframeParam()}
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("成功", "#/definitions/SymbolData"),
+		400: *schemaResponse("不正なsymbol/timeframe", "#/definitions/ErrorResponse"),
+		404: *schemaResponse("銘柄が見つからない", "#/definitions/ErrorResponse"),
+		500: *schemaResponse("サーバーエラー", "#/definitions/ErrorResponse"),
+		503: *schemaResponse("キャッシュ準備中 (CACHE_WARMING)", "#/definitions/ErrorResponse"),
+	}}}
+	return op
+}
+
+func readyOperation() *spec.Operation {
+	op := spec.NewOperation("getReady").
+		WithSummary("API の準備状態を取得").
+		WithDescription("設定された全タイムフレームのキャッシュが読み込み済みの場合のみ200を返します。停止処理の開始後は status が draining の503を返します。").
+		WithTags("health")
+	op.Responses = &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+		200: *schemaResponse("準備完了", "#/definitions/ReadyResponse"),
+		503: *schemaResponse("キャッシュ準備中または停止処理中", "#/definitions/ReadyResponse"),
 	}}}
 	return op
 }
@@ -111,8 +617,9 @@ func apiDefinitions() spec.Definitions {
 	return spec.Definitions{
 		"RootResponse": objectSchema(map[string]spec.Schema{"message": schemaWithDescription(*spec.StringProperty(), "ルートメッセージ")}, "message"),
 		"ErrorDetail": objectSchema(map[string]spec.Schema{
-			"code":    schemaWithDescription(*spec.StringProperty(), "エラーコード"),
-			"message": schemaWithDescription(*spec.StringProperty(), "エラーメッセージ"),
+			"code":       schemaWithDescription(*spec.StringProperty(), "エラーコード"),
+			"message":    schemaWithDescription(*spec.StringProperty(), "エラーメッセージ"),
+			"request_id": schemaWithDescription(*spec.StringProperty(), "リクエストID (X-Request-ID レスポンスヘッダーと同じ値)"),
 		}, "code", "message"),
 		"ErrorResponse": objectSchema(map[string]spec.Schema{"error": schemaWithDescription(*spec.RefSchema("#/definitions/ErrorDetail"), "エラー詳細")}, "error"),
 		"PriceInfo": objectSchema(map[string]spec.Schema{
@@ -123,14 +630,34 @@ func apiDefinitions() spec.Definitions {
 			"pct":       schemaWithDescription(*spec.Float64Property(), "価格変動率 (%)"),
 			"direction": schemaWithDescription(*spec.StringProperty(), "変動方向"),
 		}, "pct", "direction"),
+		"VolatilityMeasure": objectSchema(map[string]spec.Schema{
+			"metric":     schemaWithDescription(*spec.StringProperty(), "指標名"),
+			"value":      schemaWithDescription(*spec.Float64Property(), "指標値 (%)"),
+			"bars":       schemaWithDescription(*spec.Int64Property(), "計算に使った本数"),
+			"annualized": schemaWithDescription(*spec.BoolProperty(), "年率換算済みかどうか"),
+		}, "metric", "value", "bars", "annualized"),
 		"VolatilityData": objectSchema(map[string]spec.Schema{
-			"symbol":    schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
-			"timeframe": schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
-			"candle_ts": schemaWithDescription(*spec.Int64Property(), "ローソク足の開始タイムスタンプ (ミリ秒)"),
-			"price":     schemaWithDescription(*spec.RefSchema("#/definitions/PriceInfo"), "価格情報"),
-			"change":    schemaWithDescription(*spec.RefSchema("#/definitions/ChangeInfo"), "変動情報"),
+			"symbol":     schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"timeframe":  schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"candle_ts":  schemaWithDescription(*spec.Int64Property(), "ローソク足の開始タイムスタンプ (ミリ秒)"),
+			"price":      schemaWithDescription(*spec.RefSchema("#/definitions/PriceInfo"), "価格情報"),
+			"change":     schemaWithDescription(*spec.RefSchema("#/definitions/ChangeInfo"), "変動情報"),
+			"volatility": schemaWithDescription(*spec.RefSchema("#/definitions/VolatilityMeasure"), "metric が change 以外の場合の指標値"),
 		}, "symbol", "timeframe", "candle_ts", "price", "change"),
+		"ResponseMeta": objectSchema(map[string]spec.Schema{
+			"total":      schemaWithDescription(*spec.Int64Property(), "limit 適用前の該当件数"),
+			"evaluated":  schemaWithDescription(*spec.Int64Property(), "履歴が足りて評価した銘柄数"),
+			"skipped":    schemaWithDescription(*spec.Int64Property(), "履歴不足で評価しなかった銘柄数"),
+			"params":     schemaWithDescription(*spec.MapProperty(nil), "デフォルト値を補った実際のパラメータ"),
+			"timeframes": schemaWithDescription(*spec.MapProperty(spec.RefSchema("#/definitions/TimeframeMeta")), "タイムフレーム別のスナップショット情報"),
+		}, "total", "evaluated", "skipped", "params", "timeframes"),
+		"TimeframeMeta": objectSchema(map[string]spec.Schema{
+			"refreshed_at": schemaWithDescription(*spec.StringProperty(), "キャッシュの最終読み込み時刻 (RFC3339)"),
+			"newest_ts":    schemaWithDescription(*spec.Int64Property(), "計算に使った最新のローソク足の開始タイムスタンプ (ミリ秒)"),
+		}, "newest_ts"),
 		"VolatilityResponse": objectSchema(map[string]spec.Schema{
+			"meta":  schemaWithDescription(*spec.RefSchema("#/definitions/ResponseMeta"), "集計の内訳と正規化したパラメータ"),
+			"as_of": schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
 			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
 			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/VolatilityData")), "変動率データ"),
 		}, "count", "data"),
@@ -140,8 +667,248 @@ func apiDefinitions() spec.Definitions {
 			"total_turnover": schemaWithDescription(*spec.Float64Property(), "合計売買代金"),
 			"timeframe":      schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
 			"period":         schemaWithDescription(*spec.StringProperty(), "集計期間"),
+			"comparison":     schemaWithDescription(*spec.RefSchema("#/definitions/VolumeComparison"), "compare=previous の場合の直前期間との比較"),
 		}, "symbol", "total_volume", "total_turnover", "timeframe", "period"),
+		"VolumeComparison": objectSchema(map[string]spec.Schema{
+			"previous_volume":     schemaWithDescription(*spec.Float64Property(), "直前期間の合計出来高"),
+			"previous_turnover":   schemaWithDescription(*spec.Float64Property(), "直前期間の合計売買代金"),
+			"volume_change":       schemaWithDescription(*spec.Float64Property(), "出来高の増減"),
+			"volume_change_pct":   schemaWithDescription(*spec.Float64Property(), "出来高の増減率 (%)。直前期間が0の場合は null"),
+			"turnover_change":     schemaWithDescription(*spec.Float64Property(), "売買代金の増減"),
+			"turnover_change_pct": schemaWithDescription(*spec.Float64Property(), "売買代金の増減率 (%)。直前期間が0の場合は null"),
+			"rank_by":             schemaWithDescription(*spec.StringProperty(), "順位の基準 (volume/turnover)"),
+			"rank":                schemaWithDescription(*spec.Int64Property(), "現在期間の順位 (1が最大)"),
+			"previous_rank":       schemaWithDescription(*spec.Int64Property(), "直前期間の順位。直前期間のデータがない場合は null"),
+			"rank_change":         schemaWithDescription(*spec.Int64Property(), "順位の変化 (正の値は順位上昇)"),
+			"previous_coverage":   schemaWithDescription(*spec.Float64Property(), "直前期間に存在したローソク足の割合 (0..1)"),
+			"previous_partial":    schemaWithDescription(*spec.BoolProperty(), "直前期間が履歴に一部しか含まれていない場合 true"),
+		}, "previous_volume", "previous_turnover", "volume_change", "volume_change_pct", "turnover_change", "turnover_change_pct", "rank_by", "rank", "previous_rank", "rank_change", "previous_coverage", "previous_partial"),
+		"SpikeRecent": objectSchema(map[string]spec.Schema{
+			"total":    schemaWithDescription(*spec.Float64Property(), "直近 window 本の合計 (target)"),
+			"per_bar":  schemaWithDescription(*spec.Float64Property(), "直近 window 本の1本あたり平均 (target)"),
+			"bars":     schemaWithDescription(*spec.Int64Property(), "直近の本数"),
+			"turnover": schemaWithDescription(*spec.Float64Property(), "直近 window 本の合計売買代金"),
+		}, "total", "per_bar", "bars", "turnover"),
+		"SpikeBaseline": objectSchema(map[string]spec.Schema{
+			"mean":   schemaWithDescription(*spec.Float64Property(), "基準期間の1本あたり平均"),
+			"median": schemaWithDescription(*spec.Float64Property(), "基準期間の中央値"),
+			"stdev":  schemaWithDescription(*spec.Float64Property(), "基準期間の標準偏差"),
+			"bars":   schemaWithDescription(*spec.Int64Property(), "基準期間の本数"),
+		}, "mean", "median", "stdev", "bars"),
+		"VolumeSpikeData": objectSchema(map[string]spec.Schema{
+			"symbol":    schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"timeframe": schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"candle_ts": schemaWithDescription(*spec.Int64Property(), "比較した最新の確定ローソク足の開始タイムスタンプ (ミリ秒)"),
+			"target":    schemaWithDescription(*spec.StringProperty(), "比較対象 (volume/turnover)"),
+			"method":    schemaWithDescription(*spec.StringProperty(), "スコアの算出方法"),
+			"recent":    schemaWithDescription(*spec.RefSchema("#/definitions/SpikeRecent"), "直近期間の統計"),
+			"baseline":  schemaWithDescription(*spec.RefSchema("#/definitions/SpikeBaseline"), "基準期間の統計"),
+			"ratio":     schemaWithDescription(*spec.Float64Property(), "基準 (method=median の場合は中央値、それ以外は平均) に対する倍率"),
+			"zscore":    schemaWithDescription(*spec.Float64Property(), "zスコア。基準の標準偏差が0の場合は null"),
+			"score":     schemaWithDescription(*spec.Float64Property(), "閾値判定とソートに使う値"),
+		}, "symbol", "timeframe", "candle_ts", "target", "method", "recent", "baseline", "ratio", "zscore", "score"),
+		"VolumeSpikesResponse": objectSchema(map[string]spec.Schema{
+			"meta":  schemaWithDescription(*spec.RefSchema("#/definitions/ResponseMeta"), "集計の内訳と正規化したパラメータ"),
+			"as_of": schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
+			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/VolumeSpikeData")), "出来高急増データ"),
+		}, "count", "data"),
+		"CorrelationData": objectSchema(map[string]spec.Schema{
+			"symbol":           schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"benchmark":        schemaWithDescription(*spec.StringProperty(), "ベンチマーク銘柄"),
+			"timeframe":        schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"window":           schemaWithDescription(*spec.Int64Property(), "要求したリターンの本数"),
+			"observations":     schemaWithDescription(*spec.Int64Property(), "両銘柄にデータがあり計算に使ったリターンの本数"),
+			"correlation":      schemaWithDescription(*spec.Float64Property(), "相関係数"),
+			"beta":             schemaWithDescription(*spec.Float64Property(), "ベンチマークに対するベータ"),
+			"residual_vol_pct": schemaWithDescription(*spec.Float64Property(), "ベータで説明できない残差の標準偏差 (%/本)"),
+		}, "symbol", "benchmark", "timeframe", "window", "observations", "correlation", "beta", "residual_vol_pct"),
+		"CorrelationResponse": objectSchema(map[string]spec.Schema{
+			"meta":  schemaWithDescription(*spec.RefSchema("#/definitions/ResponseMeta"), "集計の内訳と正規化したパラメータ"),
+			"as_of": schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
+			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/CorrelationData")), "相関データ"),
+		}, "count", "data"),
+		"CorrelationMatrixResponse": objectSchema(map[string]spec.Schema{
+			"as_of":        schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
+			"timeframe":    schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"window":       schemaWithDescription(*spec.Int64Property(), "要求したリターンの本数"),
+			"symbols":      schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "行・列の銘柄順"),
+			"matrix":       schemaWithDescription(*spec.ArrayProperty(spec.ArrayProperty(spec.Float64Property())), "相関係数の行列。重なり不足のペアは null"),
+			"observations": schemaWithDescription(*spec.ArrayProperty(spec.ArrayProperty(spec.Int64Property())), "各ペアの計算に使ったリターンの本数"),
+		}, "timeframe", "window", "symbols", "matrix", "observations"),
+		"IndicatorRow": objectSchema(map[string]spec.Schema{
+			"ts":     schemaWithDescription(*spec.Int64Property(), "ローソク足の開始タイムスタンプ (ミリ秒)"),
+			"close":  schemaWithDescription(*spec.Float64Property(), "終値"),
+			"values": schemaWithDescription(*spec.MapProperty(spec.Float64Property()), "列名ごとの指標値。計算に必要な本数に満たない場合は null"),
+		}, "ts", "close", "values"),
+		"BreadthResponse": objectSchema(map[string]spec.Schema{
+			"timeframe":                    schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"offset":                       schemaWithDescription(*spec.Int64Property(), "比較した本数"),
+			"lookback":                     schemaWithDescription(*spec.Int64Property(), "高値・安値の判定に使った本数"),
+			"as_of":                        schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
+			"symbols":                      schemaWithDescription(*spec.Int64Property(), "変化率を計算できた銘柄数"),
+			"up":                           schemaWithDescription(*spec.Int64Property(), "上昇銘柄数"),
+			"down":                         schemaWithDescription(*spec.Int64Property(), "下落銘柄数"),
+			"flat":                         schemaWithDescription(*spec.Int64Property(), "横ばい銘柄数"),
+			"advance_decline_ratio":        schemaWithDescription(*spec.Float64Property(), "上昇銘柄数 / 下落銘柄数。下落が0の場合は null"),
+			"mean_change_pct":              schemaWithDescription(*spec.Float64Property(), "変化率の平均 (%)"),
+			"median_change_pct":            schemaWithDescription(*spec.Float64Property(), "変化率の中央値 (%)"),
+			"turnover_weighted_change_pct": schemaWithDescription(*spec.Float64Property(), "offset 本の売買代金で加重した変化率の平均 (%)"),
+			"histogram":                    schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/BreadthBucket")), "変化率のヒストグラム"),
+			"above_high":                   schemaWithDescription(*spec.RefSchema("#/definitions/BreadthShare"), "最新終値が直前 lookback 本の高値を上回る銘柄"),
+			"below_low":                    schemaWithDescription(*spec.RefSchema("#/definitions/BreadthShare"), "最新終値が直前 lookback 本の安値を下回る銘柄"),
+			"meta":                         schemaWithDescription(*spec.RefSchema("#/definitions/ResponseMeta"), "集計の内訳と正規化したパラメータ (total は symbols と同じ)"),
+		}, "timeframe", "offset", "lookback", "symbols", "up", "down", "flat", "advance_decline_ratio", "mean_change_pct", "median_change_pct", "turnover_weighted_change_pct", "histogram", "above_high", "below_low"),
+		"BreadthBucket": objectSchema(map[string]spec.Schema{
+			"min":   schemaWithDescription(*spec.Float64Property(), "下限 (%、含む)。null は下限なし"),
+			"max":   schemaWithDescription(*spec.Float64Property(), "上限 (%、含まない)。null は上限なし"),
+			"count": schemaWithDescription(*spec.Int64Property(), "銘柄数"),
+		}, "min", "max", "count"),
+		"BreadthShare": objectSchema(map[string]spec.Schema{
+			"count":     schemaWithDescription(*spec.Int64Property(), "該当銘柄数"),
+			"evaluated": schemaWithDescription(*spec.Int64Property(), "lookback+1 本以上の履歴がある銘柄数"),
+			"pct":       schemaWithDescription(*spec.Float64Property(), "該当銘柄の割合 (%)。evaluated が0の場合は null"),
+		}, "count", "evaluated", "pct"),
+		"IndicatorsResponse": objectSchema(map[string]spec.Schema{
+			"as_of":     schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
+			"symbol":    schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"timeframe": schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"columns":   schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "values の列名 (例: rsi:14, macd:12:26:9.signal)"),
+			"count":     schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":      schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/IndicatorRow")), "古い順の指標データ"),
+		}, "symbol", "timeframe", "columns", "count", "data"),
+		"ScreenRequest": objectSchema(map[string]spec.Schema{
+			"filter":  schemaWithDescription(*spec.StringProperty(), "真偽値を返す条件式"),
+			"columns": schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "出力する数値式 (最大20個)"),
+			"sort":    schemaWithDescription(*spec.StringProperty(), "ソートに使う数値式。省略時は銘柄名順"),
+			"order":   schemaWithDescription(*spec.StringProperty().WithEnum("desc", "asc"), "ソート順。デフォルト: desc"),
+			"limit":   schemaWithDescription(*spec.Int64Property(), "取得する最大件数 (1..500)。デフォルト: 100"),
+		}, "filter"),
+		"ScreenItem": objectSchema(map[string]spec.Schema{
+			"symbol": schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"values": schemaWithDescription(*spec.MapProperty(spec.Float64Property()), "columns の各式の値。データ不足の場合は null"),
+		}, "symbol", "values"),
+		"ScreenResponse": objectSchema(map[string]spec.Schema{
+			"as_of":     schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
+			"count":     schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"matched":   schemaWithDescription(*spec.Int64Property(), "limit 適用前の一致件数"),
+			"evaluated": schemaWithDescription(*spec.Int64Property(), "評価した銘柄数"),
+			"cost":      schemaWithDescription(*spec.Int64Property(), "評価コスト (読み込んだローソク足の本数 × 銘柄数)"),
+			"columns":   schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "values の列名"),
+			"data":      schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/ScreenItem")), "一致した銘柄"),
+		}, "count", "matched", "evaluated", "cost", "columns", "data"),
+		"VolatilityStreamDiff": objectSchema(map[string]spec.Schema{
+			"seq":       schemaWithDescription(*spec.Int64Property(), "イベント番号 (SSE の id と同じ)"),
+			"timeframe": schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"entered":   schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/VolatilityData")), "新たにランキングに入った銘柄"),
+			"changed":   schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/VolatilityData")), "値が変わった銘柄"),
+			"left":      schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "ランキングから外れた銘柄"),
+			"order":     schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "更新後のランキング順の全銘柄"),
+		}, "seq", "timeframe", "entered", "changed", "left", "order"),
+		"AlertRuleInput": objectSchema(map[string]spec.Schema{
+			"name":             schemaWithDescription(*spec.StringProperty(), "ルール名 (1〜100文字)"),
+			"metric":           schemaWithDescription(*spec.StringProperty().WithEnum(toAnySlice(volatilityMetrics)...), "評価する指標。デフォルト: change"),
+			"timeframe":        schemaWithDescription(*spec.StringProperty().WithEnum(toAnySlice(validTimeframes)...), "タイムフレーム"),
+			"bars":             schemaWithDescription(*spec.Int64Property(), "change は何本前と比較するか、それ以外は計算に使う本数。デフォルト: 1"),
+			"threshold":        schemaWithDescription(*spec.Float64Property(), "閾値 (%)。0より大きい値"),
+			"direction":        schemaWithDescription(*spec.StringProperty().WithEnum("up", "down", "both"), "発火する方向。change 以外は up のみ。デフォルト: up"),
+			"symbols":          schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "対象銘柄 (最大200)。省略時は全銘柄"),
+			"cooldown_seconds": schemaWithDescription(*spec.Int64Property(), "同じ銘柄で再発火しない秒数 (0〜604800)。デフォルト: 3600"),
+			"webhook_url":      schemaWithDescription(*spec.StringProperty(), "通知先の http(s) URL。ループバック・リンクローカル・プライベートアドレスは指定できません (ALERT_WEBHOOK_ALLOWED_HOSTS 設定時はそのホストのみ)"),
+			"webhook_format":   schemaWithDescription(*spec.StringProperty().WithEnum(toAnySlice(webhookFormats)...), "ペイロード形式。デフォルト: generic"),
+			"enabled":          schemaWithDescription(*spec.BoolProperty(), "評価するかどうか。デフォルト: true"),
+		}, "name", "timeframe", "threshold", "webhook_url"),
+		"AlertRule": objectSchema(map[string]spec.Schema{
+			"id":               schemaWithDescription(*spec.Int64Property(), "ルールID"),
+			"name":             schemaWithDescription(*spec.StringProperty(), "ルール名"),
+			"metric":           schemaWithDescription(*spec.StringProperty(), "評価する指標"),
+			"timeframe":        schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"bars":             schemaWithDescription(*spec.Int64Property(), "比較・計算に使う本数"),
+			"threshold":        schemaWithDescription(*spec.Float64Property(), "閾値 (%)"),
+			"direction":        schemaWithDescription(*spec.StringProperty(), "発火する方向"),
+			"symbols":          schemaWithDescription(*spec.ArrayProperty(spec.StringProperty()), "対象銘柄。空の場合は全銘柄"),
+			"cooldown_seconds": schemaWithDescription(*spec.Int64Property(), "再発火しない秒数"),
+			"webhook_url":      schemaWithDescription(*spec.StringProperty(), "通知先URL"),
+			"webhook_format":   schemaWithDescription(*spec.StringProperty(), "ペイロード形式"),
+			"enabled":          schemaWithDescription(*spec.BoolProperty(), "評価するかどうか"),
+			"created_at":       schemaWithDescription(*spec.Int64Property(), "作成時刻 (ミリ秒)"),
+			"updated_at":       schemaWithDescription(*spec.Int64Property(), "更新時刻 (ミリ秒)"),
+		}, "id", "name", "metric", "timeframe", "bars", "threshold", "direction", "symbols", "cooldown_seconds", "webhook_url", "webhook_format", "enabled", "created_at", "updated_at"),
+		"AlertRulesResponse": objectSchema(map[string]spec.Schema{
+			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/AlertRule")), "ルール"),
+		}, "count", "data"),
+		"AlertFiring": objectSchema(map[string]spec.Schema{
+			"id":           schemaWithDescription(*spec.Int64Property(), "発火ID"),
+			"rule_id":      schemaWithDescription(*spec.Int64Property(), "ルールID"),
+			"symbol":       schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"value":        schemaWithDescription(*spec.Float64Property(), "発火時の指標値 (%)"),
+			"candle_ts":    schemaWithDescription(*spec.Int64Property(), "評価したローソク足の開始タイムスタンプ (ミリ秒)"),
+			"fired_at":     schemaWithDescription(*spec.Int64Property(), "発火時刻 (ミリ秒)"),
+			"status":       schemaWithDescription(*spec.StringProperty().WithEnum(alertStatusPending, alertStatusDelivered, alertStatusFailed), "配信状態"),
+			"attempts":     schemaWithDescription(*spec.Int64Property(), "配信の試行回数"),
+			"last_error":   schemaWithDescription(*spec.StringProperty(), "最後の配信エラー (ある場合)"),
+			"delivered_at": schemaWithDescription(*spec.Int64Property(), "配信成功時刻 (ミリ秒)。未配信の場合は null"),
+		}, "id", "rule_id", "symbol", "value", "candle_ts", "fired_at", "status", "attempts", "delivered_at"),
+		"AlertFiringsResponse": objectSchema(map[string]spec.Schema{
+			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/AlertFiring")), "発火履歴 (新しい順)"),
+		}, "count", "data"),
+		"CandleData": objectSchema(map[string]spec.Schema{
+			"ts":       schemaWithDescription(*spec.Int64Property(), "ローソク足の開始タイムスタンプ (ミリ秒)"),
+			"open":     schemaWithDescription(*spec.Float64Property(), "始値"),
+			"high":     schemaWithDescription(*spec.Float64Property(), "高値"),
+			"low":      schemaWithDescription(*spec.Float64Property(), "安値"),
+			"close":    schemaWithDescription(*spec.Float64Property(), "終値"),
+			"volume":   schemaWithDescription(*spec.Float64Property(), "出来高"),
+			"turnover": schemaWithDescription(*spec.Float64Property(), "売買代金"),
+		}, "ts", "open", "high", "low", "close", "volume", "turnover"),
+		"CandlesResponse": objectSchema(map[string]spec.Schema{
+			"symbol":      schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"timeframe":   schemaWithDescription(*spec.StringProperty(), "タイムフレーム"),
+			"count":       schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":        schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/CandleData")), "ローソク足データ"),
+			"next_cursor": schemaWithDescription(*spec.StringProperty(), "次ページ取得用カーソル。最終ページでは省略"),
+		}, "symbol", "timeframe", "count", "data"),
+		"InstrumentInfo": objectSchema(map[string]spec.Schema{
+			"contract_type": schemaWithDescription(*spec.StringProperty(), "契約種別"),
+			"status":        schemaWithDescription(*spec.StringProperty(), "取引ステータス"),
+			"base_coin":     schemaWithDescription(*spec.StringProperty(), "ベース通貨"),
+			"quote_coin":    schemaWithDescription(*spec.StringProperty(), "クォート通貨"),
+			"launch_time":   schemaWithDescription(*spec.Int64Property(), "上場時刻 (ミリ秒)"),
+			"tick_size":     schemaWithDescription(*spec.Float64Property(), "呼値の単位"),
+			"qty_step":      schemaWithDescription(*spec.Float64Property(), "数量の刻み"),
+			"min_order_qty": schemaWithDescription(*spec.Float64Property(), "最小注文数量"),
+		}, "contract_type", "status", "base_coin", "quote_coin", "launch_time", "tick_size", "qty_step", "min_order_qty"),
+		"SymbolCoverage": objectSchema(map[string]spec.Schema{
+			"candles":            schemaWithDescription(*spec.Int64Property(), "保持しているローソク足の本数"),
+			"oldest_ts":          schemaWithDescription(*spec.Int64Property(), "最古のローソク足の開始タイムスタンプ (ミリ秒)"),
+			"newest_ts":          schemaWithDescription(*spec.Int64Property(), "最新のローソク足の開始タイムスタンプ (ミリ秒)"),
+			"newest_age_seconds": schemaWithDescription(*spec.Int64Property(), "最新のローソク足の経過秒数"),
+			"missing_bars":       schemaWithDescription(*spec.Int64Property(), "最古〜最新の間で欠けている本数"),
+			"stale":              schemaWithDescription(*spec.BoolProperty(), "最新足が2本分以上古い場合 true"),
+		}, "candles", "oldest_ts", "newest_ts", "newest_age_seconds", "missing_bars", "stale"),
+		"SymbolData": objectSchema(map[string]spec.Schema{
+			"symbol":     schemaWithDescription(*spec.StringProperty(), "銘柄シンボル"),
+			"instrument": schemaWithDescription(*spec.RefSchema("#/definitions/InstrumentInfo"), "銘柄情報 (取得済みの場合)"),
+			"timeframes": schemaWithDescription(*spec.MapProperty(spec.RefSchema("#/definitions/SymbolCoverage")), "タイムフレーム別のカバレッジ"),
+		}, "symbol", "timeframes"),
+		"SymbolsResponse": objectSchema(map[string]spec.Schema{
+			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
+			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/SymbolData")), "銘柄データ"),
+		}, "count", "data"),
+		"TimeframeReady": objectSchema(map[string]spec.Schema{
+			"loaded":       schemaWithDescription(*spec.BoolProperty(), "読み込み済みかどうか"),
+			"refreshed_at": schemaWithDescription(*spec.StringProperty(), "最終読み込み時刻 (RFC3339)"),
+		}, "loaded"),
+		"ReadyResponse": objectSchema(map[string]spec.Schema{
+			"status":     schemaWithDescription(*spec.StringProperty(), "ready / warming / draining (停止処理中)"),
+			"timeframes": schemaWithDescription(*spec.MapProperty(spec.RefSchema("#/definitions/TimeframeReady")), "タイムフレーム別の状態"),
+		}, "status", "timeframes"),
 		"VolumeResponse": objectSchema(map[string]spec.Schema{
+			"meta":  schemaWithDescription(*spec.RefSchema("#/definitions/ResponseMeta"), "集計の内訳と正規化したパラメータ"),
+			"as_of": schemaWithDescription(*spec.Int64Property(), "as_of を指定した場合の評価時刻 (ミリ秒)"),
 			"count": schemaWithDescription(*spec.Int64Property(), "返却件数"),
 			"data":  schemaWithDescription(*spec.ArrayProperty(spec.RefSchema("#/definitions/VolumeData")), "出来高データ"),
 		}, "count", "data"),
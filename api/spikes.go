@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var spikeMethods = []string{"mean", "median", "zscore"}
+
+type volumeSpikeParams struct {
+	timeframe   string
+	window      int
+	lookback    int
+	target      string
+	method      string
+	threshold   float64
+	direction   string
+	minTurnover float64
+	sort        string
+	limit       int
+	asOf        int64
+}
+
+// volumeSpikeStats compares the per-bar average of the newest window closed
+// bars with the lookback bars immediately before them. The still-forming bar
+// is left out: its partial volume would read as a drop every new interval.
+type volumeSpikeStats struct {
+	recentTotal    float64
+	recentPerBar   float64
+	recentTurnover float64
+	mean           float64
+	median         float64
+	stdev          float64
+}
+
+func (s *apiServer) volumeSpikesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	params, perr := parseVolumeSpikeParams(r.URL.Query(), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+
+	snapshot, err := s.marketCache.getSnapshot(r.Context(), params.timeframe)
+	if err != nil {
+		s.writeQueryError(w, r, err, "volume spikes query error", "timeframe", params.timeframe)
+		return
+	}
+	if s.notModified(w, r, snapshot, params) {
+		return
+	}
+	snapshot, err = s.snapshotAt(r.Context(), snapshot, params.timeframe, params.asOf, params.window+params.lookback+1, nil)
+	if err != nil {
+		s.writeQueryError(w, r, err, "volume spikes query error", "timeframe", params.timeframe)
+		return
+	}
+
+	_, span := tracer.Start(r.Context(), "queryVolumeSpikes")
+	items, stats, queryErr := queryVolumeSpikes(snapshot, params)
+	span.End()
+	if queryErr != nil {
+		s.writeQueryError(w, r, queryErr, "volume spikes query error", "timeframe", params.timeframe)
+		return
+	}
+
+	writeData(w, r, http.StatusOK, volumeSpikesResponse{
+		Count: len(items),
+		AsOf:  params.asOf,
+		Data:  items,
+		Meta:  newResponseMeta(stats, params.echo(), map[string]marketSnapshot{params.timeframe: snapshot}),
+	})
+}
+
+func parseVolumeSpikeParams(q url.Values, historyLimit int) (volumeSpikeParams, *paramError) {
+	p := volumeSpikeParams{window: 1, lookback: 20, target: "turnover", method: "mean", direction: "up", sort: "spike_desc", limit: 100}
+
+	p.timeframe = strings.TrimSpace(q.Get("timeframe"))
+	if !contains(validTimeframes, p.timeframe) {
+		return p, &paramError{http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", "))}
+	}
+
+	var err error
+	if raw := strings.TrimSpace(q.Get("window")); raw != "" {
+		p.window, err = strconv.Atoi(raw)
+		if err != nil || p.window <= 0 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "window は1以上の整数を指定してください"}
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("lookback")); raw != "" {
+		p.lookback, err = strconv.Atoi(raw)
+		if err != nil || p.lookback < 2 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "lookback は2以上の整数を指定してください"}
+		}
+	}
+	if required := p.window + p.lookback + 1; required > historyLimit {
+		msg := fmt.Sprintf("window (%d本) と lookback (%d本) に形成中の1本を加えた本数が、現在利用可能な履歴の最大本数(%d本)を超えています。", p.window, p.lookback, historyLimit)
+		return p, &paramError{http.StatusBadRequest, "INSUFFICIENT_HISTORY", msg}
+	}
+
+	if raw := strings.TrimSpace(q.Get("target")); raw != "" {
+		p.target = raw
+	}
+	if p.target != "volume" && p.target != "turnover" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "target は volume/turnover のいずれかを指定してください"}
+	}
+
+	if raw := strings.TrimSpace(q.Get("method")); raw != "" {
+		p.method = raw
+	}
+	if !contains(spikeMethods, p.method) {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "method は mean/median/zscore のいずれかを指定してください"}
+	}
+
+	if raw := strings.TrimSpace(q.Get("threshold")); raw != "" {
+		p.threshold, err = parsePositiveFloat(raw)
+		if err != nil {
+			return p, &paramError{http.StatusBadRequest, "INVALID_INPUT", "threshold は0より大きい有限の数値を指定してください"}
+		}
+		// Ratio thresholds at or below 1 make "up" and "down" overlap.
+		if p.method != "zscore" && p.threshold <= 1 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "method が mean/median の場合、threshold は1より大きい値を指定してください"}
+		}
+	}
+
+	if raw := strings.TrimSpace(q.Get("direction")); raw != "" {
+		p.direction = raw
+	}
+	if p.direction != "up" && p.direction != "down" && p.direction != "both" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "direction は up/down/both のいずれかを指定してください"}
+	}
+
+	if raw := strings.TrimSpace(q.Get("min_turnover")); raw != "" {
+		p.minTurnover, err = parsePositiveFloat(raw)
+		if err != nil {
+			return p, &paramError{http.StatusBadRequest, "INVALID_INPUT", "min_turnover は0より大きい有限の数値を指定してください"}
+		}
+	}
+
+	if raw := strings.TrimSpace(q.Get("sort")); raw != "" {
+		p.sort = raw
+	}
+	if p.sort != "spike_desc" && p.sort != "spike_asc" && p.sort != "symbol_asc" {
+		return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "sort は spike_desc/spike_asc/symbol_asc のいずれかを指定してください"}
+	}
+
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		p.limit, err = strconv.Atoi(raw)
+		if err != nil || p.limit <= 0 || p.limit > 500 {
+			return p, &paramError{http.StatusUnprocessableEntity, "INVALID_INPUT", "limit は1以上500以下の整数を指定してください"}
+		}
+	}
+
+	asOf, perr := parseAsOf(q, time.Now())
+	if perr != nil {
+		return p, perr
+	}
+	p.asOf = asOf
+
+	return p, nil
+}
+
+func queryVolumeSpikes(snapshot marketSnapshot, p volumeSpikeParams) ([]volumeSpikeItem, rankStats, error) {
+	var counts rankStats
+	items := make([]volumeSpikeItem, 0, len(snapshot.seriesBySymbol))
+	for symbol, candles := range snapshot.seriesBySymbol {
+		stats, ok := computeVolumeSpike(candles, p.window, p.lookback, p.target)
+		if !ok {
+			counts.skipped++
+			continue
+		}
+		counts.evaluated++
+		if p.minTurnover > 0 && stats.recentTurnover < p.minTurnover {
+			continue
+		}
+
+		baseline := stats.mean
+		if p.method == "median" {
+			baseline = stats.median
+		}
+		if baseline <= 0 {
+			continue
+		}
+		ratio := stats.recentPerBar / baseline
+
+		var zscore *float64
+		if stats.stdev > 0 {
+			z := round4((stats.recentPerBar - stats.mean) / stats.stdev)
+			zscore = &z
+		}
+		if p.method == "zscore" && zscore == nil {
+			continue
+		}
+
+		score := ratio
+		if p.method == "zscore" {
+			score = *zscore
+		}
+		if !spikeMatchesDirection(p.method, score, p.threshold, p.direction) {
+			continue
+		}
+
+		item := volumeSpikeItem{
+			Symbol:    symbol,
+			Timeframe: p.timeframe,
+			CandleTS:  candles[1].TS,
+			Target:    p.target,
+			Method:    p.method,
+			Ratio:     round4(ratio),
+			ZScore:    zscore,
+			Score:     round4(score),
+		}
+		item.Recent.Total = round4(stats.recentTotal)
+		item.Recent.PerBar = round4(stats.recentPerBar)
+		item.Recent.Bars = p.window
+		item.Recent.Turnover = round4(stats.recentTurnover)
+		item.Baseline.Mean = round4(stats.mean)
+		item.Baseline.Median = round4(stats.median)
+		item.Baseline.Stdev = round4(stats.stdev)
+		item.Baseline.Bars = p.lookback
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		switch p.sort {
+		case "spike_asc":
+			if items[i].Score == items[j].Score {
+				return items[i].Symbol < items[j].Symbol
+			}
+			return items[i].Score < items[j].Score
+		case "symbol_asc":
+			return items[i].Symbol < items[j].Symbol
+		default:
+			if items[i].Score == items[j].Score {
+				return items[i].Symbol < items[j].Symbol
+			}
+			return items[i].Score > items[j].Score
+		}
+	})
+
+	counts.matched = len(items)
+	if p.limit < len(items) {
+		items = items[:p.limit]
+	}
+	return items, counts, nil
+}
+
+// spikeMatchesDirection applies direction and the optional threshold to a
+// score. Ratios are centred on 1 and "down" uses the reciprocal threshold;
+// z-scores are centred on 0 and "down" uses the negated threshold.
+func spikeMatchesDirection(method string, score, threshold float64, direction string) bool {
+	up, down := score > 1, score < 1
+	if threshold > 0 {
+		up, down = score >= threshold, score <= 1/threshold
+	}
+	if method == "zscore" {
+		up, down = score > 0, score < 0
+		if threshold > 0 {
+			up, down = score >= threshold, score <= -threshold
+		}
+	}
+
+	switch direction {
+	case "up":
+		return up
+	case "down":
+		return down
+	default:
+		return up || down
+	}
+}
+
+func computeVolumeSpike(candles []marketCandle, window, lookback int, target string) (volumeSpikeStats, bool) {
+	if len(candles) < window+lookback+1 {
+		return volumeSpikeStats{}, false
+	}
+	value := func(c marketCandle) float64 {
+		if target == "volume" {
+			return c.Volume
+		}
+		return c.Turnover
+	}
+
+	stats := volumeSpikeStats{}
+	closed := candles[1:]
+	for _, c := range closed[:window] {
+		stats.recentTotal += value(c)
+		stats.recentTurnover += c.Turnover
+	}
+	stats.recentPerBar = stats.recentTotal / float64(window)
+
+	baseline := make([]float64, 0, lookback)
+	for _, c := range closed[window : window+lookback] {
+		baseline = append(baseline, value(c))
+	}
+	for _, v := range baseline {
+		stats.mean += v
+	}
+	stats.mean /= float64(lookback)
+	stats.median = median(baseline)
+	stats.stdev = sampleStdev(baseline)
+	return stats, true
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
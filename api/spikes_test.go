@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"testing"
+)
+
+func spikeSeries(forming float64, closed ...float64) []marketCandle {
+	candles := []marketCandle{{TS: int64(len(closed) + 1), Turnover: forming}}
+	for i, v := range closed {
+		candles = append(candles, marketCandle{TS: int64(len(closed) - i), Turnover: v})
+	}
+	return candles
+}
+
+func TestComputeVolumeSpikeSkipsFormingBar(t *testing.T) {
+	stats, ok := computeVolumeSpike(spikeSeries(1000, 40, 10, 10, 10, 30), 1, 4, "turnover")
+	if !ok {
+		t.Fatal("ok = false")
+	}
+	if stats.recentPerBar != 40 || stats.recentTurnover != 40 || stats.mean != 15 || stats.median != 10 || stats.stdev != 10 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if _, ok := computeVolumeSpike(spikeSeries(1000, 40, 10, 10, 10), 1, 4, "turnover"); ok {
+		t.Fatal("series without a closed bar to spare was evaluated")
+	}
+}
+
+func TestQueryVolumeSpikesMethodsAndDirections(t *testing.T) {
+	snapshot := marketSnapshot{seriesBySymbol: map[string][]marketCandle{
+		// ratio 40/15 = 2.6667 (mean), 4 (median), zscore 2.5
+		"UPUSDT": spikeSeries(1000, 40, 10, 10, 10, 30),
+		// ratio 0.2, zscore -3.4641
+		"DOWNUSDT": spikeSeries(1000, 2, 8, 12, 8, 12),
+		// ratio 1, zscore 0
+		"FLATUSDT":  spikeSeries(1000, 10, 8, 12, 8, 12),
+		"SHORTUSDT": spikeSeries(1000, 10, 8),
+	}}
+
+	cases := []struct {
+		method    string
+		threshold float64
+		direction string
+		want      []string
+	}{
+		{"mean", 0, "up", []string{"UPUSDT"}},
+		{"mean", 0, "down", []string{"DOWNUSDT"}},
+		{"mean", 2, "both", []string{"UPUSDT", "DOWNUSDT"}},
+		{"mean", 3, "up", nil},
+		{"median", 3, "up", []string{"UPUSDT"}},
+		{"median", 5, "both", []string{"DOWNUSDT"}},
+		{"zscore", 2, "up", []string{"UPUSDT"}},
+		{"zscore", 1.5, "down", []string{"DOWNUSDT"}},
+		{"zscore", 0, "both", []string{"UPUSDT", "DOWNUSDT"}},
+	}
+	for _, tc := range cases {
+		p := volumeSpikeParams{timeframe: "1h", window: 1, lookback: 4, target: "turnover", method: tc.method, threshold: tc.threshold, direction: tc.direction, sort: "spike_desc", limit: 10}
+		items, stats, err := queryVolumeSpikes(snapshot, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.evaluated != 3 || stats.skipped != 1 || stats.matched != len(tc.want) {
+			t.Errorf("%s/%v/%s: stats = %+v", tc.method, tc.threshold, tc.direction, stats)
+		}
+		if len(items) != len(tc.want) {
+			t.Errorf("%s/%v/%s: %d items, want %v", tc.method, tc.threshold, tc.direction, len(items), tc.want)
+			continue
+		}
+		for i, item := range items {
+			if item.Symbol != tc.want[i] || item.CandleTS != 5 {
+				t.Errorf("%s/%v/%s: items[%d] = %s @%d, want %s", tc.method, tc.threshold, tc.direction, i, item.Symbol, item.CandleTS, tc.want[i])
+			}
+		}
+	}
+
+	items, _, _ := queryVolumeSpikes(snapshot, volumeSpikeParams{window: 1, lookback: 4, target: "turnover", method: "zscore", direction: "down", limit: 10})
+	if len(items) != 1 || math.Abs(items[0].Score+3.4641) > 1e-9 || items[0].Ratio != 0.2 {
+		t.Fatalf("down zscore item = %+v", items)
+	}
+}
+
+func TestParseVolumeSpikeParamsThreshold(t *testing.T) {
+	cases := []struct {
+		method, threshold string
+		ok                bool
+	}{
+		{"mean", "1.5", true},
+		{"mean", "1", false},
+		{"median", "0.5", false},
+		{"zscore", "0.5", true},
+		{"zscore", "0", false},
+	}
+	for _, tc := range cases {
+		_, perr := parseVolumeSpikeParams(url.Values{"timeframe": {"1h"}, "method": {tc.method}, "threshold": {tc.threshold}}, 100)
+		if (perr == nil) != tc.ok {
+			t.Errorf("method=%s threshold=%s accepted=%v, want %v", tc.method, tc.threshold, perr == nil, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+)
+
+// openStateDB opens the API's own writable database. Market data stays in the
+// fetcher's database, which the API only reads; state owned by the API
+// (alert rules, delivery history and API key usage) lives here instead.
+func openStateDB(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serialises writers and keeps the PRAGMAs below in
+	// effect for every statement.
+	db.SetMaxOpenConns(1)
+
+	pragmas := []string{
+		"PRAGMA busy_timeout = 5000",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+	}
+	for _, p := range pragmas {
+		if _, err := db.Exec(p); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := ensureStateTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func ensureStateTables(db *sql.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS alert_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			timeframe TEXT NOT NULL,
+			bars INTEGER NOT NULL,
+			threshold REAL NOT NULL,
+			direction TEXT NOT NULL,
+			symbols TEXT NOT NULL DEFAULT '',
+			cooldown_seconds INTEGER NOT NULL,
+			webhook_url TEXT NOT NULL,
+			webhook_format TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS alert_firings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			symbol TEXT NOT NULL,
+			value REAL NOT NULL,
+			candle_ts INTEGER NOT NULL,
+			fired_at INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			delivered_at INTEGER,
+			UNIQUE (rule_id, symbol, candle_ts)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_alert_firings_rule_symbol ON alert_firings (rule_id, symbol, fired_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_alert_firings_status ON alert_firings (status)`,
+		`CREATE TABLE IF NOT EXISTS api_usage (
+			key_name TEXT NOT NULL,
+			endpoint TEXT NOT NULL,
+			hour_ts INTEGER NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			rate_limited INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (key_name, endpoint, hour_ts)
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
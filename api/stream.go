@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// streamSubscriberBuffer is how many refreshes a subscriber may fall
+	// behind before it is disconnected.
+	streamSubscriberBuffer = 4
+	streamWriteTimeout     = 10 * time.Second
+)
+
+type streamSubscriber struct {
+	timeframe string
+	updates   chan marketSnapshot
+	// dropped is closed when the subscriber is disconnected for falling behind.
+	dropped chan struct{}
+}
+
+// volatilityStreams fans snapshot refreshes out to /stream/volatility
+// subscribers. publish never blocks: a subscriber whose buffer is full is
+// dropped so a slow client cannot hold up the cache refresh.
+type volatilityStreams struct {
+	mu          sync.Mutex
+	max         int
+	subscribers map[*streamSubscriber]struct{}
+	// closing is closed on shutdown. Server.Shutdown does not interrupt
+	// active handlers, so streams watch it to end themselves.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func newVolatilityStreams(max int) *volatilityStreams {
+	return &volatilityStreams{max: max, subscribers: make(map[*streamSubscriber]struct{}), closing: make(chan struct{})}
+}
+
+// close ends every open stream with a SHUTTING_DOWN event.
+func (h *volatilityStreams) close() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+func (h *volatilityStreams) subscribe(timeframe string) (*streamSubscriber, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers) >= h.max {
+		return nil, false
+	}
+	sub := &streamSubscriber{
+		timeframe: timeframe,
+		updates:   make(chan marketSnapshot, streamSubscriberBuffer),
+		dropped:   make(chan struct{}),
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub, true
+}
+
+func (h *volatilityStreams) unsubscribe(sub *streamSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+func (h *volatilityStreams) publish(timeframe string, snapshot marketSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if sub.timeframe != timeframe {
+			continue
+		}
+		select {
+		case sub.updates <- snapshot:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+func (s *apiServer) volatilityStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	params, perr := parseVolatilityParams(r.URL.Query(), s.ohlcvHistoryLimit)
+	if perr != nil {
+		writeError(w, perr.status, perr.code, perr.message)
+		return
+	}
+	if params.asOf != 0 {
+		writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "as_of はストリームでは指定できません")
+		return
+	}
+
+	// Subscribe before reading the snapshot so a refresh in between is
+	// delivered as a diff rather than lost.
+	sub, ok := s.streams.subscribe(params.timeframe)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfterSeconds))
+		writeError(w, http.StatusServiceUnavailable, "TOO_MANY_SUBSCRIBERS", "ストリームの同時接続数が上限に達しています。しばらくしてから再接続してください")
+		return
+	}
+	defer s.streams.unsubscribe(sub)
+
+	items, err := s.queryVolatility(r.Context(), params)
+	if err != nil {
+		s.writeQueryError(w, r, err, "volatility stream query error", "timeframe", params.timeframe)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	seq := 1
+	if err := writeStreamEvent(w, rc, seq, "snapshot", volatilityResponse{Count: len(items), Data: items}); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.closing:
+			var resp errorResponse
+			resp.Error.Code = "SHUTTING_DOWN"
+			resp.Error.Message = "サーバーを停止するため切断しました。再接続してください"
+			resp.Error.RequestID = w.Header().Get("X-Request-ID")
+			writeStreamEvent(w, rc, seq+1, "error", resp)
+			return
+		case <-sub.dropped:
+			s.logger.WarnContext(r.Context(), "volatility stream subscriber dropped for falling behind", "timeframe", params.timeframe)
+			var resp errorResponse
+			resp.Error.Code = "SLOW_CONSUMER"
+			resp.Error.Message = "受信が追いつかないため切断しました。再接続してください"
+			resp.Error.RequestID = w.Header().Get("X-Request-ID")
+			writeStreamEvent(w, rc, seq+1, "error", resp)
+			return
+		case <-heartbeat.C:
+			if err := writeStreamComment(w, rc, "heartbeat"); err != nil {
+				return
+			}
+		case snapshot := <-sub.updates:
+			next, _, err := rankVolatility(snapshot, params)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "volatility stream rank error", "timeframe", params.timeframe, "err", err)
+				return
+			}
+			diff, changed := diffVolatility(items, next)
+			items = next
+			if !changed {
+				continue
+			}
+			seq++
+			diff.Seq = seq
+			diff.Timeframe = params.timeframe
+			if err := writeStreamEvent(w, rc, seq, "diff", diff); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// diffVolatility describes how a ranking changed between two refreshes.
+// changed is false when the rankings are identical.
+func diffVolatility(prev, next []volatilityItem) (volatilityStreamDiff, bool) {
+	diff := volatilityStreamDiff{
+		Entered: []volatilityItem{},
+		Changed: []volatilityItem{},
+		Left:    []string{},
+		Order:   make([]string, 0, len(next)),
+	}
+	prevBySymbol := make(map[string]volatilityItem, len(prev))
+	prevOrder := make([]string, 0, len(prev))
+	for _, item := range prev {
+		prevBySymbol[item.Symbol] = item
+		prevOrder = append(prevOrder, item.Symbol)
+	}
+
+	seen := make(map[string]bool, len(next))
+	for _, item := range next {
+		seen[item.Symbol] = true
+		diff.Order = append(diff.Order, item.Symbol)
+		old, ok := prevBySymbol[item.Symbol]
+		switch {
+		case !ok:
+			diff.Entered = append(diff.Entered, item)
+		case !sameVolatilityItem(old, item):
+			diff.Changed = append(diff.Changed, item)
+		}
+	}
+	for _, item := range prev {
+		if !seen[item.Symbol] {
+			diff.Left = append(diff.Left, item.Symbol)
+		}
+	}
+
+	changed := len(diff.Entered) > 0 || len(diff.Changed) > 0 || len(diff.Left) > 0 || !slices.Equal(prevOrder, diff.Order)
+	return diff, changed
+}
+
+func sameVolatilityItem(a, b volatilityItem) bool {
+	if a.CandleTS != b.CandleTS || a.Price != b.Price || a.Change != b.Change {
+		return false
+	}
+	if a.Volatility == nil || b.Volatility == nil {
+		return a.Volatility == nil && b.Volatility == nil
+	}
+	return *a.Volatility == *b.Volatility
+}
+
+func writeStreamEvent(w http.ResponseWriter, rc *http.ResponseController, id int, event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return writeStreamChunk(w, rc, fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", id, event, data))
+}
+
+func writeStreamComment(w http.ResponseWriter, rc *http.ResponseController, comment string) error {
+	return writeStreamChunk(w, rc, ": "+comment+"\n\n")
+}
+
+// writeStreamChunk bounds each write with its own deadline, replacing the
+// server-wide WriteTimeout that would otherwise end the stream.
+func writeStreamChunk(w http.ResponseWriter, rc *http.ResponseController, chunk string) error {
+	if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(w, chunk); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffVolatilityReportsEnteredChangedAndLeft(t *testing.T) {
+	item := func(symbol string, pct float64) volatilityItem {
+		it := volatilityItem{Symbol: symbol, Timeframe: "1h", CandleTS: 100}
+		it.Change.Pct = pct
+		return it
+	}
+	prev := []volatilityItem{item("AAAUSDT", 9), item("BBBUSDT", 7), item("CCCUSDT", 6)}
+	next := []volatilityItem{item("BBBUSDT", 8), item("AAAUSDT", 7.5), item("DDDUSDT", 6.5)}
+
+	diff, changed := diffVolatility(prev, next)
+	if !changed {
+		t.Fatal("changed = false, want true")
+	}
+	if len(diff.Entered) != 1 || diff.Entered[0].Symbol != "DDDUSDT" {
+		t.Fatalf("entered = %+v, want DDDUSDT", diff.Entered)
+	}
+	if len(diff.Changed) != 2 || diff.Changed[0].Symbol != "BBBUSDT" || diff.Changed[1].Symbol != "AAAUSDT" {
+		t.Fatalf("changed = %+v, want BBBUSDT then AAAUSDT", diff.Changed)
+	}
+	if !slices.Equal(diff.Left, []string{"CCCUSDT"}) {
+		t.Fatalf("left = %v, want [CCCUSDT]", diff.Left)
+	}
+	if !slices.Equal(diff.Order, []string{"BBBUSDT", "AAAUSDT", "DDDUSDT"}) {
+		t.Fatalf("order = %v", diff.Order)
+	}
+
+	if _, changed := diffVolatility(next, next); changed {
+		t.Fatal("identical rankings should not produce a diff")
+	}
+}
+
+func TestVolatilityStreamsDropSlowSubscriber(t *testing.T) {
+	streams := newVolatilityStreams(2)
+	slow, _ := streams.subscribe("1h")
+	other, _ := streams.subscribe("4h")
+	if _, ok := streams.subscribe("1h"); ok {
+		t.Fatal("subscribe beyond the limit should fail")
+	}
+
+	for i := 0; i <= streamSubscriberBuffer; i++ {
+		streams.publish("1h", marketSnapshot{})
+	}
+	select {
+	case <-slow.dropped:
+	default:
+		t.Fatal("subscriber with a full buffer should be dropped")
+	}
+	if len(other.updates) != 0 {
+		t.Fatalf("other timeframe received %d updates, want 0", len(other.updates))
+	}
+	if _, ok := streams.subscribe("1h"); !ok {
+		t.Fatal("dropped subscriber should free its slot")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *apiServer) symbolsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	timeframes, ok := s.parseCoverageTimeframes(w, r)
+	if !ok {
+		return
+	}
+
+	stale := false
+	if raw := strings.TrimSpace(r.URL.Query().Get("stale")); raw != "" {
+		if raw != "true" && raw != "false" {
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_INPUT", "stale は true/false のいずれかを指定してください")
+			return
+		}
+		stale = raw == "true"
+	}
+
+	items, err := s.querySymbols(r.Context(), timeframes, "")
+	if err != nil {
+		s.writeQueryError(w, r, err, "symbols query error")
+		return
+	}
+	if stale {
+		filtered := make([]symbolInfo, 0, len(items))
+		for _, item := range items {
+			if item.hasStaleTimeframe() {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	writeData(w, r, http.StatusOK, symbolsResponse{Count: len(items), Data: items})
+}
+
+func (s *apiServer) symbolHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	symbol := strings.ToUpper(strings.TrimSpace(r.PathValue("symbol")))
+	if !symbolRegex.MatchString(symbol) {
+		writeError(w, http.StatusBadRequest, "INVALID_SYMBOL", "symbol は英数字の銘柄シンボルを指定してください (例: BTCUSDT)")
+		return
+	}
+
+	timeframes, ok := s.parseCoverageTimeframes(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := s.querySymbols(r.Context(), timeframes, symbol)
+	if err != nil {
+		s.writeQueryError(w, r, err, "symbol query error", "symbol", symbol)
+		return
+	}
+	if len(items) == 0 {
+		writeError(w, http.StatusNotFound, "SYMBOL_NOT_FOUND", fmt.Sprintf("銘柄 %s のデータはありません", symbol))
+		return
+	}
+
+	writeData(w, r, http.StatusOK, items[0])
+}
+
+// parseCoverageTimeframes reads the optional timeframe filter. Without it
+// every cached timeframe is reported.
+func (s *apiServer) parseCoverageTimeframes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	timeframe := strings.TrimSpace(r.URL.Query().Get("timeframe"))
+	if timeframe == "" {
+		return s.cacheTimeframes, true
+	}
+	if !contains(validTimeframes, timeframe) {
+		writeError(w, http.StatusBadRequest, "INVALID_TIMEFRAME", fmt.Sprintf("無効なタイムフレームです。有効な値: %s", strings.Join(validTimeframes, ", ")))
+		return nil, false
+	}
+	return []string{timeframe}, true
+}
+
+// querySymbols builds per-timeframe coverage from the cached series and
+// joins instrument metadata when the fetcher has stored it. An empty
+// onlySymbol lists every known symbol.
+func (s *apiServer) querySymbols(ctx context.Context, timeframes []string, onlySymbol string) ([]symbolInfo, error) {
+	nowMS := time.Now().UTC().UnixMilli()
+	bySymbol := make(map[string]*symbolInfo)
+	entry := func(symbol string) *symbolInfo {
+		info, ok := bySymbol[symbol]
+		if !ok {
+			info = &symbolInfo{Symbol: symbol, Timeframes: make(map[string]symbolCoverage)}
+			bySymbol[symbol] = info
+		}
+		return info
+	}
+
+	for _, tf := range timeframes {
+		snapshot, err := s.marketCache.getSnapshot(ctx, tf)
+		if err != nil {
+			return nil, err
+		}
+		tfMinutes, err := parseTimeframeToMinutes(tf)
+		if err != nil {
+			return nil, err
+		}
+		stepMS := int64(tfMinutes) * 60 * 1000
+
+		for symbol, candles := range snapshot.seriesBySymbol {
+			if len(candles) == 0 || (onlySymbol != "" && symbol != onlySymbol) {
+				continue
+			}
+			entry(symbol).Timeframes[tf] = buildCoverage(candles, stepMS, nowMS)
+		}
+	}
+
+	instruments, err := s.queryInstruments(ctx, onlySymbol)
+	if err != nil {
+		return nil, err
+	}
+	for symbol, inst := range instruments {
+		entry(symbol).Instrument = inst
+	}
+
+	items := make([]symbolInfo, 0, len(bySymbol))
+	for _, info := range bySymbol {
+		items = append(items, *info)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Symbol < items[j].Symbol })
+	return items, nil
+}
+
+// buildCoverage summarises a newest-first series. missing_bars counts the
+// step-aligned timestamps absent between the oldest and newest candle.
+func buildCoverage(candles []marketCandle, stepMS, nowMS int64) symbolCoverage {
+	newest := candles[0].TS
+	oldest := candles[len(candles)-1].TS
+	expected := int((newest-oldest)/stepMS) + 1
+
+	coverage := symbolCoverage{
+		Candles:          len(candles),
+		OldestTS:         oldest,
+		NewestTS:         newest,
+		NewestAgeSeconds: (nowMS - newest) / 1000,
+		MissingBars:      maxInt(expected-len(candles), 0),
+	}
+	// The newest candle is the one still forming; anything older than two
+	// bars means the series stopped updating.
+	coverage.Stale = nowMS-newest > 2*stepMS
+	return coverage
+}
+
+func (s *apiServer) queryInstruments(ctx context.Context, onlySymbol string) (map[string]*instrumentMeta, error) {
+	exists, err := tableExists(ctx, s.db, "instruments")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return map[string]*instrumentMeta{}, nil
+	}
+
+	query := `
+		SELECT symbol, contract_type, status, base_coin, quote_coin, launch_time, tick_size, qty_step, min_order_qty
+		FROM instruments
+		WHERE ? = '' OR symbol = ?
+	`
+	rows, err := s.db.QueryContext(ctx, query, onlySymbol, onlySymbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	instruments := make(map[string]*instrumentMeta)
+	for rows.Next() {
+		var symbol string
+		var meta instrumentMeta
+		if err := rows.Scan(&symbol, &meta.ContractType, &meta.Status, &meta.BaseCoin, &meta.QuoteCoin, &meta.LaunchTime, &meta.TickSize, &meta.QtyStep, &meta.MinOrderQty); err != nil {
+			return nil, err
+		}
+		instruments[symbol] = &meta
+	}
+	return instruments, rows.Err()
+}
+
+func (info symbolInfo) hasStaleTimeframe() bool {
+	for _, coverage := range info.Timeframes {
+		if coverage.Stale {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestBuildCoverageMissingBarsAndStale(t *testing.T) {
+	const step = int64(60_000)
+	series := func(ts ...int64) []marketCandle {
+		candles := make([]marketCandle, len(ts))
+		for i, v := range ts {
+			candles[i] = marketCandle{TS: v * step}
+		}
+		return candles
+	}
+
+	cases := []struct {
+		name        string
+		candles     []marketCandle
+		now         int64
+		wantMissing int
+		wantStale   bool
+		wantAge     int64
+	}{
+		{"complete and forming", series(10, 9, 8, 7), 10*step + 30_000, 0, false, 30},
+		{"gaps in the middle", series(10, 7, 6, 3), 10 * step, 4, false, 0},
+		{"single candle", series(5), 5 * step, 0, false, 0},
+		{"two bars behind", series(10, 9), 12 * step, 0, false, 120},
+		{"stopped updating", series(10, 9), 12*step + 1, 0, true, 120},
+	}
+	for _, tc := range cases {
+		got := buildCoverage(tc.candles, step, tc.now)
+		if got.MissingBars != tc.wantMissing || got.Stale != tc.wantStale || got.NewestAgeSeconds != tc.wantAge {
+			t.Errorf("%s: coverage = %+v", tc.name, got)
+		}
+		if got.Candles != len(tc.candles) || got.NewestTS != tc.candles[0].TS || got.OldestTS != tc.candles[len(tc.candles)-1].TS {
+			t.Errorf("%s: bounds = %+v", tc.name, got)
+		}
+	}
+}
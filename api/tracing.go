@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+
+	"volatility-cmma-go/internal/sqltrace"
+)
+
+var tracer = otel.Tracer("volatility-cmma-go/api")
+
+// openSQLite opens dsn with statements traced as children of their context.
+func openSQLite(dsn string) (*sql.DB, error) {
+	return sqltrace.Open(dsn, tracer)
+}
+
+// setupTracing installs the global tracer provider. OTEL_TRACES_EXPORTER
+// selects otlp (OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_*
+// variables), stdout or none; sampling follows OTEL_TRACES_SAMPLER and
+// OTEL_TRACES_SAMPLER_ARG. The returned function flushes pending spans.
+func setupTracing(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := strings.ToLower(strings.TrimSpace(getEnv("OTEL_TRACES_EXPORTER", "none"))); name {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q (otlp, stdout or none)", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// withTracing starts a server span per request, named after the mux pattern
+// so spans group by route. Readiness probes are not traced.
+func withTracing(next http.Handler, mux *http.ServeMux) http.Handler {
+	return otelhttp.NewHandler(next, "api",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			return r.Method + " " + pattern
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/readyz"
+		}),
+	)
+}
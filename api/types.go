@@ -2,26 +2,60 @@ package main
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 	"regexp"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	validTimeframes = []string{"1m", "5m", "15m", "30m", "1h", "4h", "1d", "1w", "1M"}
 	validPeriods    = []string{"1h", "6h", "12h", "24h", "1d", "7d", "1w", "1M"}
 	tableNameRegex  = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+	symbolRegex     = regexp.MustCompile(`^[0-9A-Z]{1,32}$`)
 )
 
+// maxTimestampMS is the open upper bound for time range filters.
+const maxTimestampMS = int64(1<<63 - 1)
+
 type errorResponse struct {
 	Error struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id,omitempty"`
 	} `json:"error"`
 }
 
+// responseMeta describes how a ranking was produced. Total counts matches
+// before limit; Evaluated and Skipped split the symbols by whether they had
+// enough history. Params echoes the normalised parameters.
+type responseMeta struct {
+	Total      int                      `json:"total"`
+	Evaluated  int                      `json:"evaluated"`
+	Skipped    int                      `json:"skipped"`
+	Params     map[string]any           `json:"params"`
+	Timeframes map[string]timeframeMeta `json:"timeframes"`
+}
+
+// timeframeMeta is the freshness of one timeframe's snapshot. NewestTS is the
+// newest candle used, which is at or before as_of when given.
+type timeframeMeta struct {
+	RefreshedAt string `json:"refreshed_at,omitempty"`
+	NewestTS    int64  `json:"newest_ts"`
+}
+
+// rankStats counts the symbols a ranking looked at for responseMeta.
+type rankStats struct {
+	evaluated int
+	skipped   int
+	matched   int
+}
+
 type volatilityResponse struct {
 	Count int              `json:"count"`
+	AsOf  int64            `json:"as_of,omitempty"`
 	Data  []volatilityItem `json:"data"`
+	Meta  *responseMeta    `json:"meta,omitempty"`
 }
 
 type volatilityItem struct {
@@ -36,11 +70,70 @@ type volatilityItem struct {
 		Pct       float64 `json:"pct"`
 		Direction string  `json:"direction"`
 	} `json:"change"`
+	Volatility *volatilityMeasure `json:"volatility,omitempty"`
+}
+
+// volatilityStreamDiff is the payload of a /stream/volatility "diff" event.
+// Order lists every symbol in the new ranking so clients can re-sort.
+type volatilityStreamDiff struct {
+	Seq       int              `json:"seq"`
+	Timeframe string           `json:"timeframe"`
+	Entered   []volatilityItem `json:"entered"`
+	Changed   []volatilityItem `json:"changed"`
+	Left      []string         `json:"left"`
+	Order     []string         `json:"order"`
+}
+
+// volatilityMeasure is present when /volatility ranks by an estimator other
+// than the close-to-close change.
+type volatilityMeasure struct {
+	Metric     string  `json:"metric"`
+	Value      float64 `json:"value"`
+	Bars       int     `json:"bars"`
+	Annualized bool    `json:"annualized"`
+}
+
+// sortValue is the value /volatility ranks by: the signed change, or the
+// estimator value when one was requested.
+func (item volatilityItem) sortValue() float64 {
+	if item.Volatility != nil {
+		return item.Volatility.Value
+	}
+	return item.Change.Pct
+}
+
+type volatilityParams struct {
+	timeframe       string
+	threshold       float64
+	offset          int
+	direction       string
+	sort            string
+	limit           int
+	metric          string
+	annualize       bool
+	asOf            int64
+	minTurnover     float64
+	minVolume       float64
+	liquidityPeriod string
+	liquidityBars   int
+	symbols         []string
+	exclude         []string
+	base            string
+	maxPct          float64
+}
+
+// paramError is a validation failure rendered through writeError.
+type paramError struct {
+	status  int
+	code    string
+	message string
 }
 
 type volumeResponse struct {
-	Count int          `json:"count"`
-	Data  []volumeItem `json:"data"`
+	Count int           `json:"count"`
+	AsOf  int64         `json:"as_of,omitempty"`
+	Data  []volumeItem  `json:"data"`
+	Meta  *responseMeta `json:"meta,omitempty"`
 }
 
 type volumeItem struct {
@@ -49,11 +142,286 @@ type volumeItem struct {
 	TotalTurnover float64 `json:"total_turnover"`
 	Timeframe     string  `json:"timeframe"`
 	Period        string  `json:"period"`
+
+	Comparison *volumeComparison `json:"comparison,omitempty"`
+}
+
+// volumeComparison is present with compare=previous and describes the window
+// of the same length immediately before the current one.
+type volumeComparison struct {
+	PreviousVolume    float64  `json:"previous_volume"`
+	PreviousTurnover  float64  `json:"previous_turnover"`
+	VolumeChange      float64  `json:"volume_change"`
+	VolumeChangePct   *float64 `json:"volume_change_pct"`
+	TurnoverChange    float64  `json:"turnover_change"`
+	TurnoverChangePct *float64 `json:"turnover_change_pct"`
+	RankBy            string   `json:"rank_by"`
+	Rank              int      `json:"rank"`
+	PreviousRank      *int     `json:"previous_rank"`
+	RankChange        *int     `json:"rank_change"`
+	PreviousCoverage  float64  `json:"previous_coverage"`
+	PreviousPartial   bool     `json:"previous_partial"`
+}
+
+type volumeParams struct {
+	timeframe       string
+	period          string
+	periodBars      int
+	minVolume       float64
+	minVolumeTarget string
+	compare         string
+	sort            string
+	limit           int
+	asOf            int64
+}
+
+// indicatorsResponse lists rows oldest-first. Columns gives the value keys in
+// the order requested; a value is null while the indicator is warming up.
+type indicatorsResponse struct {
+	Symbol    string         `json:"symbol"`
+	Timeframe string         `json:"timeframe"`
+	Columns   []string       `json:"columns"`
+	Count     int            `json:"count"`
+	AsOf      int64          `json:"as_of,omitempty"`
+	Data      []indicatorRow `json:"data"`
+}
+
+type indicatorRow struct {
+	TS     int64               `json:"ts"`
+	Close  float64             `json:"close"`
+	Values map[string]*float64 `json:"values"`
+}
+
+// screenResponse lists the symbols matching a /screen filter. Matched is the
+// number of matches before limit; Evaluated is the size of the universe.
+type screenResponse struct {
+	Count     int          `json:"count"`
+	Matched   int          `json:"matched"`
+	Evaluated int          `json:"evaluated"`
+	Cost      int          `json:"cost"`
+	Columns   []string     `json:"columns"`
+	AsOf      int64        `json:"as_of,omitempty"`
+	Data      []screenItem `json:"data"`
+}
+
+type screenItem struct {
+	Symbol string              `json:"symbol"`
+	Values map[string]*float64 `json:"values"`
+}
+
+type alertRule struct {
+	ID              int64    `json:"id"`
+	Name            string   `json:"name"`
+	Metric          string   `json:"metric"`
+	Timeframe       string   `json:"timeframe"`
+	Bars            int      `json:"bars"`
+	Threshold       float64  `json:"threshold"`
+	Direction       string   `json:"direction"`
+	Symbols         []string `json:"symbols"`
+	CooldownSeconds int      `json:"cooldown_seconds"`
+	WebhookURL      string   `json:"webhook_url"`
+	WebhookFormat   string   `json:"webhook_format"`
+	Enabled         bool     `json:"enabled"`
+	CreatedAt       int64    `json:"created_at"`
+	UpdatedAt       int64    `json:"updated_at"`
+}
+
+type alertRulesResponse struct {
+	Count int         `json:"count"`
+	Data  []alertRule `json:"data"`
+}
+
+// alertFiring is one rule crossing for one symbol and candle, together with
+// the state of its webhook delivery.
+type alertFiring struct {
+	ID          int64   `json:"id"`
+	RuleID      int64   `json:"rule_id"`
+	Symbol      string  `json:"symbol"`
+	Value       float64 `json:"value"`
+	CandleTS    int64   `json:"candle_ts"`
+	FiredAt     int64   `json:"fired_at"`
+	Status      string  `json:"status"`
+	Attempts    int     `json:"attempts"`
+	LastError   string  `json:"last_error,omitempty"`
+	DeliveredAt *int64  `json:"delivered_at"`
+}
+
+type alertFiringsResponse struct {
+	Count int           `json:"count"`
+	Data  []alertFiring `json:"data"`
+}
+
+type correlationResponse struct {
+	Count int               `json:"count"`
+	AsOf  int64             `json:"as_of,omitempty"`
+	Data  []correlationItem `json:"data"`
+	Meta  *responseMeta     `json:"meta,omitempty"`
+}
+
+// correlationItem regresses the symbol's log returns on the benchmark's over
+// the aligned window. ResidualVolPct is per bar.
+type correlationItem struct {
+	Symbol         string  `json:"symbol"`
+	Benchmark      string  `json:"benchmark"`
+	Timeframe      string  `json:"timeframe"`
+	Window         int     `json:"window"`
+	Observations   int     `json:"observations"`
+	Correlation    float64 `json:"correlation"`
+	Beta           float64 `json:"beta"`
+	ResidualVolPct float64 `json:"residual_vol_pct"`
+}
+
+type correlationMatrixResponse struct {
+	Timeframe    string       `json:"timeframe"`
+	Window       int          `json:"window"`
+	Symbols      []string     `json:"symbols"`
+	Matrix       [][]*float64 `json:"matrix"`
+	Observations [][]int      `json:"observations"`
+	AsOf         int64        `json:"as_of,omitempty"`
+}
+
+type volumeSpikesResponse struct {
+	Count int               `json:"count"`
+	AsOf  int64             `json:"as_of,omitempty"`
+	Data  []volumeSpikeItem `json:"data"`
+	Meta  *responseMeta     `json:"meta,omitempty"`
+}
+
+type volumeSpikeItem struct {
+	Symbol    string `json:"symbol"`
+	Timeframe string `json:"timeframe"`
+	CandleTS  int64  `json:"candle_ts"`
+	Target    string `json:"target"`
+	Method    string `json:"method"`
+	Recent    struct {
+		Total    float64 `json:"total"`
+		PerBar   float64 `json:"per_bar"`
+		Bars     int     `json:"bars"`
+		Turnover float64 `json:"turnover"`
+	} `json:"recent"`
+	Baseline struct {
+		Mean   float64 `json:"mean"`
+		Median float64 `json:"median"`
+		Stdev  float64 `json:"stdev"`
+		Bars   int     `json:"bars"`
+	} `json:"baseline"`
+	Ratio  float64  `json:"ratio"`
+	ZScore *float64 `json:"zscore"`
+	Score  float64  `json:"score"`
+}
+
+// breadthResponse summarises the whole market for one timeframe. Ratios and
+// averages are null when there is nothing to divide by.
+type breadthResponse struct {
+	Timeframe                 string          `json:"timeframe"`
+	Offset                    int             `json:"offset"`
+	Lookback                  int             `json:"lookback"`
+	AsOf                      int64           `json:"as_of,omitempty"`
+	Symbols                   int             `json:"symbols"`
+	Up                        int             `json:"up"`
+	Down                      int             `json:"down"`
+	Flat                      int             `json:"flat"`
+	AdvanceDeclineRatio       *float64        `json:"advance_decline_ratio"`
+	MeanChangePct             *float64        `json:"mean_change_pct"`
+	MedianChangePct           *float64        `json:"median_change_pct"`
+	TurnoverWeightedChangePct *float64        `json:"turnover_weighted_change_pct"`
+	Histogram                 []breadthBucket `json:"histogram"`
+	AboveHigh                 breadthShare    `json:"above_high"`
+	BelowLow                  breadthShare    `json:"below_low"`
+	Meta                      *responseMeta   `json:"meta,omitempty"`
+}
+
+// breadthBucket counts changes in [Min, Max); a nil bound is unbounded.
+type breadthBucket struct {
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+type breadthShare struct {
+	Count     int      `json:"count"`
+	Evaluated int      `json:"evaluated"`
+	Pct       *float64 `json:"pct"`
+}
+
+type candlesResponse struct {
+	Symbol     string       `json:"symbol"`
+	Timeframe  string       `json:"timeframe"`
+	Count      int          `json:"count"`
+	Data       []candleItem `json:"data"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+type candleItem struct {
+	TS       int64   `json:"ts"`
+	Open     float64 `json:"open"`
+	High     float64 `json:"high"`
+	Low      float64 `json:"low"`
+	Close    float64 `json:"close"`
+	Volume   float64 `json:"volume"`
+	Turnover float64 `json:"turnover"`
+}
+
+type symbolsResponse struct {
+	Count int          `json:"count"`
+	Data  []symbolInfo `json:"data"`
+}
+
+type symbolInfo struct {
+	Symbol     string                    `json:"symbol"`
+	Instrument *instrumentMeta           `json:"instrument,omitempty"`
+	Timeframes map[string]symbolCoverage `json:"timeframes"`
+}
+
+type instrumentMeta struct {
+	ContractType string  `json:"contract_type"`
+	Status       string  `json:"status"`
+	BaseCoin     string  `json:"base_coin"`
+	QuoteCoin    string  `json:"quote_coin"`
+	LaunchTime   int64   `json:"launch_time"`
+	TickSize     float64 `json:"tick_size"`
+	QtyStep      float64 `json:"qty_step"`
+	MinOrderQty  float64 `json:"min_order_qty"`
+}
+
+type symbolCoverage struct {
+	Candles          int   `json:"candles"`
+	OldestTS         int64 `json:"oldest_ts"`
+	NewestTS         int64 `json:"newest_ts"`
+	NewestAgeSeconds int64 `json:"newest_age_seconds"`
+	MissingBars      int   `json:"missing_bars"`
+	Stale            bool  `json:"stale"`
+}
+
+type readyResponse struct {
+	Status     string                    `json:"status"`
+	Timeframes map[string]timeframeReady `json:"timeframes"`
+}
+
+type timeframeReady struct {
+	Loaded      bool   `json:"loaded"`
+	RefreshedAt string `json:"refreshed_at,omitempty"`
 }
 
 type apiServer struct {
-	logger            *log.Logger
+	// draining is set once shutdown starts so /readyz reports not-ready
+	// while in-flight requests finish.
+	draining          atomic.Bool
+	logger            *slog.Logger
 	db                *sql.DB
 	ohlcvHistoryLimit int
+	cacheTimeframes   []string
+	retryAfterSeconds int
+	screenMaxCost     int
 	marketCache       *marketDataCache
+	// alerts is nil when no state database is configured.
+	alerts *alertEngine
+	// cacheMaxAgeSeconds is the Cache-Control max-age of snapshot-backed
+	// responses.
+	cacheMaxAgeSeconds int
+	// privateResponses is set when API key auth is on, so shared caches do
+	// not hand one key's response to an unauthenticated client.
+	privateResponses bool
+	streams          *volatilityStreams
+	streamHeartbeat  time.Duration
 }
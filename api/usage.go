@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type usageKey struct {
+	keyName  string
+	endpoint string
+	hourTS   int64
+}
+
+type usageCount struct {
+	requests    int64
+	rateLimited int64
+}
+
+// usageRecorder counts authenticated requests per key, route and hour in
+// memory and periodically adds them to the api_usage table. A nil recorder
+// discards counts (no state database).
+type usageRecorder struct {
+	db     *sql.DB
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[usageKey]*usageCount
+}
+
+func newUsageRecorder(db *sql.DB, logger *slog.Logger) *usageRecorder {
+	return &usageRecorder{db: db, logger: logger, pending: make(map[usageKey]*usageCount)}
+}
+
+func (u *usageRecorder) record(keyName, endpoint string, rateLimited bool) {
+	if u == nil {
+		return
+	}
+	hourTS := time.Now().UTC().Truncate(time.Hour).UnixMilli()
+	k := usageKey{keyName: keyName, endpoint: endpoint, hourTS: hourTS}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c, ok := u.pending[k]
+	if !ok {
+		c = &usageCount{}
+		u.pending[k] = c
+	}
+	c.requests++
+	if rateLimited {
+		c.rateLimited++
+	}
+}
+
+func (u *usageRecorder) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Final flush on a fresh context; ctx is already cancelled.
+			if err := u.flush(context.Background()); err != nil {
+				u.logger.Error("usage flush error", "err", err)
+			}
+			return
+		case <-ticker.C:
+			if err := u.flush(ctx); err != nil {
+				u.logger.Error("usage flush error", "err", err)
+			}
+		}
+	}
+}
+
+// flush writes the pending counts. On failure they are merged back so the
+// next flush retries them.
+func (u *usageRecorder) flush(ctx context.Context) error {
+	u.mu.Lock()
+	batch := u.pending
+	u.pending = make(map[usageKey]*usageCount)
+	u.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := u.write(ctx, batch)
+	if err != nil {
+		u.mu.Lock()
+		for k, c := range batch {
+			if cur, ok := u.pending[k]; ok {
+				cur.requests += c.requests
+				cur.rateLimited += c.rateLimited
+			} else {
+				u.pending[k] = c
+			}
+		}
+		u.mu.Unlock()
+	}
+	return err
+}
+
+func (u *usageRecorder) write(ctx context.Context, batch map[usageKey]*usageCount) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO api_usage (key_name, endpoint, hour_ts, requests, rate_limited)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key_name, endpoint, hour_ts) DO UPDATE SET
+			requests = requests + excluded.requests,
+			rate_limited = rate_limited + excluded.rate_limited`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for k, c := range batch {
+		if _, err := stmt.ExecContext(ctx, k.keyName, k.endpoint, k.hourTS, c.requests, c.rateLimited); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func parseTimeframeToMinutes(s string) (int, error) {
@@ -77,6 +79,31 @@ func parsePositiveFloat(v string) (float64, error) {
 	return f, nil
 }
 
+// parseTimeParam accepts a UNIX timestamp in milliseconds or an RFC3339 time
+// and returns milliseconds.
+func parseTimeParam(v string) (int64, error) {
+	v = strings.TrimSpace(v)
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if ms < 0 {
+			return 0, errors.New("negative timestamp")
+		}
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, errors.New("invalid time")
+	}
+	return t.UnixMilli(), nil
+}
+
+func tableExists(ctx context.Context, db *sql.DB, tableName string) (bool, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, tableName).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func safeTableName(timeframe string) (string, error) {
 	tf := strings.TrimSpace(timeframe)
 	if tf == "" {
@@ -89,9 +116,16 @@ func safeTableName(timeframe string) (string, error) {
 }
 
 func writeError(w http.ResponseWriter, status int, code, message string) {
+	// Validators and caching set for a success response must not describe
+	// the error.
+	header := w.Header()
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	header.Del("Cache-Control")
 	resp := errorResponse{}
 	resp.Error.Code = code
 	resp.Error.Message = message
+	resp.Error.RequestID = w.Header().Get("X-Request-ID")
 	writeJSONStatus(w, status, resp)
 }
 
@@ -102,7 +136,7 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 func writeJSONStatus(w http.ResponseWriter, status int, payload any) {
 	data, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("failed to encode JSON response: %v", err)
+		slog.Error("failed to encode JSON response", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, `{"error":{"code":"INTERNAL_ERROR","message":"internal error"}}`)
 		return
@@ -115,6 +149,20 @@ func round4(v float64) float64 {
 	return math.Round(v*10000) / 10000
 }
 
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func contains(values []string, target string) bool {
 	for _, v := range values {
 		if v == target {
@@ -124,6 +172,26 @@ func contains(values []string, target string) bool {
 	return false
 }
 
+// loadCacheTimeframes returns the timeframes the API keeps warm, taken from
+// the same TIMEFRAMES setting as the fetcher. Unknown entries are ignored.
+func loadCacheTimeframes() []string {
+	raw := strings.TrimSpace(os.Getenv("TIMEFRAMES"))
+	if raw == "" {
+		return validTimeframes
+	}
+	timeframes := make([]string, 0, len(validTimeframes))
+	for _, tf := range strings.Split(raw, ",") {
+		tf = strings.TrimSpace(tf)
+		if contains(validTimeframes, tf) && !contains(timeframes, tf) {
+			timeframes = append(timeframes, tf)
+		}
+	}
+	if len(timeframes) == 0 {
+		return validTimeframes
+	}
+	return timeframes
+}
+
 func getEnv(key, fallback string) string {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
@@ -149,7 +217,7 @@ func configureSQLiteForReader(db *sql.DB) error {
 	// WALは書き込み可能な場合にのみ変更できる。読み取り専用マウントでは
 	// この変更だけ失敗し得るため、他のPRAGMAとは異なり起動を止めない。
 	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
-		log.Printf("WAL pragma skipped (read-only mount?): %v", err)
+		slog.Warn("WAL pragma skipped (read-only mount?)", "err", err)
 	}
 	return nil
 }
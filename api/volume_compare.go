@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+var volumeChangeSortKeys = []string{"volume_change_desc", "volume_change_asc", "turnover_change_desc", "turnover_change_asc"}
+
+// previousWindow totals the candles in [prevStartTSMS, startTSMS) of a
+// newest-first series. Coverage is the share of the expected bars present;
+// the window is partial when history does not reach back to its start or
+// bars inside it are missing.
+func previousWindow(candles []marketCandle, startTSMS, prevStartTSMS int64, expectedBars int) *volumeComparison {
+	cmp := &volumeComparison{}
+	bars := 0
+	for _, candle := range candles {
+		if candle.TS >= startTSMS {
+			continue
+		}
+		if candle.TS < prevStartTSMS {
+			break
+		}
+		bars++
+		cmp.PreviousVolume += candle.Volume
+		cmp.PreviousTurnover += candle.Turnover
+	}
+
+	reachesBack := len(candles) > 0 && candles[len(candles)-1].TS <= prevStartTSMS
+	cmp.PreviousCoverage = float64(bars) / float64(expectedBars)
+	if cmp.PreviousCoverage > 1 {
+		cmp.PreviousCoverage = 1
+	}
+	cmp.PreviousPartial = !reachesBack || bars < expectedBars
+	return cmp
+}
+
+// volumeRankBy picks the total that ranks are computed on: turnover when the
+// requested sort is turnover-based, otherwise volume.
+func volumeRankBy(sortKey string) string {
+	if strings.HasPrefix(sortKey, "turnover") {
+		return "turnover"
+	}
+	return "volume"
+}
+
+// assignVolumeRanks fills current and previous ranks (1 = largest) across all
+// symbols with current data, before min_volume filtering and limit, and the
+// change figures derived from the two windows.
+func assignVolumeRanks(items []volumeItem, rankBy string) {
+	current := func(item volumeItem) float64 {
+		if rankBy == "turnover" {
+			return item.TotalTurnover
+		}
+		return item.TotalVolume
+	}
+	previous := func(item volumeItem) float64 {
+		if rankBy == "turnover" {
+			return item.Comparison.PreviousTurnover
+		}
+		return item.Comparison.PreviousVolume
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ia, ib := items[order[a]], items[order[b]]
+		if current(ia) == current(ib) {
+			return ia.Symbol < ib.Symbol
+		}
+		return current(ia) > current(ib)
+	})
+	for rank, idx := range order {
+		items[idx].Comparison.Rank = rank + 1
+	}
+
+	withPrevious := make([]int, 0, len(items))
+	for i, item := range items {
+		if previous(item) > 0 {
+			withPrevious = append(withPrevious, i)
+		}
+	}
+	sort.SliceStable(withPrevious, func(a, b int) bool {
+		ia, ib := items[withPrevious[a]], items[withPrevious[b]]
+		if previous(ia) == previous(ib) {
+			return ia.Symbol < ib.Symbol
+		}
+		return previous(ia) > previous(ib)
+	})
+	for rank, idx := range withPrevious {
+		prevRank := rank + 1
+		change := prevRank - items[idx].Comparison.Rank
+		items[idx].Comparison.PreviousRank = &prevRank
+		items[idx].Comparison.RankChange = &change
+	}
+
+	for i := range items {
+		cmp := items[i].Comparison
+		cmp.RankBy = rankBy
+		cmp.VolumeChange = items[i].TotalVolume - cmp.PreviousVolume
+		cmp.TurnoverChange = items[i].TotalTurnover - cmp.PreviousTurnover
+		cmp.VolumeChangePct = percentChange(items[i].TotalVolume, cmp.PreviousVolume)
+		cmp.TurnoverChangePct = percentChange(items[i].TotalTurnover, cmp.PreviousTurnover)
+	}
+}
+
+func percentChange(current, previous float64) *float64 {
+	if previous <= 0 {
+		return nil
+	}
+	pct := (current - previous) / previous * 100
+	return &pct
+}
+
+// lessByChange orders by percent change; items without a previous total
+// (nil change) always sort last.
+func lessByChange(a, b volumeItem, sortKey string) bool {
+	pick := func(item volumeItem) *float64 {
+		if strings.HasPrefix(sortKey, "turnover") {
+			return item.Comparison.TurnoverChangePct
+		}
+		return item.Comparison.VolumeChangePct
+	}
+	pa, pb := pick(a), pick(b)
+	switch {
+	case pa == nil && pb == nil:
+		return a.Symbol < b.Symbol
+	case pa == nil:
+		return false
+	case pb == nil:
+		return true
+	case *pa == *pb:
+		return a.Symbol < b.Symbol
+	case strings.HasSuffix(sortKey, "_asc"):
+		return *pa < *pb
+	default:
+		return *pa > *pb
+	}
+}
+
+func (c *volumeComparison) round() {
+	c.PreviousVolume = round4(c.PreviousVolume)
+	c.PreviousTurnover = round4(c.PreviousTurnover)
+	c.VolumeChange = round4(c.VolumeChange)
+	c.TurnoverChange = round4(c.TurnoverChange)
+	c.PreviousCoverage = round4(c.PreviousCoverage)
+	if c.VolumeChangePct != nil {
+		v := round4(*c.VolumeChangePct)
+		c.VolumeChangePct = &v
+	}
+	if c.TurnoverChangePct != nil {
+		v := round4(*c.TurnoverChangePct)
+		c.TurnoverChangePct = &v
+	}
+}
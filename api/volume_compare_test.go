@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestPreviousWindowCoverage(t *testing.T) {
+	series := func(ts ...int64) []marketCandle {
+		candles := make([]marketCandle, len(ts))
+		for i, v := range ts {
+			candles[i] = marketCandle{TS: v, Volume: 1, Turnover: 10}
+		}
+		return candles
+	}
+
+	// Current window starts at 7, previous window is [4, 7) with 3 bars.
+	cases := []struct {
+		name         string
+		candles      []marketCandle
+		wantVolume   float64
+		wantCoverage float64
+		wantPartial  bool
+	}{
+		{"full", series(8, 7, 6, 5, 4, 3), 3, 1, false},
+		{"starts exactly at the window", series(8, 7, 6, 5, 4), 3, 1, false},
+		{"bar missing inside", series(8, 7, 6, 4, 3), 2, 2.0 / 3, true},
+		{"history too short", series(8, 7, 6, 5), 2, 2.0 / 3, true},
+		{"no previous bars", series(8, 7), 0, 0, true},
+		{"empty", nil, 0, 0, true},
+	}
+	for _, tc := range cases {
+		got := previousWindow(tc.candles, 7, 4, 3)
+		if got.PreviousVolume != tc.wantVolume || got.PreviousTurnover != tc.wantVolume*10 || got.PreviousCoverage != tc.wantCoverage || got.PreviousPartial != tc.wantPartial {
+			t.Errorf("%s: %+v", tc.name, got)
+		}
+	}
+}
+
+func TestAssignVolumeRanksOrdersTiesBySymbol(t *testing.T) {
+	item := func(symbol string, volume, turnover, prevVolume float64) volumeItem {
+		return volumeItem{Symbol: symbol, TotalVolume: volume, TotalTurnover: turnover, Comparison: &volumeComparison{PreviousVolume: prevVolume}}
+	}
+	items := []volumeItem{
+		item("CCCUSDT", 10, 1, 5),
+		item("AAAUSDT", 10, 3, 20),
+		item("BBBUSDT", 30, 2, 20),
+		item("DDDUSDT", 5, 4, 0),
+	}
+
+	assignVolumeRanks(items, "volume")
+
+	cases := []struct {
+		rank, prevRank int
+		hasPrev        bool
+	}{
+		{3, 3, true},
+		{2, 1, true},
+		{1, 2, true},
+		{4, 0, false},
+	}
+	for i, tc := range cases {
+		cmp := items[i].Comparison
+		if cmp.Rank != tc.rank || (cmp.PreviousRank != nil) != tc.hasPrev {
+			t.Fatalf("%s: rank %d previous %v", items[i].Symbol, cmp.Rank, cmp.PreviousRank)
+		}
+		if tc.hasPrev && (*cmp.PreviousRank != tc.prevRank || *cmp.RankChange != tc.prevRank-tc.rank) {
+			t.Fatalf("%s: previous rank %d change %d", items[i].Symbol, *cmp.PreviousRank, *cmp.RankChange)
+		}
+	}
+	if items[3].Comparison.VolumeChangePct != nil || *items[0].Comparison.VolumeChangePct != 100 {
+		t.Fatalf("change pct = %v / %v", items[3].Comparison.VolumeChangePct, items[0].Comparison.VolumeChangePct)
+	}
+
+	assignVolumeRanks(items, "turnover")
+	for i, want := range []int{4, 2, 3, 1} {
+		if items[i].Comparison.Rank != want || items[i].Comparison.RankBy != "turnover" {
+			t.Fatalf("turnover rank of %s = %d, want %d", items[i].Symbol, items[i].Comparison.Rank, want)
+		}
+	}
+}
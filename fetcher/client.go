@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var bybitRetryDelays = []time.Duration{
@@ -19,9 +23,9 @@ var bybitRetryDelays = []time.Duration{
 	360 * time.Second,
 }
 
-func getAllLinearSymbols(ctx context.Context, httpClient *http.Client, baseURL string) ([]string, error) {
+func getAllLinearInstruments(ctx context.Context, httpClient *http.Client, baseURL string) ([]instrumentInfo, error) {
 	cursor := ""
-	symbols := make([]string, 0, 800)
+	instruments := make([]instrumentInfo, 0, 800)
 
 	for {
 		url := fmt.Sprintf("%s/v5/market/instruments-info?category=linear&status=Trading&limit=1000", baseURL)
@@ -35,16 +39,19 @@ func getAllLinearSymbols(ctx context.Context, httpClient *http.Client, baseURL s
 		}
 
 		var payload bybitInstrumentsResp
-		if err := runBybitWithRetry(ctx, "instruments-info", func() error {
-			resp, err := httpClient.Do(req)
+		if err := runBybitWithRetry(ctx, "instruments-info", func(ctx context.Context) error {
+			resp, err := httpClient.Do(req.WithContext(ctx))
 			if err != nil {
+				metrics.observeRequest("instruments-info", "error")
 				return err
 			}
 			defer resp.Body.Close()
 
 			if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+				metrics.observeRequest("instruments-info", "error")
 				return err
 			}
+			metrics.observeRequest("instruments-info", strconv.Itoa(payload.RetCode))
 			if resp.StatusCode >= 300 {
 				return fmt.Errorf("status=%d", resp.StatusCode)
 			}
@@ -57,9 +64,24 @@ func getAllLinearSymbols(ctx context.Context, httpClient *http.Client, baseURL s
 		}
 
 		for _, item := range payload.Result.List {
-			if strings.HasSuffix(item.Symbol, "USDT") {
-				symbols = append(symbols, item.Symbol)
+			if !strings.HasSuffix(item.Symbol, "USDT") {
+				continue
 			}
+			launchTime, _ := strconv.ParseInt(item.LaunchTime, 10, 64)
+			tickSize, _ := parseFiniteFloat(item.PriceFilter.TickSize)
+			qtyStep, _ := parseFiniteFloat(item.LotSizeFilter.QtyStep)
+			minOrderQty, _ := parseFiniteFloat(item.LotSizeFilter.MinOrderQty)
+			instruments = append(instruments, instrumentInfo{
+				Symbol:       item.Symbol,
+				ContractType: item.ContractType,
+				Status:       item.Status,
+				BaseCoin:     item.BaseCoin,
+				QuoteCoin:    item.QuoteCoin,
+				LaunchTime:   launchTime,
+				TickSize:     tickSize,
+				QtyStep:      qtyStep,
+				MinOrderQty:  minOrderQty,
+			})
 		}
 
 		cursor = payload.Result.NextPageCursor
@@ -69,7 +91,7 @@ func getAllLinearSymbols(ctx context.Context, httpClient *http.Client, baseURL s
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	return symbols, nil
+	return instruments, nil
 }
 
 func getKlineData(ctx context.Context, httpClient *http.Client, baseURL, symbol, interval string, limit int) ([]klineRow, error) {
@@ -80,16 +102,19 @@ func getKlineData(ctx context.Context, httpClient *http.Client, baseURL, symbol,
 	}
 
 	var payload bybitKlineResp
-	if err := runBybitWithRetry(ctx, "kline", func() error {
-		resp, err := httpClient.Do(req)
+	if err := runBybitWithRetry(ctx, "kline", func(ctx context.Context) error {
+		resp, err := httpClient.Do(req.WithContext(ctx))
 		if err != nil {
+			metrics.observeRequest("kline", "error")
 			return err
 		}
 		defer resp.Body.Close()
 
 		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			metrics.observeRequest("kline", "error")
 			return err
 		}
+		metrics.observeRequest("kline", strconv.Itoa(payload.RetCode))
 		if resp.StatusCode >= 300 {
 			return fmt.Errorf("status=%d", resp.StatusCode)
 		}
@@ -104,12 +129,12 @@ func getKlineData(ctx context.Context, httpClient *http.Client, baseURL, symbol,
 	rows := make([]klineRow, 0, len(payload.Result.List))
 	for _, item := range payload.Result.List {
 		if len(item) < 7 {
-			log.Printf("Skipping malformed kline row for %s: expected at least 7 fields, got %d", symbol, len(item))
+			slog.Warn("skipping malformed kline row", "symbol", symbol, "fields", len(item))
 			continue
 		}
 		ts, err := strconv.ParseInt(item[0], 10, 64)
 		if err != nil {
-			log.Printf("Skipping kline row for %s: invalid timestamp %q: %v", symbol, item[0], err)
+			slog.Warn("skipping kline row with invalid timestamp", "symbol", symbol, "timestamp", item[0], "err", err)
 			continue
 		}
 		op, ok1 := parseFiniteFloat(item[1])
@@ -119,7 +144,7 @@ func getKlineData(ctx context.Context, httpClient *http.Client, baseURL, symbol,
 		vol, ok5 := parseFiniteFloat(item[5])
 		to, ok6 := parseFiniteFloat(item[6])
 		if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
-			log.Printf("Skipping kline row for %s: invalid (non-finite) price or volume values", symbol)
+			slog.Warn("skipping kline row with non-finite price or volume", "symbol", symbol)
 			continue
 		}
 		rows = append(rows, klineRow{TS: ts, Open: op, High: hi, Low: lo, Close: cl, Volume: vol, Turnover: to})
@@ -151,16 +176,19 @@ func getKlineDataByTimeRange(
 	}
 
 	var payload bybitKlineResp
-	if err := runBybitWithRetry(ctx, "kline-range", func() error {
-		resp, err := httpClient.Do(req)
+	if err := runBybitWithRetry(ctx, "kline-range", func(ctx context.Context) error {
+		resp, err := httpClient.Do(req.WithContext(ctx))
 		if err != nil {
+			metrics.observeRequest("kline-range", "error")
 			return err
 		}
 		defer resp.Body.Close()
 
 		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			metrics.observeRequest("kline-range", "error")
 			return err
 		}
+		metrics.observeRequest("kline-range", strconv.Itoa(payload.RetCode))
 		if resp.StatusCode >= 300 {
 			return fmt.Errorf("status=%d", resp.StatusCode)
 		}
@@ -175,12 +203,12 @@ func getKlineDataByTimeRange(
 	rows := make([]klineRow, 0, len(payload.Result.List))
 	for _, item := range payload.Result.List {
 		if len(item) < 7 {
-			log.Printf("Skipping malformed range kline row for %s: expected at least 7 fields, got %d", symbol, len(item))
+			slog.Warn("skipping malformed range kline row", "symbol", symbol, "fields", len(item))
 			continue
 		}
 		ts, err := strconv.ParseInt(item[0], 10, 64)
 		if err != nil {
-			log.Printf("Skipping range kline row for %s: invalid timestamp %q: %v", symbol, item[0], err)
+			slog.Warn("skipping range kline row with invalid timestamp", "symbol", symbol, "timestamp", item[0], "err", err)
 			continue
 		}
 		op, ok1 := parseFiniteFloat(item[1])
@@ -190,7 +218,7 @@ func getKlineDataByTimeRange(
 		vol, ok5 := parseFiniteFloat(item[5])
 		to, ok6 := parseFiniteFloat(item[6])
 		if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
-			log.Printf("Skipping range kline row for %s: invalid (non-finite) price or volume values", symbol)
+			slog.Warn("skipping range kline row with non-finite price or volume", "symbol", symbol)
 			continue
 		}
 		rows = append(rows, klineRow{TS: ts, Open: op, High: hi, Low: lo, Close: cl, Volume: vol, Turnover: to})
@@ -206,7 +234,18 @@ func parseFiniteFloat(s string) (float64, bool) {
 	return f, true
 }
 
-func runBybitWithRetry(ctx context.Context, operation string, fn func() error) error {
+// runBybitWithRetry calls fn until it succeeds or the retry delays run out.
+// The whole operation and each attempt get their own span; fn receives the
+// attempt's context so its HTTP call is traced beneath it.
+func runBybitWithRetry(ctx context.Context, operation string, fn func(ctx context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, "bybit "+operation, trace.WithAttributes(attribute.String("bybit.operation", operation)))
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	var lastErr error
 	maxAttempts := len(bybitRetryDelays) + 1
 
@@ -215,16 +254,23 @@ func runBybitWithRetry(ctx context.Context, operation string, fn func() error) e
 			return ctx.Err()
 		}
 
-		if err := fn(); err == nil {
+		attemptCtx, attemptSpan := tracer.Start(ctx, "bybit "+operation+" attempt", trace.WithAttributes(attribute.Int("bybit.attempt", attempt)))
+		err := fn(attemptCtx)
+		if err != nil {
+			attemptSpan.RecordError(err)
+			attemptSpan.SetStatus(codes.Error, err.Error())
+		}
+		attemptSpan.End()
+		if err == nil {
 			return nil
-		} else {
-			lastErr = err
 		}
+		lastErr = err
 
 		if attempt == maxAttempts {
 			break
 		}
 
+		metrics.incRetry(operation)
 		wait := bybitRetryDelays[attempt-1]
 		timer := time.NewTimer(wait)
 		select {
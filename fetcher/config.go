@@ -34,11 +34,24 @@ func loadConfig() config {
 		concurrency = 10
 	}
 
+	leaseTTL, _ := strconv.Atoi(getEnv("WRITER_LEASE_TTL_SECONDS", "60"))
+	if leaseTTL <= 0 {
+		leaseTTL = 60
+	}
+
+	readyMaxIntervals, _ := strconv.Atoi(getEnv("READY_MAX_INTERVALS", "3"))
+	if readyMaxIntervals <= 0 {
+		readyMaxIntervals = 3
+	}
+
 	return config{
 		Timeframes:           cleaned,
 		FetchIntervalSeconds: fetchInterval,
 		OHLCVHistoryLimit:    historyLimit,
 		ConcurrencyLimit:     concurrency,
+		LeaseTTLSeconds:      leaseTTL,
+		ReadyMaxIntervals:    readyMaxIntervals,
+		MetricsAddr:          strings.TrimSpace(os.Getenv("METRICS_ADDR")),
 		BaseURL:              getEnv("BYBIT_BASE_URL", "https://api.bybit.com"),
 		DBPath:               getEnv("DB_PATH", "/app/data/cmma.db"),
 	}
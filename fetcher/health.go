@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type timeframeReadiness struct {
+	LastSuccess string  `json:"last_success,omitempty"`
+	AgeSeconds  float64 `json:"age_seconds,omitempty"`
+	Ready       bool    `json:"ready"`
+}
+
+type readinessResponse struct {
+	Status      string                        `json:"status"`
+	WriterLease string                        `json:"writer_lease"`
+	Timeframes  map[string]timeframeReadiness `json:"timeframes"`
+}
+
+// startHealthServer serves /healthz, /readyz and /metrics on cfg.MetricsAddr
+// until ctx is cancelled. It is a no-op when the address is empty.
+func startHealthServer(ctx context.Context, logger *slog.Logger, cfg config) {
+	if cfg.MetricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := buildReadiness(cfg, time.Now())
+		status := http.StatusOK
+		if resp.Status != "ready" {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.writePrometheus(w)
+	})
+
+	server := &http.Server{
+		Addr:              cfg.MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+	go func() {
+		logger.Info("health server started", "addr", cfg.MetricsAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("health server error", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
+
+// buildReadiness reports ready only when every supported timeframe persisted
+// successfully within ReadyMaxIntervals fetch intervals.
+func buildReadiness(cfg config, now time.Time) readinessResponse {
+	resp := readinessResponse{
+		Status:      "ready",
+		WriterLease: "standby",
+		Timeframes:  make(map[string]timeframeReadiness, len(cfg.Timeframes)),
+	}
+	if metrics.isLeaseHeld() {
+		resp.WriterLease = "held"
+	}
+
+	maxAge := time.Duration(cfg.ReadyMaxIntervals*cfg.FetchIntervalSeconds) * time.Second
+	for _, tf := range cfg.Timeframes {
+		if _, ok := timeframeMap[tf]; !ok {
+			continue
+		}
+		entry := timeframeReadiness{}
+		if at, ok := metrics.lastSuccessFor(tf); ok {
+			age := now.Sub(at)
+			entry.LastSuccess = at.UTC().Format(time.RFC3339)
+			entry.AgeSeconds = age.Seconds()
+			entry.Ready = age <= maxAge
+		}
+		if !entry.Ready {
+			resp.Status = "not_ready"
+		}
+		resp.Timeframes[tf] = entry
+	}
+	return resp
+}
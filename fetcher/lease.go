@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	writerLeaseName = "fetcher"
+	// minLeaseTTLSeconds keeps the heartbeat (a third of the TTL) at one
+	// second or more with room for a missed renewal before expiry.
+	minLeaseTTLSeconds = 3
+)
+
+// errLeaseLost aborts a write whose transaction found the lease expired or
+// held by another fetcher.
+var errLeaseLost = errors.New("writer lease lost")
+
+// writerLease is an advisory lock row in the shared database. Only the holder
+// writes OHLCV rows; other fetchers stay in standby until the lease expires.
+type writerLease struct {
+	db     *sql.DB
+	name   string
+	holder string
+	ttl    time.Duration
+}
+
+func newWriterLease(db *sql.DB, ttl time.Duration) *writerLease {
+	return &writerLease{
+		db:     db,
+		name:   writerLeaseName,
+		holder: leaseHolderID(),
+		ttl:    ttl,
+	}
+}
+
+func ensureLeaseTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS writer_lease (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		)
+	`)
+	return err
+}
+
+// tryAcquire takes the lease if it is free or expired, or renews it if this
+// process already holds it. It reports whether the lease is held afterwards.
+func (l *writerLease) tryAcquire(ctx context.Context, now time.Time) (bool, error) {
+	res, err := l.db.ExecContext(ctx, `
+		INSERT INTO writer_lease (name, holder, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			holder=excluded.holder,
+			expires_at=excluded.expires_at
+		WHERE writer_lease.holder = excluded.holder OR writer_lease.expires_at <= ?
+	`, l.name, l.holder, now.Add(l.ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// fence fails with errLeaseLost unless this process still holds an
+// unexpired lease. It runs as the first statement of every write transaction
+// so the check and the writes commit atomically.
+func (l *writerLease) fence(ctx context.Context, tx *sql.Tx) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE writer_lease SET expires_at = expires_at
+		WHERE name = ? AND holder = ? AND expires_at > ?
+	`, l.name, l.holder, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+func (l *writerLease) release() error {
+	_, err := l.db.Exec(`DELETE FROM writer_lease WHERE name = ? AND holder = ?`, l.name, l.holder)
+	return err
+}
+
+func (l *writerLease) currentHolder(ctx context.Context) (string, time.Time, error) {
+	var holder string
+	var expiresAt int64
+	err := l.db.QueryRowContext(ctx, `SELECT holder, expires_at FROM writer_lease WHERE name = ?`, l.name).Scan(&holder, &expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return holder, time.UnixMilli(expiresAt), nil
+}
+
+// acquire blocks in standby until the lease is obtained. The returned context
+// is cancelled as soon as the lease is lost or ctx is done, so an in-flight
+// cycle stops writing before another fetcher can take over.
+func (l *writerLease) acquire(ctx context.Context, logger *slog.Logger) (context.Context, context.CancelFunc, error) {
+	heartbeat := l.heartbeatInterval()
+	loggedStandby := false
+
+	for {
+		held, err := l.tryAcquire(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			logger.Error("writer lease acquire error", "err", err)
+		}
+		if held {
+			break
+		}
+		if !loggedStandby && err == nil {
+			if holder, expiresAt, herr := l.currentHolder(ctx); herr == nil {
+				logger.Info("standby: writer lease held by another fetcher", "holder", holder, "expires_at", expiresAt.UTC().Format(time.RFC3339))
+			}
+			loggedStandby = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(heartbeat):
+		}
+	}
+	logger.Info("writer lease acquired", "holder", l.holder, "ttl", l.ttl.String())
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	go l.keepAlive(leaseCtx, cancel, logger, time.Now().Add(l.ttl))
+	return leaseCtx, cancel, nil
+}
+
+func (l *writerLease) keepAlive(ctx context.Context, cancel context.CancelFunc, logger *slog.Logger, expiresAt time.Time) {
+	defer cancel()
+	ticker := time.NewTicker(l.heartbeatInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		held, err := l.tryAcquire(ctx, now)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			// A transient error (e.g. SQLITE_BUSY) does not lose the lease by
+			// itself; give up only once it can no longer be renewed in time.
+			logger.Warn("writer lease renew error", "err", err)
+			if !now.Before(expiresAt) {
+				logger.Error("writer lease expired while renewal was failing")
+				return
+			}
+		case !held:
+			logger.Warn("writer lease taken over by another fetcher")
+			return
+		default:
+			expiresAt = now.Add(l.ttl)
+		}
+	}
+}
+
+func (l *writerLease) heartbeatInterval() time.Duration {
+	interval := l.ttl / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+func leaseHolderID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	buf := make([]byte, 4)
+	_, _ = rand.Read(buf)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(buf))
+}
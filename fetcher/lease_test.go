@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openLeaseTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := openSQLite(filepath.Join(t.TempDir(), "lease.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := ensureLeaseTable(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestWriterLeaseAcquireRenewTakeoverRelease(t *testing.T) {
+	db := openLeaseTestDB(t)
+	ctx := context.Background()
+	a := &writerLease{db: db, name: writerLeaseName, holder: "a", ttl: 10 * time.Second}
+	b := &writerLease{db: db, name: writerLeaseName, holder: "b", ttl: 10 * time.Second}
+	now := time.UnixMilli(1_700_000_000_000)
+
+	if held, err := a.tryAcquire(ctx, now); err != nil || !held {
+		t.Fatalf("a acquire = %v, %v", held, err)
+	}
+	if held, err := b.tryAcquire(ctx, now.Add(time.Second)); err != nil || held {
+		t.Fatalf("b acquired a live lease: %v, %v", held, err)
+	}
+	if held, err := a.tryAcquire(ctx, now.Add(5*time.Second)); err != nil || !held {
+		t.Fatalf("a renew = %v, %v", held, err)
+	}
+	if _, expiresAt, err := a.currentHolder(ctx); err != nil || !expiresAt.Equal(now.Add(15*time.Second)) {
+		t.Fatalf("renewed expiry = %v, %v", expiresAt, err)
+	}
+
+	if held, err := b.tryAcquire(ctx, now.Add(14*time.Second)); err != nil || held {
+		t.Fatalf("b took over before expiry: %v, %v", held, err)
+	}
+	if held, err := b.tryAcquire(ctx, now.Add(15*time.Second)); err != nil || !held {
+		t.Fatalf("b takeover after expiry = %v, %v", held, err)
+	}
+	if holder, _, err := a.currentHolder(ctx); err != nil || holder != "b" {
+		t.Fatalf("holder = %q, %v", holder, err)
+	}
+
+	// Releasing a lease held by someone else is a no-op.
+	if err := a.release(); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _, err := a.currentHolder(ctx); err != nil || holder != "b" {
+		t.Fatalf("holder after foreign release = %q, %v", holder, err)
+	}
+	if err := b.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.currentHolder(ctx); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("lease row after release: %v", err)
+	}
+}
+
+func TestWriterLeaseFencesWrites(t *testing.T) {
+	db := openLeaseTestDB(t)
+	ctx := context.Background()
+	if err := ensureTables(db, []string{"1h"}); err != nil {
+		t.Fatal(err)
+	}
+	a := &writerLease{db: db, name: writerLeaseName, holder: "a", ttl: time.Minute}
+	b := &writerLease{db: db, name: writerLeaseName, holder: "b", ttl: time.Minute}
+	rows := map[string][]klineRow{"BTCUSDT": {{TS: 1, Close: 1}}}
+
+	if err := upsertRows(ctx, db, a, "1h", rows); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("write without a lease = %v, want errLeaseLost", err)
+	}
+	if _, err := a.tryAcquire(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := upsertRows(ctx, db, a, "1h", rows); err != nil {
+		t.Fatalf("write while holding the lease: %v", err)
+	}
+
+	// b takes over once a's lease has expired; a's next write is refused.
+	if _, err := b.tryAcquire(ctx, time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanupOldRows(ctx, db, a, "1h", 1); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("write after takeover = %v, want errLeaseLost", err)
+	}
+}
@@ -4,61 +4,124 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
 	cfg := loadConfig()
-	logger := log.New(os.Stdout, "", log.LstdFlags)
+	logger := newLogger(getEnv("LOG_LEVEL", "info"))
+	slog.SetDefault(logger)
+	if cfg.LeaseTTLSeconds < minLeaseTTLSeconds {
+		logger.Error("WRITER_LEASE_TTL_SECONDS is too small", "value", cfg.LeaseTTLSeconds, "min", minLeaseTTLSeconds)
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), "fetcher")
+	if err != nil {
+		logger.Error("tracing setup failed", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("tracing shutdown failed", "err", err)
+		}
+	}()
 
-	db, err := sql.Open("sqlite", cfg.DBPath)
+	db, err := openSQLite(cfg.DBPath)
 	if err != nil {
-		logger.Fatalf("db open failed: %v", err)
+		logger.Error("db open failed", "err", err)
+		os.Exit(1)
 	}
 	defer db.Close()
 
 	if err := configureSQLiteForWriter(db); err != nil {
-		logger.Fatalf("set sqlite pragma failed: %v", err)
+		logger.Error("set sqlite pragma failed", "err", err)
+		os.Exit(1)
 	}
 
 	if err := ensureTables(db, cfg.Timeframes); err != nil {
-		logger.Fatalf("ensure tables failed: %v", err)
+		logger.Error("ensure tables failed", "err", err)
+		os.Exit(1)
 	}
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+	if err := ensureLeaseTable(db); err != nil {
+		logger.Error("ensure lease table failed", "err", err)
+		os.Exit(1)
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	logger.Printf("fetcher started, timeframes=%v interval=%ds", cfg.Timeframes, cfg.FetchIntervalSeconds)
+	lease := newWriterLease(db, time.Duration(cfg.LeaseTTLSeconds)*time.Second)
+	defer func() {
+		if err := lease.release(); err != nil {
+			logger.Error("writer lease release failed", "err", err)
+		}
+	}()
+
+	startHealthServer(ctx, logger, cfg)
+
+	logger.Info("fetcher started", "timeframes", cfg.Timeframes, "interval_seconds", cfg.FetchIntervalSeconds)
+
+	for {
+		leaseCtx, cancel, err := lease.acquire(ctx, logger)
+		if err != nil {
+			logger.Info("fetcher stopped")
+			return
+		}
+		metrics.setLeaseHeld(true)
+		runCycles(leaseCtx, logger, httpClient, db, lease, cfg)
+		cancel()
+		metrics.setLeaseHeld(false)
+		if ctx.Err() != nil {
+			logger.Info("fetcher stopped")
+			return
+		}
+		logger.Warn("writer lease lost, returning to standby")
+	}
+}
+
+// runCycles fetches and stores on every interval until ctx is cancelled,
+// either by shutdown or by losing the writer lease.
+func runCycles(ctx context.Context, logger *slog.Logger, httpClient *http.Client, db *sql.DB, lease *writerLease, cfg config) {
 	firstCycle := true
 
 	for {
 		start := time.Now()
-		if err := fetchAndStore(ctx, logger, httpClient, db, cfg, firstCycle); err != nil && !errors.Is(err, context.Canceled) {
-			logger.Printf("fetch cycle error: %v", err)
+		cycleCtx, span := tracer.Start(ctx, "fetch cycle", trace.WithAttributes(attribute.Bool("fetch.first_cycle", firstCycle)))
+		err := fetchAndStore(cycleCtx, logger, httpClient, db, lease, cfg, firstCycle)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			span.SetStatus(codes.Error, err.Error())
+			logger.Error("fetch cycle error", "err", err)
 		}
+		span.End()
 		firstCycle = false
-		if ctx.Err() != nil {
-			logger.Printf("fetcher stopped")
+		if ctx.Err() != nil || errors.Is(err, errLeaseLost) {
 			return
 		}
 
 		elapsed := time.Since(start)
+		metrics.observeCycle(elapsed)
 		wait := time.Duration(cfg.FetchIntervalSeconds) * time.Second
 
-		logger.Printf("cycle complete in %.2fs, waiting %.2fs", elapsed.Seconds(), wait.Seconds())
+		logger.Info("cycle complete", "elapsed_seconds", elapsed.Seconds(), "wait_seconds", wait.Seconds())
 
 		select {
 		case <-ctx.Done():
-			logger.Printf("fetcher stopped")
 			return
 		case <-time.After(wait):
 		}
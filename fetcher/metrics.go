@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	cycleDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200}
+	dbWriteBuckets       = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	metrics = newFetcherMetrics()
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type requestKey struct {
+	endpoint string
+	retCode  string
+}
+
+// fetcherMetrics collects counters for the /metrics and /readyz endpoints.
+// It is a process-wide registry so the Bybit client and the repository can
+// record without threading it through every call.
+type fetcherMetrics struct {
+	mu sync.Mutex
+
+	startedAt     time.Time
+	cycleDuration *histogram
+	requests      map[requestKey]uint64
+	retries       map[string]uint64
+	rowsUpserted  map[string]uint64
+	gapsFound     map[string]uint64
+	gapsFilled    map[string]uint64
+	dbWrite       map[string]*histogram
+	lastSuccess   map[string]time.Time
+	leaseHeld     bool
+}
+
+func newFetcherMetrics() *fetcherMetrics {
+	return &fetcherMetrics{
+		startedAt:     time.Now(),
+		cycleDuration: newHistogram(cycleDurationBuckets),
+		requests:      make(map[requestKey]uint64),
+		retries:       make(map[string]uint64),
+		rowsUpserted:  make(map[string]uint64),
+		gapsFound:     make(map[string]uint64),
+		gapsFilled:    make(map[string]uint64),
+		dbWrite:       make(map[string]*histogram),
+		lastSuccess:   make(map[string]time.Time),
+	}
+}
+
+func (m *fetcherMetrics) observeCycle(d time.Duration) {
+	m.mu.Lock()
+	m.cycleDuration.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+// observeRequest records one Bybit HTTP call. retCode is the Bybit retCode,
+// or "error" when no response body could be decoded.
+func (m *fetcherMetrics) observeRequest(endpoint, retCode string) {
+	m.mu.Lock()
+	m.requests[requestKey{endpoint: endpoint, retCode: retCode}]++
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) incRetry(endpoint string) {
+	m.mu.Lock()
+	m.retries[endpoint]++
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) addRowsUpserted(timeframe string, n int) {
+	m.mu.Lock()
+	m.rowsUpserted[timeframe] += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) addGaps(timeframe string, found, filled int) {
+	m.mu.Lock()
+	m.gapsFound[timeframe] += uint64(found)
+	m.gapsFilled[timeframe] += uint64(filled)
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) observeDBWrite(operation string, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	m.mu.Lock()
+	h, ok := m.dbWrite[operation]
+	if !ok {
+		h = newHistogram(dbWriteBuckets)
+		m.dbWrite[operation] = h
+	}
+	h.observe(elapsed)
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) markTimeframeSuccess(timeframe string, at time.Time) {
+	m.mu.Lock()
+	m.lastSuccess[timeframe] = at
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) setLeaseHeld(held bool) {
+	m.mu.Lock()
+	m.leaseHeld = held
+	m.mu.Unlock()
+}
+
+func (m *fetcherMetrics) lastSuccessFor(timeframe string) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at, ok := m.lastSuccess[timeframe]
+	return at, ok
+}
+
+func (m *fetcherMetrics) isLeaseHeld() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leaseHeld
+}
+
+// writePrometheus renders all metrics in the Prometheus text exposition format.
+func (m *fetcherMetrics) writePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "fetcher_uptime_seconds", "gauge", "Seconds since the fetcher process started.")
+	fmt.Fprintf(w, "fetcher_uptime_seconds %g\n", time.Since(m.startedAt).Seconds())
+
+	leaseHeld := 0
+	if m.leaseHeld {
+		leaseHeld = 1
+	}
+	writeHeader(w, "fetcher_writer_lease_held", "gauge", "1 if this process holds the writer lease.")
+	fmt.Fprintf(w, "fetcher_writer_lease_held %d\n", leaseHeld)
+
+	writeHeader(w, "fetcher_cycle_duration_seconds", "histogram", "Duration of fetch cycles.")
+	writeHistogram(w, "fetcher_cycle_duration_seconds", "", m.cycleDuration)
+
+	writeHeader(w, "fetcher_bybit_requests_total", "counter", "Bybit API requests by endpoint and retCode.")
+	reqKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		if reqKeys[i].endpoint == reqKeys[j].endpoint {
+			return reqKeys[i].retCode < reqKeys[j].retCode
+		}
+		return reqKeys[i].endpoint < reqKeys[j].endpoint
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(w, "fetcher_bybit_requests_total{endpoint=%q,ret_code=%q} %d\n", k.endpoint, k.retCode, m.requests[k])
+	}
+
+	writeHeader(w, "fetcher_bybit_retries_total", "counter", "Bybit API retries by endpoint.")
+	writeCounterVec(w, "fetcher_bybit_retries_total", "endpoint", m.retries)
+
+	writeHeader(w, "fetcher_rows_upserted_total", "counter", "OHLCV rows upserted by timeframe.")
+	writeCounterVec(w, "fetcher_rows_upserted_total", "timeframe", m.rowsUpserted)
+
+	writeHeader(w, "fetcher_gaps_found_total", "counter", "Missing candle timestamps detected by timeframe.")
+	writeCounterVec(w, "fetcher_gaps_found_total", "timeframe", m.gapsFound)
+
+	writeHeader(w, "fetcher_gaps_filled_total", "counter", "Missing candles filled by timeframe.")
+	writeCounterVec(w, "fetcher_gaps_filled_total", "timeframe", m.gapsFilled)
+
+	writeHeader(w, "fetcher_db_write_duration_seconds", "histogram", "Latency of database write transactions by operation.")
+	for _, op := range sortedKeys(m.dbWrite) {
+		writeHistogram(w, "fetcher_db_write_duration_seconds", fmt.Sprintf("operation=%q", op), m.dbWrite[op])
+	}
+
+	writeHeader(w, "fetcher_last_success_timestamp_seconds", "gauge", "Unix time of the last successful persist by timeframe.")
+	for _, tf := range sortedKeys(m.lastSuccess) {
+		fmt.Fprintf(w, "fetcher_last_success_timestamp_seconds{timeframe=%q} %d\n", tf, m.lastSuccess[tf].Unix())
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounterVec(w io.Writer, name, label string, values map[string]uint64) {
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, prefix, fmt.Sprintf("%g", upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+	if labels == "" {
+		fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+		return
+	}
+	fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", name, labels, h.sum, name, labels, h.count)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
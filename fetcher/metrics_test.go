@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheusFormat(t *testing.T) {
+	m := newFetcherMetrics()
+	m.setLeaseHeld(true)
+	m.observeCycle(3 * time.Second)
+	m.observeRequest("/v5/market/kline", "0")
+	m.observeRequest("/v5/market/kline", "0")
+	m.incRetry("/v5/market/kline")
+	m.addRowsUpserted("1h", 5)
+	m.markTimeframeSuccess("1h", time.Unix(1_700_000_000, 0))
+
+	var out strings.Builder
+	m.writePrometheus(&out)
+	text := out.String()
+
+	for _, want := range []string{
+		"# TYPE fetcher_writer_lease_held gauge\nfetcher_writer_lease_held 1\n",
+		`fetcher_cycle_duration_seconds_bucket{le="1"} 0` + "\n",
+		`fetcher_cycle_duration_seconds_bucket{le="5"} 1` + "\n",
+		`fetcher_cycle_duration_seconds_bucket{le="+Inf"} 1` + "\n",
+		"fetcher_cycle_duration_seconds_sum 3\nfetcher_cycle_duration_seconds_count 1\n",
+		`fetcher_bybit_requests_total{endpoint="/v5/market/kline",ret_code="0"} 2` + "\n",
+		`fetcher_bybit_retries_total{endpoint="/v5/market/kline"} 1` + "\n",
+		`fetcher_rows_upserted_total{timeframe="1h"} 5` + "\n",
+		`fetcher_last_success_timestamp_seconds{timeframe="1h"} 1700000000` + "\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if !strings.HasPrefix(line, "# ") && len(strings.Fields(line)) != 2 {
+			t.Errorf("malformed sample line %q", line)
+		}
+	}
+}
+
+func TestBuildReadinessStaleness(t *testing.T) {
+	saved := metrics
+	defer func() { metrics = saved }()
+	metrics = newFetcherMetrics()
+
+	now := time.Unix(1_700_000_000, 0)
+	cfg := config{Timeframes: []string{"1h", "4h", "bogus"}, FetchIntervalSeconds: 60, ReadyMaxIntervals: 3}
+
+	if got := buildReadiness(cfg, now); got.Status != "not_ready" || got.WriterLease != "standby" || len(got.Timeframes) != 2 {
+		t.Fatalf("before any success = %+v", got)
+	}
+
+	metrics.markTimeframeSuccess("1h", now.Add(-180*time.Second))
+	metrics.markTimeframeSuccess("4h", now.Add(-181*time.Second))
+	got := buildReadiness(cfg, now)
+	if !got.Timeframes["1h"].Ready || got.Timeframes["4h"].Ready || got.Status != "not_ready" {
+		t.Fatalf("at the staleness edge = %+v", got)
+	}
+
+	metrics.markTimeframeSuccess("4h", now.Add(-time.Minute))
+	metrics.setLeaseHeld(true)
+	if got := buildReadiness(cfg, now); got.Status != "ready" || got.WriterLease != "held" {
+		t.Fatalf("all fresh = %+v", got)
+	}
+}
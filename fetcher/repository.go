@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -31,23 +32,56 @@ func ensureTables(db *sql.DB, timeframes []string) error {
 		if _, err := db.Exec(query); err != nil {
 			return err
 		}
+		// The API's incremental cache refresh filters by timestamp alone.
+		index := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_timestamp ON %s (timestamp)`, tableName, tableName)
+		if _, err := db.Exec(index); err != nil {
+			return err
+		}
 	}
-	return nil
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS instruments (
+			symbol TEXT PRIMARY KEY,
+			contract_type TEXT NOT NULL,
+			status TEXT NOT NULL,
+			base_coin TEXT NOT NULL,
+			quote_coin TEXT NOT NULL,
+			launch_time INTEGER NOT NULL,
+			tick_size REAL NOT NULL,
+			qty_step REAL NOT NULL,
+			min_order_qty REAL NOT NULL,
+			updated_at INTEGER NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS data_version (
+			timeframe TEXT PRIMARY KEY,
+			version INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	return err
 }
 
-func upsertRows(db *sql.DB, timeframe string, rowsBySymbol map[string][]klineRow) error {
+func upsertRows(ctx context.Context, db *sql.DB, lease *writerLease, timeframe string, rowsBySymbol map[string][]klineRow) error {
 	tableName, err := safeTableName(timeframe)
 	if err != nil {
 		return err
 	}
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
+	if err := lease.fence(ctx, tx); err != nil {
+		return err
+	}
 
-	stmt, err := tx.Prepare(fmt.Sprintf(`
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`
 		INSERT INTO %s (symbol, timestamp, open, high, low, close, volume, turnover)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(symbol, timestamp) DO UPDATE SET
@@ -65,26 +99,70 @@ func upsertRows(db *sql.DB, timeframe string, rowsBySymbol map[string][]klineRow
 
 	for symbol, rows := range rowsBySymbol {
 		for _, row := range rows {
-			if _, err := stmt.Exec(symbol, row.TS, row.Open, row.High, row.Low, row.Close, row.Volume, row.Turnover); err != nil {
+			if _, err := stmt.ExecContext(ctx, symbol, row.TS, row.Open, row.High, row.Low, row.Close, row.Volume, row.Turnover); err != nil {
 				return err
 			}
 		}
 	}
+	if err := bumpDataVersion(ctx, tx, timeframe); err != nil {
+		return err
+	}
 
 	return tx.Commit()
 }
 
-func cleanupOldRows(db *sql.DB, timeframe string, historyLimit int) error {
+func upsertInstruments(ctx context.Context, db *sql.DB, lease *writerLease, instruments []instrumentInfo) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := lease.fence(ctx, tx); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO instruments (symbol, contract_type, status, base_coin, quote_coin, launch_time, tick_size, qty_step, min_order_qty, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(symbol) DO UPDATE SET
+			contract_type=excluded.contract_type,
+			status=excluded.status,
+			base_coin=excluded.base_coin,
+			quote_coin=excluded.quote_coin,
+			launch_time=excluded.launch_time,
+			tick_size=excluded.tick_size,
+			qty_step=excluded.qty_step,
+			min_order_qty=excluded.min_order_qty,
+			updated_at=excluded.updated_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	for _, inst := range instruments {
+		if _, err := stmt.ExecContext(ctx, inst.Symbol, inst.ContractType, inst.Status, inst.BaseCoin, inst.QuoteCoin, inst.LaunchTime, inst.TickSize, inst.QtyStep, inst.MinOrderQty, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func cleanupOldRows(ctx context.Context, db *sql.DB, lease *writerLease, timeframe string, historyLimit int) error {
 	tableName, err := safeTableName(timeframe)
 	if err != nil {
 		return err
 	}
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
+	if err := lease.fence(ctx, tx); err != nil {
+		return err
+	}
 
 	query := fmt.Sprintf(`
 		DELETE FROM %s
@@ -99,27 +177,47 @@ func cleanupOldRows(db *sql.DB, timeframe string, historyLimit int) error {
 			WHERE rn > ?
 		)
 	`, tableName, tableName)
-	if _, err := tx.Exec(query, historyLimit); err != nil {
+	res, err := tx.ExecContext(ctx, query, historyLimit)
+	if err != nil {
 		return err
 	}
+	if deleted, err := res.RowsAffected(); err == nil && deleted > 0 {
+		if err := bumpDataVersion(ctx, tx, timeframe); err != nil {
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
 
-func timeframeHasRows(db *sql.DB, timeframe string) (bool, error) {
+// bumpDataVersion increments the per-timeframe version inside the writing
+// transaction so readers observe the new version together with the rows.
+func bumpDataVersion(ctx context.Context, tx *sql.Tx, timeframe string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO data_version (timeframe, version, updated_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT(timeframe) DO UPDATE SET
+			version=data_version.version + 1,
+			updated_at=excluded.updated_at
+	`, timeframe, time.Now().UnixMilli())
+	return err
+}
+
+func timeframeHasRows(ctx context.Context, db *sql.DB, timeframe string) (bool, error) {
 	tableName, err := safeTableName(timeframe)
 	if err != nil {
 		return false, err
 	}
 	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s LIMIT 1)`, tableName)
 	var exists int
-	if err := db.QueryRow(query).Scan(&exists); err != nil {
+	if err := db.QueryRowContext(ctx, query).Scan(&exists); err != nil {
 		return false, err
 	}
 	return exists == 1, nil
 }
 
 func detectMissingTimestamps(
+	ctx context.Context,
 	db *sql.DB,
 	timeframe string,
 	historyLimit int,
@@ -153,7 +251,7 @@ func detectMissingTimestamps(
 		ORDER BY symbol ASC, timestamp DESC
 	`, tableName)
 
-	rows, err := db.Query(query, historyLimit)
+	rows, err := db.QueryContext(ctx, query, historyLimit)
 	if err != nil {
 		return nil, err
 	}
@@ -5,33 +5,46 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-func fetchAndStore(ctx context.Context, logger *log.Logger, httpClient *http.Client, db *sql.DB, cfg config, fillStartupGaps bool) error {
-	symbols, err := getAllLinearSymbols(ctx, httpClient, cfg.BaseURL)
+func fetchAndStore(ctx context.Context, logger *slog.Logger, httpClient *http.Client, db *sql.DB, lease *writerLease, cfg config, fillStartupGaps bool) error {
+	instruments, err := getAllLinearInstruments(ctx, httpClient, cfg.BaseURL)
 	if err != nil {
 		return err
 	}
-	if len(symbols) == 0 {
+	if len(instruments) == 0 {
 		return errors.New("no symbols returned from bybit")
 	}
-	logger.Printf("found %d symbols", len(symbols))
+	symbols := make([]string, 0, len(instruments))
+	for _, inst := range instruments {
+		symbols = append(symbols, inst.Symbol)
+	}
+	logger.Info("found symbols", "count", len(symbols))
+
+	instrumentsStart := time.Now()
+	if err := upsertInstruments(ctx, db, lease, instruments); err != nil {
+		// Metadata is informational only; keep collecting candles.
+		logger.Error("upsert instruments error", "err", err)
+	} else {
+		metrics.observeDBWrite("instruments", instrumentsStart)
+	}
 
 	for _, timeframe := range cfg.Timeframes {
 		interval, ok := timeframeMap[timeframe]
 		if !ok {
-			logger.Printf("skip unsupported timeframe: %s", timeframe)
+			logger.Warn("skip unsupported timeframe", "timeframe", timeframe)
 			continue
 		}
 
-		hasRows, err := timeframeHasRows(db, timeframe)
+		hasRows, err := timeframeHasRows(ctx, db, timeframe)
 		if err != nil {
 			return fmt.Errorf("check timeframe rows %s: %w", timeframe, err)
 		}
@@ -40,7 +53,7 @@ func fetchAndStore(ctx context.Context, logger *log.Logger, httpClient *http.Cli
 			fetchLimit = dynamicIncrementalFetchLimit(cfg.FetchIntervalSeconds, timeframe, cfg.OHLCVHistoryLimit)
 		}
 
-		logger.Printf("timeframe %s: fetching (limit=%d)", timeframe, fetchLimit)
+		logger.Info("fetching timeframe", "timeframe", timeframe, "limit", fetchLimit)
 		results := make(map[string][]klineRow, len(symbols))
 		var mu sync.Mutex
 		var wg sync.WaitGroup
@@ -53,7 +66,7 @@ func fetchAndStore(ctx context.Context, logger *log.Logger, httpClient *http.Cli
 				defer wg.Done()
 				defer func() {
 					if r := recover(); r != nil {
-						logger.Printf("panic in kline fetch goroutine symbol=%s tf=%s: %v", s, timeframe, r)
+						logger.Error("panic in kline fetch goroutine", "symbol", s, "timeframe", timeframe, "panic", fmt.Sprint(r))
 					}
 				}()
 				select {
@@ -65,7 +78,7 @@ func fetchAndStore(ctx context.Context, logger *log.Logger, httpClient *http.Cli
 
 				rows, fetchErr := getKlineData(ctx, httpClient, cfg.BaseURL, s, interval, fetchLimit)
 				if fetchErr != nil {
-					logger.Printf("kline error symbol=%s tf=%s: %v", s, timeframe, fetchErr)
+					logger.Warn("kline error", "symbol", s, "timeframe", timeframe, "err", fetchErr)
 					return
 				}
 				if len(rows) == 0 {
@@ -80,27 +93,36 @@ func fetchAndStore(ctx context.Context, logger *log.Logger, httpClient *http.Cli
 		wg.Wait()
 
 		if len(results) == 0 {
-			logger.Printf("timeframe %s: no rows fetched", timeframe)
+			logger.Warn("no rows fetched", "timeframe", timeframe)
 		} else {
-			if err := upsertRows(db, timeframe, results); err != nil {
+			upsertStart := time.Now()
+			if err := upsertRows(ctx, db, lease, timeframe, results); err != nil {
 				return fmt.Errorf("upsert timeframe %s: %w", timeframe, err)
 			}
-			logger.Printf("timeframe %s: persisted symbols=%d", timeframe, len(results))
+			metrics.observeDBWrite("upsert", upsertStart)
+			metrics.addRowsUpserted(timeframe, countRows(results))
+			logger.Info("timeframe persisted", "timeframe", timeframe, "symbols", len(results))
 		}
-		if err := cleanupOldRows(db, timeframe, cfg.OHLCVHistoryLimit); err != nil {
+		cleanupStart := time.Now()
+		if err := cleanupOldRows(ctx, db, lease, timeframe, cfg.OHLCVHistoryLimit); err != nil {
 			return fmt.Errorf("cleanup timeframe %s: %w", timeframe, err)
 		}
+		metrics.observeDBWrite("cleanup", cleanupStart)
+		if len(results) > 0 {
+			metrics.markTimeframeSuccess(timeframe, time.Now())
+		}
 
 		if !fillStartupGaps {
 			continue
 		}
 
-		filledRows, missingPoints, err := backfillMissingByTimestamp(ctx, logger, httpClient, db, cfg, timeframe, interval, symbols)
+		filledRows, missingPoints, err := backfillMissingByTimestamp(ctx, logger, httpClient, db, lease, cfg, timeframe, interval, symbols)
 		if err != nil {
 			return fmt.Errorf("backfill missing timeframe %s: %w", timeframe, err)
 		}
+		metrics.addGaps(timeframe, missingPoints, filledRows)
 		if missingPoints > 0 {
-			logger.Printf("timeframe %s: startup gap backfill complete missing_timestamps=%d filled_rows=%d", timeframe, missingPoints, filledRows)
+			logger.Info("startup gap backfill complete", "timeframe", timeframe, "missing_timestamps", missingPoints, "filled_rows", filledRows)
 		}
 	}
 
@@ -115,9 +137,10 @@ type missingTimeRange struct {
 
 func backfillMissingByTimestamp(
 	ctx context.Context,
-	logger *log.Logger,
+	logger *slog.Logger,
 	httpClient *http.Client,
 	db *sql.DB,
+	lease *writerLease,
 	cfg config,
 	timeframe string,
 	interval string,
@@ -129,7 +152,7 @@ func backfillMissingByTimestamp(
 	}
 	stepMs := int64(stepSeconds) * 1000
 
-	missingBySymbol, err := detectMissingTimestamps(db, timeframe, cfg.OHLCVHistoryLimit, stepMs, symbols)
+	missingBySymbol, err := detectMissingTimestamps(ctx, db, timeframe, cfg.OHLCVHistoryLimit, stepMs, symbols)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -155,7 +178,7 @@ func backfillMissingByTimestamp(
 			defer wg.Done()
 			defer func() {
 				if r := recover(); r != nil {
-					logger.Printf("panic in gap fill goroutine symbol=%s tf=%s: %v", s, timeframe, r)
+					logger.Error("panic in gap fill goroutine", "symbol", s, "timeframe", timeframe, "panic", fmt.Sprint(r))
 				}
 			}()
 			select {
@@ -167,7 +190,7 @@ func backfillMissingByTimestamp(
 
 			rows, fetchErr := fetchMissingRowsForSymbol(ctx, httpClient, cfg.BaseURL, s, interval, targetTS, stepMs)
 			if fetchErr != nil {
-				logger.Printf("gap fill error symbol=%s tf=%s: %v", s, timeframe, fetchErr)
+				logger.Warn("gap fill error", "symbol", s, "timeframe", timeframe, "err", fetchErr)
 				return
 			}
 			if len(rows) == 0 {
@@ -181,20 +204,22 @@ func backfillMissingByTimestamp(
 	}
 	wg.Wait()
 
-	filledRows := 0
-	for _, rows := range filled {
-		filledRows += len(rows)
-	}
+	filledRows := countRows(filled)
 	if filledRows == 0 {
 		return 0, totalMissing, nil
 	}
 
-	if err := upsertRows(db, timeframe, filled); err != nil {
+	upsertStart := time.Now()
+	if err := upsertRows(ctx, db, lease, timeframe, filled); err != nil {
 		return 0, totalMissing, err
 	}
-	if err := cleanupOldRows(db, timeframe, cfg.OHLCVHistoryLimit); err != nil {
+	metrics.observeDBWrite("upsert", upsertStart)
+	metrics.addRowsUpserted(timeframe, filledRows)
+	cleanupStart := time.Now()
+	if err := cleanupOldRows(ctx, db, lease, timeframe, cfg.OHLCVHistoryLimit); err != nil {
 		return 0, totalMissing, err
 	}
+	metrics.observeDBWrite("cleanup", cleanupStart)
 
 	return filledRows, totalMissing, nil
 }
@@ -323,6 +348,14 @@ func timeframeToSeconds(s string) (int, error) {
 	}
 }
 
+func countRows(rowsBySymbol map[string][]klineRow) int {
+	total := 0
+	for _, rows := range rowsBySymbol {
+		total += len(rows)
+	}
+	return total
+}
+
 func minInt(a, b int) int {
 	if a < b {
 		return a
@@ -15,8 +15,11 @@ type config struct {
 	FetchIntervalSeconds int
 	OHLCVHistoryLimit    int
 	ConcurrencyLimit     int
+	LeaseTTLSeconds      int
+	ReadyMaxIntervals    int
 	BaseURL              string
 	DBPath               string
+	MetricsAddr          string
 }
 
 type bybitInstrumentsResp struct {
@@ -24,7 +27,19 @@ type bybitInstrumentsResp struct {
 	RetMsg  string `json:"retMsg"`
 	Result  struct {
 		List []struct {
-			Symbol string `json:"symbol"`
+			Symbol       string `json:"symbol"`
+			ContractType string `json:"contractType"`
+			Status       string `json:"status"`
+			BaseCoin     string `json:"baseCoin"`
+			QuoteCoin    string `json:"quoteCoin"`
+			LaunchTime   string `json:"launchTime"`
+			PriceFilter  struct {
+				TickSize string `json:"tickSize"`
+			} `json:"priceFilter"`
+			LotSizeFilter struct {
+				QtyStep     string `json:"qtyStep"`
+				MinOrderQty string `json:"minOrderQty"`
+			} `json:"lotSizeFilter"`
 		} `json:"list"`
 		NextPageCursor string `json:"nextPageCursor"`
 	} `json:"result"`
@@ -47,3 +62,15 @@ type klineRow struct {
 	Volume   float64
 	Turnover float64
 }
+
+type instrumentInfo struct {
+	Symbol       string
+	ContractType string
+	Status       string
+	BaseCoin     string
+	QuoteCoin    string
+	LaunchTime   int64
+	TickSize     float64
+	QtyStep      float64
+	MinOrderQty  float64
+}
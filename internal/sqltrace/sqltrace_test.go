@@ -0,0 +1,51 @@
+package sqltrace
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSQLSpansFollowParent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	db, err := Open(filepath.Join(t.TempDir(), "trace.db"), provider.Tracer("sqltrace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE t (v INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	if _, err := db.ExecContext(ctx, `INSERT INTO t (v) VALUES (?)`, 1); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := db.QueryRowContext(ctx, `SELECT   v
+		FROM t`).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want INSERT, SELECT and parent (untraced CREATE)", len(spans))
+	}
+	for i, want := range []string{"INSERT", "SELECT"} {
+		s := spans[i]
+		if s.Name() != want || s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("span %d = %s parent=%s, want %s under parent", i, s.Name(), s.Parent().SpanID(), want)
+		}
+	}
+	for _, attr := range spans[1].Attributes() {
+		if attr.Key == "db.query.text" && attr.Value.AsString() != "SELECT v FROM t" {
+			t.Fatalf("db.query.text = %q", attr.Value.AsString())
+		}
+	}
+}